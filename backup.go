@@ -0,0 +1,65 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Backup copies all the segment files of the WAL to destDir, which can be opened as a WAL
+// with the same options except DirPath. The active segment file is flushed and synced first,
+// and copied up to its current size, so the backup has every entry written before it is called.
+// The start position set by TrimFront is copied too, so the trimmed entries stay trimmed.
+//
+// The WAL lock is held during the copy, so the writes are blocked until it is done.
+// The segment files are created in destDir by options.FileSystem, and it fails
+// if any of them exists. The segment files loaded by options.SegmentLoader are not copied.
+func (wal *WAL) Backup(destDir string) error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	if wal.olderSegments == nil {
+		return ErrClosed
+	}
+	if err := wal.syncActiveSegment(); err != nil {
+		return err
+	}
+	if err := wal.options.FileSystem.MkdirAll(destDir, wal.options.DirMode); err != nil {
+		return err
+	}
+
+	for _, id := range append(wal.sortedOlderSegmentIDs(), wal.activeSegment.id) {
+		seg := wal.findSegment(id)
+		if err := wal.copySegment(seg, destDir); err != nil {
+			return fmt.Errorf("backup segment file %d%s failed: %w", id, wal.options.SegmentFileExt, err)
+		}
+	}
+	if wal.trimStart != nil {
+		path := filepath.Join(destDir, trimFileName)
+		if err := writePositionFile(wal.options.FileSystem, path, wal.options.FileMode, wal.trimStart); err != nil {
+			return fmt.Errorf("backup the trim file failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// copySegment copies the data of the segment file visible to the readers
+// to the segment file with the same name in destDir, and syncs it.
+func (wal *WAL) copySegment(seg *segment, destDir string) error {
+	name := segmentFilePath(&wal.options, destDir, wal.options.SegmentFileExt, seg.id)
+	dest, err := wal.options.FileSystem.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, wal.options.FileMode)
+	if err != nil {
+		return err
+	}
+	src := io.NewSectionReader(seg.readFd, 0, seg.writtenSize.Load())
+	if _, err := io.Copy(dest, src); err != nil {
+		_ = dest.Close()
+		return err
+	}
+	if err := dest.Sync(); err != nil {
+		_ = dest.Close()
+		return err
+	}
+	return dest.Close()
+}
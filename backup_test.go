@@ -0,0 +1,94 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWAL_Backup(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-backup")
+	opts := Options{
+		DirPath:         dir,
+		SegmentFileExt:  ".SEG",
+		SegmentSize:     64 * KB,
+		WriteBufferSize: 4 * KB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	var positions []*ChunkPosition
+	for i := 0; i < 1000; i++ {
+		pos, err := wal.Write([]byte(fmt.Sprintf("entry-%d-%0200d", i, i)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.True(t, wal.ActiveSegmentID() > 1)
+
+	destDir, _ := os.MkdirTemp("", "wal-test-backup-dest")
+	assert.Nil(t, os.RemoveAll(destDir))
+	assert.Nil(t, wal.Backup(destDir))
+	// the segment files in the backup are never overwritten.
+	assert.NotNil(t, wal.Backup(destDir))
+
+	// the entries written after the backup are not in it.
+	_, err = wal.Write([]byte("after"))
+	assert.Nil(t, err)
+
+	opts.DirPath = destDir
+	backup, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(backup)
+	assert.Equal(t, wal.ActiveSegmentID(), backup.ActiveSegmentID())
+	for i, pos := range positions {
+		val, err := backup.Read(pos)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("entry-%d-%0200d", i, i), string(val))
+	}
+	n, err := backup.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, len(positions), n)
+}
+
+func TestWAL_BackupTrimmed(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-backup-trimmed")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    64 * KB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	var positions []*ChunkPosition
+	for i := 0; i < 1000; i++ {
+		pos, err := wal.Write([]byte(fmt.Sprintf("entry-%d-%0200d", i, i)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	trimAt := 600
+	assert.Nil(t, wal.TrimFront(positions[trimAt]))
+
+	destDir, _ := os.MkdirTemp("", "wal-test-backup-trimmed-dest")
+	assert.Nil(t, os.RemoveAll(destDir))
+	assert.Nil(t, wal.Backup(destDir))
+
+	// the trimmed entries are not restored by the backup.
+	opts.DirPath = destDir
+	backup, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(backup)
+	first, err := backup.FirstPosition()
+	assert.Nil(t, err)
+	assert.Equal(t, positions[trimAt].BlockNumber, first.BlockNumber)
+	assert.Equal(t, positions[trimAt].ChunkOffset, first.ChunkOffset)
+	_, err = backup.Read(positions[trimAt-1])
+	assert.ErrorIs(t, err, ErrPositionTruncated)
+	n, err := backup.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, len(positions)-trimAt, n)
+}
@@ -0,0 +1,94 @@
+package wal
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	checkpointFileName = "CHECKPOINT"
+	checkpointTempExt  = ".tmp"
+)
+
+var ErrInvalidCheckpoint = errors.New("the checkpoint file is invalid")
+
+// writeCheckpoint atomically persists the position into the checkpoint file.
+// The position is written to a temporary file first,
+// and then renamed to the checkpoint file after it is synced,
+// the directory is synced at last to make the rename durable.
+func writeCheckpoint(fs FileSystem, dirPath string, perm os.FileMode, pos *ChunkPosition) error {
+	return writePositionFile(fs, filepath.Join(dirPath, checkpointFileName), perm, pos)
+}
+
+// writePositionFile atomically persists the position into the file at path,
+// it is shared by the checkpoint file and the trim file.
+func writePositionFile(fs FileSystem, path string, perm os.FileMode, pos *ChunkPosition) error {
+	tempPath := path + checkpointTempExt
+
+	fd, err := fs.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = fd.Write(pos.EncodeFixedSize()); err != nil {
+		_ = fd.Close()
+		return err
+	}
+	if err = fd.Sync(); err != nil {
+		_ = fd.Close()
+		return err
+	}
+	if err = fd.Close(); err != nil {
+		return err
+	}
+	if err = fs.Rename(tempPath, path); err != nil {
+		return err
+	}
+	return syncDir(fs, filepath.Dir(path))
+}
+
+// readCheckpoint reads the position from the checkpoint file.
+// It returns nil if the checkpoint file does not exist.
+func readCheckpoint(fs FileSystem, dirPath string) (*ChunkPosition, error) {
+	return readPositionFile(fs, filepath.Join(dirPath, checkpointFileName))
+}
+
+// readPositionFile reads the position from the file at path written by writePositionFile.
+// It returns nil if the file does not exist.
+func readPositionFile(fs FileSystem, path string) (*ChunkPosition, error) {
+	fd, err := fs.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() {
+		_ = fd.Close()
+	}()
+
+	buf, err := io.ReadAll(fd)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) != maxLen {
+		return nil, ErrInvalidCheckpoint
+	}
+	return DecodeChunkPosition(buf), nil
+}
+
+// removeCheckpoint removes the checkpoint file and its temporary file if exist.
+func removeCheckpoint(fs FileSystem, dirPath string) error {
+	return removePositionFile(fs, filepath.Join(dirPath, checkpointFileName))
+}
+
+// removePositionFile removes the file at path and its temporary file if exist.
+func removePositionFile(fs FileSystem, path string) error {
+	for _, name := range []string{path, path + checkpointTempExt} {
+		if err := fs.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package wal
+
+import (
+	"hash/crc32"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// ChecksumType is the algorithm used to compute the checksums of the chunks.
+type ChecksumType byte
+
+const (
+	// ChecksumCRC32 is the CRC-32 checksum with the IEEE polynomial,
+	// which is used by all the versions of the WAL.
+	ChecksumCRC32 ChecksumType = iota
+	// ChecksumXXH64 is the lower 32 bits of the XXH64 hash,
+	// which is much faster than CRC-32 for the large payloads.
+	ChecksumXXH64
+)
+
+// checksummer computes the checksums of the chunks of a segment file as the options specify.
+type checksummer struct {
+	typ      ChecksumType
+	crcTable *crc32.Table // the table of the CRC-32 checksum, see Options.CRCTable.
+}
+
+func newChecksummer(options *Options) checksummer {
+	table := options.CRCTable
+	if table == nil {
+		table = crcTable
+	}
+	return checksummer{typ: options.ChecksumType, crcTable: table}
+}
+
+// sum returns the checksum of the chunk, whose header after the checksum field is header,
+// and whose payload is data, the payload may also be passed along with the header.
+func (c checksummer) sum(header, data []byte) uint32 {
+	switch c.typ {
+	case ChecksumXXH64:
+		// the hash is truncated to fit into the checksum field of the chunk header.
+		var d xxhash.Digest
+		d.Reset()
+		_, _ = d.Write(header)
+		_, _ = d.Write(data)
+		return uint32(d.Sum64())
+	default:
+		sum := crc32.Update(0, c.crcTable, header)
+		return crc32.Update(sum, c.crcTable, data)
+	}
+}
+
+// chunkChecksum is a chunk whose checksum is verified after all the chunks of the entry are read,
+// so the chunks of a large entry can be verified in parallel, see Options.ParallelChecksumThreshold.
+type chunkChecksum struct {
+	sum    uint32
+	header [chunkHeaderSize - 4]byte // the length and the type in the chunk header.
+	data   int                       // the offset of the payload in the data of the entry.
+	length uint16
+}
+
+// verifyChunks reports whether the checksums of all the chunks match their payloads in data,
+// the chunks are split into contiguous runs verified by several goroutines if parallel is true.
+func (c checksummer) verifyChunks(chunks []chunkChecksum, data []byte, parallel bool) bool {
+	verify := func(chunks []chunkChecksum) bool {
+		for i := range chunks {
+			chunk := &chunks[i]
+			if c.sum(chunk.header[:], data[chunk.data:chunk.data+int(chunk.length)]) != chunk.sum {
+				return false
+			}
+		}
+		return true
+	}
+	workers := min(runtime.GOMAXPROCS(0), len(chunks))
+	if !parallel || workers <= 1 {
+		return verify(chunks)
+	}
+
+	var wg sync.WaitGroup
+	var failed atomic.Bool
+	step := (len(chunks) + workers - 1) / workers
+	for start := 0; start < len(chunks); start += step {
+		wg.Add(1)
+		go func(chunks []chunkChecksum) {
+			defer wg.Done()
+			if !verify(chunks) {
+				failed.Store(true)
+			}
+		}(chunks[start:min(start+step, len(chunks))])
+	}
+	wg.Wait()
+	return !failed.Load()
+}
@@ -0,0 +1,135 @@
+package wal
+
+import (
+	"hash/crc32"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWAL_ChecksumXXH64(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-checksum-xxh64")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		ChecksumType:   ChecksumXXH64,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+
+	small, err := wal.Write([]byte("hello"))
+	assert.Nil(t, err)
+	large, err := wal.Write([]byte(strings.Repeat("X", 100*KB)))
+	assert.Nil(t, err)
+
+	// the checksum is the lower 32 bits of the hash of the header and the payload.
+	_, _, crc, err := wal.ReadHeader(small)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(xxhash.Sum64(append([]byte{5, 0, ChunkTypeFull}, "hello"...))), crc)
+
+	val, err := wal.Read(small)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(val))
+	val, err = wal.Read(large)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Repeat("X", 100*KB), string(val))
+	assert.Nil(t, wal.Verify(false))
+
+	// the checksum type is not recorded, the chunks fail the verification of another one.
+	assert.Nil(t, wal.Close())
+	opts.ChecksumType = ChecksumCRC32
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = wal.Read(small)
+	assert.Equal(t, ErrInvalidCRC, err)
+	assert.ErrorIs(t, wal.Verify(false), ErrInvalidCRC)
+}
+
+func TestWAL_CRCTable(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-checksum-crc-table")
+	table := crc32.MakeTable(crc32.Castagnoli)
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		CRCTable:       table,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+
+	small, err := wal.Write([]byte("hello"))
+	assert.Nil(t, err)
+	large, err := wal.Write([]byte(strings.Repeat("X", 100*KB)))
+	assert.Nil(t, err)
+
+	// the checksum is computed with the table over the header and the payload.
+	_, _, crc, err := wal.ReadHeader(small)
+	assert.Nil(t, err)
+	assert.Equal(t, crc32.Checksum(append([]byte{5, 0, ChunkTypeFull}, "hello"...), table), crc)
+
+	val, err := wal.Read(large)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Repeat("X", 100*KB), string(val))
+	assert.Nil(t, wal.Verify(false))
+
+	// the chunks fail the verification of the default IEEE table.
+	assert.Nil(t, wal.Close())
+	opts.CRCTable = nil
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = wal.Read(small)
+	assert.Equal(t, ErrInvalidCRC, err)
+}
+
+func TestWAL_ParallelChecksum(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-parallel-checksum")
+	opts := Options{
+		DirPath:                   dir,
+		SegmentFileExt:            ".SEG",
+		SegmentSize:               32 * MB,
+		ParallelChecksumThreshold: 64 * KB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	small, err := wal.Write([]byte(strings.Repeat("S", 40*KB)))
+	assert.Nil(t, err)
+	large, err := wal.Write([]byte(strings.Repeat("L", 10*MB)))
+	assert.Nil(t, err)
+
+	val, err := wal.Read(small)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Repeat("S", 40*KB), string(val))
+	val, err = wal.Read(large)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Repeat("L", 10*MB), string(val))
+	reader := wal.NewReader()
+	defer reader.Close()
+	for i := 0; i < 2; i++ {
+		_, _, err := reader.Next()
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, wal.Verify(false))
+
+	// a corrupted chunk in the middle of the large entry is found.
+	corruptChunk(t, wal, &ChunkPosition{SegmentId: large.SegmentId, BlockNumber: large.BlockNumber + 100})
+	_, err = wal.Read(large)
+	assert.Equal(t, ErrInvalidCRC, err)
+	_, err = wal.Read(small)
+	assert.Nil(t, err)
+	var corruption *CorruptionError
+	assert.ErrorAs(t, wal.Verify(false), &corruption)
+	assert.Equal(t, large.ChunkOffset, corruption.Position.ChunkOffset)
+	assert.ErrorIs(t, corruption, ErrInvalidCRC)
+}
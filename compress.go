@@ -0,0 +1,85 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/golang/snappy"
+)
+
+// CompressionType is the algorithm used to compress the data of the entries.
+type CompressionType byte
+
+const (
+	// CompressionNone means the data is stored as it is.
+	CompressionNone CompressionType = iota
+	// CompressionSnappy compresses the data by snappy,
+	// which trades the compression ratio for the speed.
+	CompressionSnappy
+)
+
+// The codec of an entry is stored in the entry flags of every chunk header,
+// so the entries compressed by different codecs can be mixed in a segment file.
+// The checksum of a chunk is computed over the stored bytes, that is, the compressed data.
+const (
+	entryCodecMask   byte = 0x03 << 3
+	entryCodecSnappy byte = 1 << 3
+)
+
+// entryFlags returns the entry flags of the data compressed by the compression type.
+func (c CompressionType) entryFlags() byte {
+	switch c {
+	case CompressionSnappy:
+		return entryCodecSnappy
+	default:
+		return 0
+	}
+}
+
+// compress compresses the data by the codec in the entry flags.
+func compress(data []byte, flags byte) []byte {
+	switch flags & entryCodecMask {
+	case entryCodecSnappy:
+		return snappy.Encode(nil, data)
+	default:
+		return data
+	}
+}
+
+// codecHeaderSize returns the max size of the header of the data compressed by the codec
+// in the entry flags, the size of the decompressed data can be known from the header.
+func codecHeaderSize(flags byte) int {
+	switch flags & entryCodecMask {
+	case entryCodecSnappy:
+		return binary.MaxVarintLen32
+	default:
+		return 0
+	}
+}
+
+// decompress decompresses the data of the entry, the prefix must already be stripped.
+// If skipData is true, the data may only contain the header of the compressed data,
+// and only the length of the decompressed data is decoded.
+func (e *chunkEntry) decompress(skipData bool) error {
+	switch e.flags & entryCodecMask {
+	case 0:
+		return nil
+	case entryCodecSnappy:
+		if skipData {
+			n, err := snappy.DecodedLen(e.data)
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidEntry, err)
+			}
+			e.length = n
+			return nil
+		}
+		data, err := snappy.Decode(nil, e.data)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidEntry, err)
+		}
+		e.data, e.length = data, len(data)
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown codec %d", ErrInvalidEntry, e.flags&entryCodecMask)
+	}
+}
@@ -0,0 +1,99 @@
+package wal
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWAL_CompressionSnappy(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-compression-snappy")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		Compression:    CompressionSnappy,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+
+	var values [][]byte
+	var positions []*ChunkPosition
+	var total int64
+	for i := 1; i <= 100; i++ {
+		val := []byte(strings.Repeat("snappy", i*100))
+		pos, err := wal.Write(val)
+		assert.Nil(t, err)
+		values = append(values, val)
+		positions = append(positions, pos)
+		total += int64(len(val))
+	}
+	// the compressible data takes much less space.
+	assert.Equal(t, SegmentID(1), wal.ActiveSegmentID())
+	assert.True(t, wal.activeSegment.Size() < total/4)
+
+	now := time.Now()
+	val := []byte(strings.Repeat("timestamp", 1000))
+	pos, err := wal.WriteWithTime(val, now)
+	assert.Nil(t, err)
+	values = append(values, val)
+	positions = append(positions, pos)
+
+	for i := 1; i <= 10; i++ {
+		val := []byte(strings.Repeat("batch", i*1000))
+		wal.PendingWrites(val)
+		values = append(values, val)
+	}
+	batch, err := wal.WriteAll()
+	assert.Nil(t, err)
+	positions = append(positions, batch...)
+
+	// the entries written without compression can be mixed in.
+	assert.Nil(t, wal.Close())
+	opts.Compression = CompressionNone
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	val = []byte("not compressed")
+	pos, err = wal.Write(val)
+	assert.Nil(t, err)
+	values = append(values, val)
+	positions = append(positions, pos)
+
+	for i, pos := range positions {
+		res, err := wal.Read(pos)
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], res)
+	}
+	_, ts, err := wal.ReadWithTime(positions[100])
+	assert.Nil(t, err)
+	assert.Equal(t, now.UnixNano(), ts.UnixNano())
+
+	reader := wal.NewReader()
+	for i := 0; ; i++ {
+		res, _, err := reader.Next()
+		if err == io.EOF {
+			assert.Equal(t, len(values), i)
+			break
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], res)
+	}
+
+	// the length of the decompressed data is reported without reading the data.
+	reader = wal.NewReader()
+	for i := 0; ; i++ {
+		_, length, err := reader.NextPosition()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, len(values[i]), length)
+	}
+}
@@ -0,0 +1,55 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+)
+
+// cursorVersion is the first byte of the cursor returned by Reader.SaveCursor.
+const cursorVersion byte = 1
+
+var ErrInvalidCursor = errors.New("invalid reader cursor, it is not saved by Reader.SaveCursor or beyond the WAL")
+
+// SaveCursor returns the cursor of the reader, which is where the next entry is read from,
+// it can be persisted and passed to WAL.NewReaderFromCursor to resume the reading later,
+// even after the WAL is reopened. The encoding of the cursor is opaque to the users.
+func (r *Reader) SaveCursor() []byte {
+	var pos *ChunkPosition
+	if r.peeked != nil || r.currentReader < len(r.segmentReaders) {
+		pos = r.CurrentChunkPosition()
+	} else if len(r.segmentReaders) > 0 {
+		// the reader is done, resume from the end of the last segment file.
+		last := r.segmentReaders[len(r.segmentReaders)-1]
+		pos = &ChunkPosition{
+			SegmentId:   last.segment.id,
+			BlockNumber: last.blockNumber,
+			ChunkOffset: last.chunkOffset,
+		}
+	} else {
+		pos = &ChunkPosition{}
+	}
+	return append([]byte{cursorVersion}, pos.Encode()...)
+}
+
+// NewReaderFromCursor returns a new reader starting from the cursor saved by Reader.SaveCursor.
+// ErrPositionTruncated is returned if the segment file of the cursor has been removed by retention,
+// and ErrInvalidCursor if the cursor is broken or beyond the end of the WAL.
+func (wal *WAL) NewReaderFromCursor(cursor []byte) (*Reader, error) {
+	if len(cursor) < 2 || cursor[0] != cursorVersion {
+		return nil, ErrInvalidCursor
+	}
+	pos := DecodeChunkPosition(cursor[1:])
+
+	wal.mu.RLock()
+	truncated := pos.SegmentId < wal.firstSegmentID()
+	seg := wal.findSegment(pos.SegmentId)
+	wal.mu.RUnlock()
+
+	if truncated {
+		return nil, fmt.Errorf("reader cursor in segment file %d%s: %w", pos.SegmentId, wal.options.SegmentFileExt, ErrPositionTruncated)
+	}
+	if seg == nil || int64(pos.BlockNumber)*blockSize+pos.ChunkOffset > seg.Size() {
+		return nil, ErrInvalidCursor
+	}
+	return wal.NewReaderWithStart(pos)
+}
@@ -0,0 +1,72 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReader_SaveCursor(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-reader-cursor")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    64 * KB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() { destroyWAL(wal) }()
+
+	for i := 0; i < 1000; i++ {
+		_, err := wal.Write([]byte(fmt.Sprintf("entry-%d-%0100d", i, i)))
+		assert.Nil(t, err)
+	}
+
+	reader := wal.NewReader()
+	for i := 0; i < 600; i++ {
+		_, _, err := reader.Next()
+		assert.Nil(t, err)
+	}
+	cursor := reader.SaveCursor()
+
+	// resume after reopening.
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	reader, err = wal.NewReaderFromCursor(cursor)
+	assert.Nil(t, err)
+	for i := 600; i < 1000; i++ {
+		val, _, err := reader.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("entry-%d-%0100d", i, i), string(val))
+	}
+	_, _, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// the cursor of a finished reader resumes from the new entries.
+	cursor = reader.SaveCursor()
+	_, err = wal.Write([]byte("new"))
+	assert.Nil(t, err)
+	reader, err = wal.NewReaderFromCursor(cursor)
+	assert.Nil(t, err)
+	val, _, err := reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "new", string(val))
+
+	_, err = wal.NewReaderFromCursor([]byte("bad"))
+	assert.Equal(t, ErrInvalidCursor, err)
+	_, err = wal.NewReaderFromCursor(append([]byte{cursorVersion}, (&ChunkPosition{SegmentId: 100}).Encode()...))
+	assert.Equal(t, ErrInvalidCursor, err)
+
+	// the segment file of the cursor is removed.
+	cursor = wal.NewReader().SaveCursor()
+	assert.Nil(t, os.Remove(SegmentFileName(dir, ".SEG", 1)))
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = wal.NewReaderFromCursor(cursor)
+	assert.ErrorIs(t, err, ErrPositionTruncated)
+}
@@ -0,0 +1,166 @@
+package wal
+
+import (
+	"errors"
+)
+
+// A dedup record is written by WAL.WriteDedup as an internal entry right after the data,
+// recording the position of the data and its dedup id:
+//
+//	+----------+--------------------------+-------------+
+//	| Kind(1B) | Position(maxLen bytes)   | Dedup id    |
+//	+----------+--------------------------+-------------+
+//
+// The readers skip the dedup records like the other internal entries,
+// they are only read when opening the WAL to fill the dedup window.
+const internalKindDedup byte = 4
+
+var ErrDedupDisabled = errors.New("the dedup is disabled, options.DedupWindow is zero")
+
+// WriteDedup writes the data to the WAL like Write along with the dedup id, if the id is
+// in the window of the recent ids, the data is not written and the position of the data
+// written with the id before is returned, so a producer can safely retry a write
+// after an ambiguous failure. The window holds the last options.DedupWindow ids,
+// it is filled by scanning the newest segment files when opening the WAL.
+//
+// The id is written right after the data, so if the WAL crashes in between, the id is lost
+// and a retry writes the data again. The position returned for a duplicate may be
+// already removed by retention. It returns ErrDedupDisabled if options.DedupWindow is zero.
+//
+// Notice that the ids are skipped by the older versions of the WAL,
+// they read the data written by WriteDedup as the data written by Write.
+func (wal *WAL) WriteDedup(id string, data []byte) (*ChunkPosition, error) {
+	if wal.dedup == nil {
+		return nil, ErrDedupDisabled
+	}
+	flags := wal.options.Compression.entryFlags()
+	data = compress(data, flags)
+
+	wal.lock()
+	defer wal.unlock()
+	if pos := wal.dedup.get(id); pos != nil {
+		return pos, nil
+	}
+	if int64(1+maxLen+len(id))+chunkHeaderSize > wal.segmentCapacity() {
+		return nil, ErrValueTooLarge
+	}
+	pos, err := wal.writeData(data, flags)
+	if err != nil {
+		return nil, err
+	}
+	// the id is remembered even if its record fails to be written, so a retry is deduplicated.
+	wal.dedup.add(id, pos)
+
+	record := make([]byte, 1, 1+maxLen+len(id))
+	record[0] = internalKindDedup
+	record = append(record, pos.EncodeFixedSize()...)
+	record = append(record, id...)
+	if _, err := wal.writeLocked(record, entryFlagInternal); err != nil {
+		return nil, err
+	}
+	return pos, nil
+}
+
+// dedupRecord returns the dedup id and the position recorded in the entry,
+// and false if the entry is not a dedup record.
+func (e *chunkEntry) dedupRecord() (string, *ChunkPosition, bool) {
+	if e.flags&entryFlagInternal == 0 || len(e.data) < 1+maxLen || e.data[0] != internalKindDedup {
+		return "", nil, false
+	}
+	return string(e.data[1+maxLen:]), DecodeChunkPosition(e.data[1 : 1+maxLen]), true
+}
+
+// dedupWindow is the bounded set of the recent dedup ids,
+// the oldest id is dropped when a new one is added to the full window.
+type dedupWindow struct {
+	positions map[string]*ChunkPosition
+	ids       []string // the ring of the ids in the order they are added.
+	next      int      // the index of the ring where the next id is added.
+	full      bool
+}
+
+func newDedupWindow(size int) *dedupWindow {
+	return &dedupWindow{
+		positions: make(map[string]*ChunkPosition, size),
+		ids:       make([]string, size),
+	}
+}
+
+// get returns the position of the data written with the id, or nil if the id is not in the window.
+func (w *dedupWindow) get(id string) *ChunkPosition {
+	return w.positions[id]
+}
+
+// add adds the id to the window, it does nothing if the id is already in the window.
+func (w *dedupWindow) add(id string, pos *ChunkPosition) {
+	if _, ok := w.positions[id]; ok {
+		return
+	}
+	if w.full {
+		delete(w.positions, w.ids[w.next])
+	}
+	w.positions[id] = pos
+	w.ids[w.next] = id
+	w.next++
+	if w.next == len(w.ids) {
+		w.next, w.full = 0, true
+	}
+}
+
+// loadDedupWindow fills the dedup window with the ids of the newest dedup records,
+// the segment files are scanned from the newest one until the window is full.
+func (wal *WAL) loadDedupWindow() error {
+	reader := wal.NewReader()
+	defer reader.Close()
+
+	type record struct {
+		id  string
+		pos *ChunkPosition
+	}
+	var segments [][]record
+	var total int
+	for i := len(reader.segmentReaders) - 1; i >= 0 && total < len(wal.dedup.ids); i-- {
+		var records []record
+		err := scanInternalEntries(reader.segmentReaders[i].segment, func(entry *chunkEntry) {
+			if id, pos, ok := entry.dedupRecord(); ok {
+				records = append(records, record{id: id, pos: pos})
+			}
+		})
+		if err != nil {
+			return err
+		}
+		segments = append(segments, records)
+		total += len(records)
+	}
+
+	// add the ids from the oldest one, so the newest ones are kept in the window.
+	for i := len(segments) - 1; i >= 0; i-- {
+		for _, r := range segments[i] {
+			wal.dedup.add(r.id, r.pos)
+		}
+	}
+	return nil
+}
+
+// scanInternalEntries calls fn with every internal entry of the segment file in order,
+// the scan stops at the first corrupted entry, such as the torn tail left by a crash.
+func scanInternalEntries(seg *segment, fn func(entry *chunkEntry)) error {
+	pos := &ChunkPosition{SegmentId: seg.id}
+	for {
+		entry, err := seg.readInternal(pos.BlockNumber, pos.ChunkOffset, readOptions{skipData: true})
+		// the data of the internal entry is read again, the other data is skipped.
+		if err == nil && entry.flags&entryFlagInternal != 0 {
+			entry, err = seg.readInternal(pos.BlockNumber, pos.ChunkOffset, readOptions{})
+		}
+		if err == ErrClosed {
+			return err
+		}
+		if err != nil {
+			return nil
+		}
+		if entry.flags&entryFlagInternal != 0 {
+			fn(&entry)
+		}
+		pos = entry.next
+	}
+}
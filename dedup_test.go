@@ -0,0 +1,115 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWAL_WriteDedup(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-write-dedup")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    32 * KB,
+		DedupWindow:    3,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+
+	value := func(id string) []byte {
+		return []byte(strings.Repeat(id, 8*KB))
+	}
+	write := func(id string) *ChunkPosition {
+		pos, err := wal.WriteDedup(id, value(id))
+		assert.Nil(t, err)
+		return pos
+	}
+	positions := make(map[string]*ChunkPosition)
+	for _, id := range []string{"a", "b", "c"} {
+		positions[id] = write(id)
+	}
+	// the retried writes return the positions of the data written before.
+	assert.Equal(t, positions["a"], write("a"))
+	assert.Equal(t, positions["c"], write("c"))
+
+	// the oldest id is dropped from the window.
+	positions["d"] = write("d")
+	pos := write("a")
+	assert.NotEqual(t, positions["a"], pos)
+	positions["a"] = pos
+	assert.True(t, wal.ActiveSegmentID() > 1)
+
+	// the dedup records are not returned by the readers.
+	n, err := wal.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	reader := wal.NewReader()
+	var ids []string
+	for {
+		val, _, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		ids = append(ids, string(val[:1]))
+	}
+	reader.Close()
+	assert.Equal(t, []string{"a", "b", "c", "d", "a"}, ids)
+
+	// the window is loaded from the newest segment files after reopening.
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	for _, id := range []string{"c", "d", "a"} {
+		assert.Equal(t, positions[id], write(id), id)
+	}
+	assert.NotEqual(t, positions["b"], write("b"))
+
+	// WriteDedup is disabled without a window.
+	assert.Nil(t, wal.Close())
+	opts.DedupWindow = 0
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = wal.WriteDedup("a", []byte("a"))
+	assert.Equal(t, ErrDedupDisabled, err)
+}
+
+func TestWAL_WriteDedup_LargeWindow(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-write-dedup-large")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    64 * KB,
+		DedupWindow:    1000,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+
+	positions := make([]*ChunkPosition, 2000)
+	for i := range positions {
+		positions[i], err = wal.WriteDedup(fmt.Sprint(i), []byte(fmt.Sprintf("value-%d", i)))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	for i := len(positions) - 1; i >= 1000; i-- {
+		pos, err := wal.WriteDedup(fmt.Sprint(i), nil)
+		assert.Nil(t, err)
+		assert.Equal(t, positions[i], pos)
+	}
+	pos, err := wal.WriteDedup("999", nil)
+	assert.Nil(t, err)
+	assert.NotEqual(t, positions[999], pos)
+}
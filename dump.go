@@ -0,0 +1,97 @@
+package wal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// chunkTypeNames are the names of the chunk types printed by Dump.
+var chunkTypeNames = [...]string{
+	ChunkTypeFull:   "FULL",
+	ChunkTypeFirst:  "FIRST",
+	ChunkTypeMiddle: "MIDDLE",
+	ChunkTypeLast:   "LAST",
+}
+
+// Dump writes the layout of all the segment files to w in a human-readable form,
+// such as to attach it to a bug report of a corruption. For every segment file,
+// it prints a line with its id and size, then a line for every chunk with the block number,
+// the offset, the chunk type, the entry flags, the length and the checksum, which is
+// followed by ok if it matches the payload, or by the reason why the chunk is broken.
+// The padding at the end of the blocks is skipped.
+//
+// The corruptions don't stop the dump: since the chunks after a broken one can not be
+// located reliably within the block, the dump goes on with the next block,
+// and stops at the end of the segment file or when the segment file is torn.
+// Only the errors of reading the segment files and writing to w are returned.
+//
+// Like the Reader, it doesn't hold the WAL lock, so it can run along with the writes,
+// and the segment files created after it starts are not dumped.
+func (wal *WAL) Dump(w io.Writer) error {
+	reader := wal.NewReader()
+	defer reader.Close()
+
+	bw := bufio.NewWriter(w)
+	for _, segReader := range reader.segmentReaders {
+		if err := dumpSegment(bw, segReader.segment, wal.options.SegmentFileExt); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// dumpSegment writes the layout of the chunks in the segment file to w.
+func dumpSegment(w *bufio.Writer, seg *segment, ext string) error {
+	size := seg.writtenSize.Load()
+	if _, err := fmt.Fprintf(w, "segment %d%s size %d\n", seg.id, ext, size); err != nil {
+		return err
+	}
+
+	var blockNumber uint32
+	var chunkOffset int64
+	for int64(blockNumber)*blockSize+chunkOffset < size {
+		// the rest of the block is padding if it is too short for a chunk header.
+		if chunkOffset+chunkHeaderSize > blockSize {
+			blockNumber++
+			chunkOffset = 0
+			continue
+		}
+		h, err := seg.readHeader(blockNumber, chunkOffset)
+		if err == io.ErrUnexpectedEOF {
+			_, err = fmt.Fprintf(w, "  block %d offset %d: torn chunk header\n", blockNumber, chunkOffset)
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("dump segment file %d%s failed: %w", seg.id, ext, err)
+		}
+
+		valid, err := seg.verifyChunk(blockNumber, chunkOffset, h)
+		status := "ok"
+		switch {
+		case err == io.ErrUnexpectedEOF:
+			status = "length beyond the block"
+		case err != nil:
+			return fmt.Errorf("dump segment file %d%s failed: %w", seg.id, ext, err)
+		case !valid:
+			status = "invalid crc"
+		}
+		if _, err := fmt.Fprintf(w, "  block %d offset %d type %s flags 0x%02x length %d crc %08x %s\n",
+			blockNumber, chunkOffset, chunkTypeNames[h.chunkType], h.flags, h.length, h.crc, status); err != nil {
+			return err
+		}
+
+		if status != "ok" {
+			// the next chunk can only be located at the start of the next block.
+			blockNumber++
+			chunkOffset = 0
+			continue
+		}
+		chunkOffset += chunkHeaderSize + int64(h.length)
+		if h.chunkType == ChunkTypeFirst || h.chunkType == ChunkTypeMiddle {
+			blockNumber++
+			chunkOffset = 0
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package wal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWAL_Dump(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-dump")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	var positions []*ChunkPosition
+	for i := 0; i < 10; i++ {
+		pos, err := wal.Write([]byte(strings.Repeat("X", 100)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	large, err := wal.Write([]byte(strings.Repeat("X", 40*KB)))
+	assert.Nil(t, err)
+	_, err = wal.Write([]byte("hello"))
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	assert.Nil(t, wal.Dump(&buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, "segment 1.SEG size "+fmt.Sprint(wal.activeSegment.Size()), lines[0])
+	// 10 small entries, the large one of 2 chunks, and hello.
+	assert.Equal(t, 1+10+2+1, len(lines))
+	for _, line := range lines[1:] {
+		assert.True(t, strings.HasSuffix(line, " ok"), line)
+	}
+	assert.True(t, strings.HasPrefix(lines[11], fmt.Sprintf("  block 0 offset %d type FIRST flags 0x00", large.ChunkOffset)))
+	assert.True(t, strings.HasPrefix(lines[12], "  block 1 offset 0 type LAST"))
+	assert.True(t, strings.Contains(lines[13], "length 5 "))
+
+	// the corrupted chunk is annotated, and the dump goes on with the next block.
+	corruptChunk(t, wal, positions[3])
+	buf.Reset()
+	assert.Nil(t, wal.Dump(&buf))
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 1+4+2, len(lines))
+	assert.True(t, strings.HasSuffix(lines[4], " invalid crc"))
+	assert.True(t, strings.HasPrefix(lines[5], "  block 1 offset 0 type LAST"))
+	assert.True(t, strings.Contains(lines[6], "length 5 "))
+	assert.True(t, strings.HasSuffix(lines[6], " ok"))
+}
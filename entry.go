@@ -0,0 +1,105 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// The type byte of a chunk header is made up of the chunk type and the entry flags:
+//
+//	+---------+-------------+
+//	| 7 ... 2 |    1 ... 0  |
+//	+---------+-------------+
+//	|  flags  |  chunk type |
+//	+---------+-------------+
+//
+// The flags describe how the data of the entry is encoded,
+// and every chunk of an entry carries the same flags.
+// The data written by older versions has no flags.
+const (
+	chunkTypeMask byte = 0x03
+
+	// entryFlagTimestamp means the data of the entry is prefixed
+	// by the write timestamp, in unix nanoseconds as 8 bytes int64.
+	entryFlagTimestamp byte = 1 << 2
+
+	// entryFlagSpan means the entry is a fragment of an entry spanning segment files,
+	// the first byte of its data is the kind of the fragment, see spanFirst.
+	// The other flags and the prefix are those of the whole entry,
+	// they are decoded after all the fragments are read.
+	entryFlagSpan byte = 1 << 5
+
+	// entryFlagUncommitted means the entry is written by WAL.WriteUncommitted,
+	// the readers only return it after the commit marker following it, see internalKindCommit.
+	entryFlagUncommitted byte = 1 << 6
+
+	// entryFlagInternal means the entry is written by the WAL itself, such as a segment footer,
+	// the first byte of its data is the kind of the internal entry.
+	// The internal entries are skipped by the readers, except that the WAL reader
+	// reads the commit markers to return the uncommitted entries.
+	entryFlagInternal byte = 1 << 7
+)
+
+const timestampSize = 8
+
+var ErrInvalidEntry = errors.New("invalid entry, the entry prefix is broken")
+
+// chunkEntry is an entry decoded from the chunks in a segment file.
+type chunkEntry struct {
+	// data is the user data without the prefix.
+	data []byte
+	// length is the length of the user data.
+	length int
+	// flags are the entry flags in the chunk headers.
+	flags byte
+	// timestamp is the write time in unix nanoseconds if entryFlagTimestamp is set.
+	timestamp int64
+	// next is the position of the next entry.
+	next *ChunkPosition
+	// namespace is the namespace id of the entry written by WAL.WriteNamespace, zero otherwise.
+	namespace uint32
+}
+
+// entryPrefixSize returns the size of the prefix required by the flags.
+func entryPrefixSize(flags byte) int {
+	var size int
+	if flags&entryFlagTimestamp != 0 {
+		size += timestampSize
+	}
+	return size
+}
+
+// encodeEntryPrefix returns the data with the prefix required by the flags.
+func encodeEntryPrefix(data []byte, flags byte, timestamp time.Time) []byte {
+	buf := make([]byte, entryPrefixSize(flags), entryPrefixSize(flags)+len(data))
+	if flags&entryFlagTimestamp != 0 {
+		binary.LittleEndian.PutUint64(buf[:timestampSize], uint64(timestamp.UnixNano()))
+	}
+	return append(buf, data...)
+}
+
+// decodePrefix strips the prefix from the data and decodes it.
+func (e *chunkEntry) decodePrefix() error {
+	prefixSize := entryPrefixSize(e.flags)
+	if prefixSize == 0 {
+		return nil
+	}
+	if len(e.data) < prefixSize {
+		return ErrInvalidEntry
+	}
+	if e.flags&entryFlagTimestamp != 0 {
+		e.timestamp = int64(binary.LittleEndian.Uint64(e.data[:timestampSize]))
+	}
+	e.data = e.data[prefixSize:]
+	e.length -= prefixSize
+	return nil
+}
+
+// Time returns the write timestamp of the entry, or the zero time if it has none.
+func (e *chunkEntry) Time() time.Time {
+	if e.flags&entryFlagTimestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, e.timestamp)
+}
@@ -0,0 +1,263 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// exportMagic starts the stream written by ExportTo.
+var exportMagic = []byte("RWALEXP\x01")
+
+// The kinds of the frames in the stream written by ExportTo.
+const (
+	exportFrameEnd byte = iota
+	exportFrameSegment
+	exportFrameTrim
+)
+
+var (
+	ErrInvalidExport = errors.New("invalid export stream, it is not written by ExportTo or corrupted")
+	ErrNotEmpty      = errors.New("the WAL is not empty")
+)
+
+// ExportTo writes all the segment files of the WAL to w as a single stream,
+// which can be imported by ImportFrom. The stream starts with a magic header,
+// then a frame for every segment file in the order of the ids, and an end frame:
+//
+//	+------+----+------+--------+-------+
+//	| kind | id | size |  data  |  crc  |
+//	+------+----+------+--------+-------+
+//	   1     4     8      size      4
+//
+// The data is the content of the segment file as is, so the positions in it stay valid.
+// If the start position is set by TrimFront, a trim frame follows the segment frames,
+// with the position encoded by ChunkPosition.EncodeFixedSize and the crc of it:
+//
+//	+------+----------+-------+
+//	| kind | position |  crc  |
+//	+------+----------+-------+
+//	   1      maxLen      4
+//
+// The active segment file is flushed first, and exported up to its current size.
+// The WAL lock is held during the export, so the writes are blocked until it is done.
+// The segment files loaded by options.SegmentLoader are not exported.
+func (wal *WAL) ExportTo(w io.Writer) error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	if wal.olderSegments == nil {
+		return ErrClosed
+	}
+	if err := wal.activeSegment.Flush(); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(exportMagic); err != nil {
+		return err
+	}
+	header := make([]byte, 13)
+	for _, id := range append(wal.sortedOlderSegmentIDs(), wal.activeSegment.id) {
+		seg := wal.findSegment(id)
+		size := seg.writtenSize.Load()
+		header[0] = exportFrameSegment
+		binary.LittleEndian.PutUint32(header[1:5], id)
+		binary.LittleEndian.PutUint64(header[5:13], uint64(size))
+		if _, err := bw.Write(header); err != nil {
+			return err
+		}
+		hash := crc32.New(crcTable)
+		src := io.TeeReader(io.NewSectionReader(seg.readFd, 0, size), hash)
+		if _, err := io.Copy(bw, src); err != nil {
+			return fmt.Errorf("export segment file %d%s failed: %w", id, wal.options.SegmentFileExt, err)
+		}
+		if err := binary.Write(bw, binary.LittleEndian, hash.Sum32()); err != nil {
+			return err
+		}
+	}
+	if wal.trimStart != nil {
+		pos := wal.trimStart.EncodeFixedSize()
+		if err := bw.WriteByte(exportFrameTrim); err != nil {
+			return err
+		}
+		if _, err := bw.Write(pos); err != nil {
+			return err
+		}
+		if err := binary.Write(bw, binary.LittleEndian, crc32.Checksum(pos, crcTable)); err != nil {
+			return err
+		}
+	}
+	if err := bw.WriteByte(exportFrameEnd); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ImportFrom reads the stream written by ExportTo from r, and restores the segment files
+// into the WAL, the positions in the exported WAL are valid in this WAL after the import,
+// and the start position set by TrimFront is restored along with them.
+// The WAL must be empty, otherwise ErrNotEmpty is returned.
+// If the stream is invalid, ErrInvalidExport is returned, and the restored segment
+// files are removed, the WAL is left empty. So is it if opening the restored segment files fails.
+func (wal *WAL) ImportFrom(r io.Reader) error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	if wal.olderSegments == nil {
+		return ErrClosed
+	}
+	if len(wal.olderSegments) > 0 || wal.activeSegment.Size() > 0 {
+		return ErrNotEmpty
+	}
+
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(exportMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, exportMagic) {
+		return ErrInvalidExport
+	}
+
+	// the empty active segment file is replaced by the imported ones.
+	emptyID := wal.activeSegment.id
+	if err := wal.activeSegment.Remove(); err != nil {
+		return err
+	}
+	var imported []SegmentID
+	var trimStart *ChunkPosition
+	importErr := func() error {
+		header := make([]byte, 13)
+		for {
+			kind, err := br.ReadByte()
+			if err != nil {
+				return ErrInvalidExport
+			}
+			if kind == exportFrameEnd {
+				// the start position must be in one of the segment files.
+				if trimStart != nil && (len(imported) == 0 || trimStart.SegmentId < imported[0] ||
+					trimStart.SegmentId > imported[len(imported)-1]) {
+					return ErrInvalidExport
+				}
+				return nil
+			}
+			if kind == exportFrameTrim {
+				frame := make([]byte, maxLen+4)
+				if _, err := io.ReadFull(br, frame); err != nil ||
+					crc32.Checksum(frame[:maxLen], crcTable) != binary.LittleEndian.Uint32(frame[maxLen:]) {
+					return ErrInvalidExport
+				}
+				trimStart = DecodeChunkPosition(frame[:maxLen])
+				continue
+			}
+			if kind != exportFrameSegment {
+				return ErrInvalidExport
+			}
+			if _, err := io.ReadFull(br, header[1:]); err != nil {
+				return ErrInvalidExport
+			}
+			id := binary.LittleEndian.Uint32(header[1:5])
+			size := int64(binary.LittleEndian.Uint64(header[5:13]))
+			if len(imported) > 0 && id <= imported[len(imported)-1] {
+				return ErrInvalidExport
+			}
+			imported = append(imported, id)
+			if err := wal.importSegment(br, id, size); err != nil {
+				return err
+			}
+		}
+	}()
+	if importErr == nil && len(imported) == 0 {
+		importErr = ErrInvalidExport
+	}
+	if importErr != nil {
+		for _, id := range imported {
+			_ = wal.options.FileSystem.Remove(wal.segmentFileName(id))
+		}
+		imported = []SegmentID{emptyID}
+		trimStart = nil
+	}
+
+	// the imported segment files are new entries of the directory.
+	wal.dirDirty = true
+	if err := wal.openImported(imported); err != nil {
+		// drop the imported segment files, and reopen the empty active segment file,
+		// so the WAL is still usable like before the import.
+		for id, seg := range wal.olderSegments {
+			_ = seg.Close()
+			delete(wal.olderSegments, id)
+		}
+		for _, id := range imported {
+			_ = wal.options.FileSystem.Remove(wal.segmentFileName(id))
+		}
+		seg, openErr := openSegmentFile(wal.options.DirPath, wal.options.SegmentFileExt, emptyID, &wal.options)
+		if openErr != nil {
+			return errors.Join(err, openErr)
+		}
+		wal.activeSegment = seg
+		return err
+	}
+	if trimStart != nil {
+		if err := writePositionFile(wal.options.FileSystem, wal.trimFilePath(), wal.options.FileMode, trimStart); err != nil {
+			return err
+		}
+		wal.trimStart = trimStart
+	}
+	if err := wal.syncDir(); err != nil {
+		return err
+	}
+	return importErr
+}
+
+// openImported opens the imported segment files, the last one is the active segment file.
+// If it fails, the segment files opened are left in wal.olderSegments.
+func (wal *WAL) openImported(ids []SegmentID) error {
+	for i, id := range ids {
+		seg, err := openSegmentFile(wal.options.DirPath, wal.options.SegmentFileExt, id, &wal.options)
+		if err != nil {
+			return err
+		}
+		if i == len(ids)-1 {
+			wal.activeSegment = seg
+			return nil
+		}
+		wal.olderSegments[id] = seg
+		if wal.options.MMapReads {
+			if err := seg.mmap(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// importSegment writes the data of the segment file in the frame to the WAL directory,
+// and verifies the checksum after the data.
+func (wal *WAL) importSegment(r io.Reader, id SegmentID, size int64) error {
+	name := wal.segmentFileName(id)
+	fd, err := wal.options.FileSystem.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, wal.options.FileMode)
+	if err != nil {
+		return err
+	}
+	hash := crc32.New(crcTable)
+	_, err = io.CopyN(io.MultiWriter(fd, hash), r, size)
+	if err == io.EOF {
+		err = ErrInvalidExport
+	}
+	var sum uint32
+	if err == nil {
+		if binary.Read(r, binary.LittleEndian, &sum) != nil || sum != hash.Sum32() {
+			err = ErrInvalidExport
+		}
+	}
+	if err == nil {
+		err = fd.Sync()
+	}
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,179 @@
+package wal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWAL_ExportTo(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-export")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    64 * KB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	var positions []*ChunkPosition
+	for i := 0; i < 1000; i++ {
+		pos, err := wal.Write([]byte(fmt.Sprintf("entry-%d-%0200d", i, i)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.True(t, wal.ActiveSegmentID() > 1)
+
+	var buf bytes.Buffer
+	assert.Nil(t, wal.ExportTo(&buf))
+	stream := buf.Bytes()
+
+	opts.DirPath, _ = os.MkdirTemp("", "wal-test-import")
+	imported, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() { destroyWAL(imported) }()
+
+	// a corrupted stream leaves the WAL empty.
+	corrupted := append([]byte(nil), stream...)
+	corrupted[len(corrupted)/2] ^= 0xFF
+	assert.Equal(t, ErrInvalidExport, imported.ImportFrom(bytes.NewReader(corrupted)))
+	assert.True(t, imported.IsEmpty())
+	assert.Equal(t, ErrInvalidExport, imported.ImportFrom(bytes.NewReader(stream[:len(stream)-1])))
+	assert.True(t, imported.IsEmpty())
+	assert.Equal(t, ErrInvalidExport, imported.ImportFrom(bytes.NewReader([]byte("hello"))))
+
+	assert.Nil(t, imported.ImportFrom(bytes.NewReader(stream)))
+	assert.Equal(t, ErrNotEmpty, imported.ImportFrom(bytes.NewReader(stream)))
+	assert.Equal(t, wal.ActiveSegmentID(), imported.ActiveSegmentID())
+	check := func(w *WAL) {
+		for i, pos := range positions {
+			val, err := w.Read(pos)
+			assert.Nil(t, err)
+			assert.Equal(t, fmt.Sprintf("entry-%d-%0200d", i, i), string(val))
+		}
+		n, err := w.Len()
+		assert.Nil(t, err)
+		assert.Equal(t, len(positions), n)
+	}
+	check(imported)
+
+	// the imported WAL can be written and reopened.
+	_, err = imported.Write([]byte("after"))
+	assert.Nil(t, err)
+	assert.Nil(t, imported.Close())
+	imported, err = Open(opts)
+	assert.Nil(t, err)
+	for i, pos := range positions {
+		val, err := imported.Read(pos)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("entry-%d-%0200d", i, i), string(val))
+	}
+}
+
+func TestWAL_ExportToTrimmed(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-export-trimmed")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    64 * KB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	var positions []*ChunkPosition
+	for i := 0; i < 1000; i++ {
+		pos, err := wal.Write([]byte(fmt.Sprintf("entry-%d-%0200d", i, i)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	trimAt := 600
+	assert.Nil(t, wal.TrimFront(positions[trimAt]))
+
+	var buf bytes.Buffer
+	assert.Nil(t, wal.ExportTo(&buf))
+	opts.DirPath, _ = os.MkdirTemp("", "wal-test-import-trimmed")
+	imported, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() { destroyWAL(imported) }()
+	assert.Nil(t, imported.ImportFrom(bytes.NewReader(buf.Bytes())))
+
+	// the trimmed entries stay trimmed after the import and reopening.
+	check := func(w *WAL) {
+		first, err := w.FirstPosition()
+		assert.Nil(t, err)
+		assert.Equal(t, positions[trimAt].SegmentId, first.SegmentId)
+		assert.Equal(t, positions[trimAt].ChunkOffset, first.ChunkOffset)
+		_, err = w.Read(positions[trimAt-1])
+		assert.ErrorIs(t, err, ErrPositionTruncated)
+		val, err := w.Read(positions[trimAt])
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("entry-%d-%0200d", trimAt, trimAt), string(val))
+		n, err := w.Len()
+		assert.Nil(t, err)
+		assert.Equal(t, len(positions)-trimAt, n)
+	}
+	check(imported)
+	assert.Nil(t, imported.Close())
+	imported, err = Open(opts)
+	assert.Nil(t, err)
+	check(imported)
+}
+
+// failOpenFileSystem wraps the OSFileSystem and fails opening the file at path
+// for the writes of a segment file once armed.
+type failOpenFileSystem struct {
+	osFileSystem
+	path  string
+	armed atomic.Bool
+}
+
+func (fs *failOpenFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if name == fs.path && flag&os.O_APPEND != 0 && fs.armed.CompareAndSwap(true, false) {
+		return nil, errNoSpace
+	}
+	return fs.osFileSystem.OpenFile(name, flag, perm)
+}
+
+func TestWAL_ImportFrom_OpenFailed(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-export-open-failed")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    64 * KB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+	for i := 0; i < 1000; i++ {
+		_, err := wal.Write([]byte(fmt.Sprintf("entry-%d-%0200d", i, i)))
+		assert.Nil(t, err)
+	}
+	var buf bytes.Buffer
+	assert.Nil(t, wal.ExportTo(&buf))
+
+	opts.DirPath, _ = os.MkdirTemp("", "wal-test-import-open-failed")
+	fs := &failOpenFileSystem{path: SegmentFileName(opts.DirPath, ".SEG", wal.ActiveSegmentID())}
+	opts.FileSystem = fs
+	imported, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() { destroyWAL(imported) }()
+
+	// the WAL is left empty and writable if the active segment file fails to open.
+	fs.armed.Store(true)
+	assert.Equal(t, errNoSpace, imported.ImportFrom(bytes.NewReader(buf.Bytes())))
+	assert.True(t, imported.IsEmpty())
+	entries, err := os.ReadDir(opts.DirPath)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	pos, err := imported.Write([]byte("hello"))
+	assert.Nil(t, err)
+	val, err := imported.Read(pos)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(val))
+}
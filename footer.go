@@ -0,0 +1,310 @@
+package wal
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// When a segment file is sealed, that is, it stops being the active segment file,
+// a footer is appended to it as an internal entry.
+// The footer contains the offset of the first entry starting in every block,
+// so a reader can jump to any block directly instead of scanning the segment file.
+//
+// It also contains the range of the segment file, that is the positions of the first
+// and the last entries, and the range of the write timestamps, so the readers can skip
+// the whole segment file without reading it.
+//
+// The data of the footer entry:
+//
+//	+----------+-------------+-------------+-------------+--- ... ---+-------+---------+
+//	| Kind(1B) | Version(1B) | Entries(4B) | Blocks(4B)  |  Offsets  | Range | Trailer |
+//	+----------+-------------+-------------+-------------+--- ... ---+-------+---------+
+//
+//	Offsets = 2 bytes offset for every block, 0xFFFF if no entry starts in the block
+//	Range   = Block number(4B) + Chunk offset(4B) of the first and the last entries
+//	          + Min timestamp(8B) + Max timestamp(8B) + Flags(1B), see footerRangeTimed
+//	Trailer = Block number(4B) + Chunk offset(4B) of the footer entry + Magic(4B)
+//
+// The trailer is at the end of the segment file, so the footer can be found when opening.
+// A segment file without footer, e.g. the process crashed before sealing it,
+// is still readable, but the reader has to scan it.
+// The footer of version 1 has no range, it is still loaded, but the readers can't skip
+// the segment file by it. The older versions treat the segment files with a version 2
+// footer as footerless ones.
+const (
+	internalKindFooter byte = 1
+	footerVersion      byte = 2
+	footerVersionV1    byte = 1
+	footerMagic             = 0x57414C46 // "WALF"
+	footerHeaderSize        = 10
+	footerRangeSize         = 33
+	footerTrailerSize       = 12
+
+	// footerRangeTimed means some entries of the segment file have a write timestamp,
+	// and footerRangeContinued means the segment file starts with a fragment of an entry
+	// spanning from the previous segment file.
+	footerRangeTimed     byte = 1 << 0
+	footerRangeContinued byte = 1 << 1
+
+	// noEntryInBlock means no entry starts in the block,
+	// the block is fully occupied by the middle chunks of a large entry.
+	noEntryInBlock = math.MaxUint16
+)
+
+// segmentIndex records the offset of the first entry starting in every block of a segment file.
+type segmentIndex struct {
+	// blocks is the offset of the first entry starting in every block.
+	blocks []uint16
+	// entries is the number of entries in the segment file.
+	entries uint32
+	// complete is whether all the entries of the segment file are recorded,
+	// it is false for a footerless segment file opened from the disk.
+	complete bool
+
+	// first and last are the block numbers and the chunk offsets of the first
+	// and the last entries, they are valid if entries is not zero.
+	firstBlock, lastBlock   uint32
+	firstOffset, lastOffset int64
+	// minTime and maxTime are the range of the write timestamps in unix nanoseconds,
+	// they are valid if timed is true, that is some entries have a timestamp.
+	minTime, maxTime int64
+	timed            bool
+	// continued is whether the segment file starts with a fragment of an entry
+	// spanning from the previous segment file, which is not recorded as an entry.
+	continued bool
+	// noRange is whether the range above is not recorded, the index is loaded from a version 1 footer.
+	noRange bool
+}
+
+// add records the entry starting at the position,
+// and its write timestamp if timed is true.
+func (idx *segmentIndex) add(pos *ChunkPosition, timestamp int64, timed bool) {
+	if idx.entries == 0 {
+		idx.firstBlock, idx.firstOffset = pos.BlockNumber, pos.ChunkOffset
+	}
+	idx.lastBlock, idx.lastOffset = pos.BlockNumber, pos.ChunkOffset
+	if timed {
+		if !idx.timed || timestamp < idx.minTime {
+			idx.minTime = timestamp
+		}
+		if !idx.timed || timestamp > idx.maxTime {
+			idx.maxTime = timestamp
+		}
+		idx.timed = true
+	}
+	idx.entries++
+	for uint32(len(idx.blocks)) < pos.BlockNumber {
+		idx.blocks = append(idx.blocks, noEntryInBlock)
+	}
+	if uint32(len(idx.blocks)) == pos.BlockNumber {
+		idx.blocks = append(idx.blocks, uint16(pos.ChunkOffset))
+	}
+}
+
+// seek returns the position of the first entry starting in or after the block,
+// and false if there is no such entry in the index.
+func (idx *segmentIndex) seek(blockNumber uint32) (uint32, int64, bool) {
+	for b := blockNumber; b < uint32(len(idx.blocks)); b++ {
+		if idx.blocks[b] != noEntryInBlock {
+			return b, int64(idx.blocks[b]), true
+		}
+	}
+	return 0, 0, false
+}
+
+// hasRange reports whether the index records the range of the segment file,
+// the index of the active segment file changes with the writes.
+func (idx *segmentIndex) hasRange() bool {
+	return idx.complete && !idx.noRange
+}
+
+// endsBefore reports whether all the entries of the segment file are before the position
+// in the segment file, it must be called only if the index has the range.
+func (idx *segmentIndex) endsBefore(pos *ChunkPosition) bool {
+	return idx.entries == 0 || idx.lastBlock < pos.BlockNumber ||
+		idx.lastBlock == pos.BlockNumber && idx.lastOffset < pos.ChunkOffset
+}
+
+// overlaps reports whether any entry of the segment file may be written in [start, end),
+// it must be called only if the index has the range.
+func (idx *segmentIndex) overlaps(start, end int64) bool {
+	return idx.timed && idx.minTime < end && idx.maxTime >= start
+}
+
+// rawTimestamp returns the write timestamp in the prefix of the data written with the flags,
+// and false if there is none. The data of a fragment of an entry spanning segment files
+// starts with the kind of the fragment, and only the first fragment has the prefix.
+func rawTimestamp(data []byte, flags byte) (int64, bool) {
+	if flags&entryFlagTimestamp == 0 {
+		return 0, false
+	}
+	if flags&entryFlagSpan != 0 {
+		if len(data) == 0 || data[0] != spanFirst {
+			return 0, false
+		}
+		data = data[1:]
+	}
+	if len(data) < timestampSize {
+		return 0, false
+	}
+	return int64(binary.LittleEndian.Uint64(data[:timestampSize])), true
+}
+
+// writeTime returns the write timestamp of the entry read from the segment file,
+// the prefix of the first fragment of a spanning entry is not decoded yet.
+func (e *chunkEntry) writeTime() (int64, bool) {
+	if e.flags&entryFlagSpan != 0 {
+		return rawTimestamp(e.data, e.flags)
+	}
+	return e.timestamp, e.flags&entryFlagTimestamp != 0
+}
+
+// startsWithSpan reports whether the segment file starts with a fragment
+// of an entry spanning from the previous segment file.
+func (seg *segment) startsWithSpan() bool {
+	entry, err := seg.readInternal(0, 0, readOptions{skipData: true})
+	return err == nil && entry.isSpanContinuation()
+}
+
+// buildIndex rebuilds the index by scanning the whole segment file.
+func (seg *segment) buildIndex() error {
+	index := &segmentIndex{complete: true, continued: seg.startsWithSpan()}
+	reader := seg.NewReader()
+	for {
+		entry, pos, err := reader.next(true)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		timestamp, timed := entry.writeTime()
+		index.add(pos, timestamp, timed)
+	}
+	seg.index = index
+	return nil
+}
+
+// maxFooterSize returns the size of the footer of a full segment file of segmentSize,
+// which has an offset for every block.
+func maxFooterSize(segmentSize int64) int64 {
+	return footerHeaderSize + 2*(segmentSize/blockSize+1) + footerRangeSize + footerTrailerSize
+}
+
+// writeFooter seals the segment file by appending the footer.
+// It does nothing if the segment file already has a footer.
+func (seg *segment) writeFooter() error {
+	if seg.closed.Load() || seg.hasFooter {
+		return nil
+	}
+	if !seg.index.complete {
+		if err := seg.buildIndex(); err != nil {
+			return err
+		}
+	}
+
+	// the position where the footer entry will start.
+	blockNumber, chunkOffset := seg.currentBlockNumber, seg.currentBlockSize
+	if chunkOffset+chunkHeaderSize >= blockSize {
+		blockNumber, chunkOffset = blockNumber+1, 0
+	}
+
+	index := seg.index
+	blocks := index.blocks
+	buf := make([]byte, footerHeaderSize+2*len(blocks)+footerRangeSize+footerTrailerSize)
+	buf[0] = internalKindFooter
+	buf[1] = footerVersion
+	binary.LittleEndian.PutUint32(buf[2:6], index.entries)
+	binary.LittleEndian.PutUint32(buf[6:10], uint32(len(blocks)))
+	for i, offset := range blocks {
+		binary.LittleEndian.PutUint16(buf[footerHeaderSize+2*i:], offset)
+	}
+	rng := buf[footerHeaderSize+2*len(blocks):]
+	binary.LittleEndian.PutUint32(rng[0:4], index.firstBlock)
+	binary.LittleEndian.PutUint32(rng[4:8], uint32(index.firstOffset))
+	binary.LittleEndian.PutUint32(rng[8:12], index.lastBlock)
+	binary.LittleEndian.PutUint32(rng[12:16], uint32(index.lastOffset))
+	binary.LittleEndian.PutUint64(rng[16:24], uint64(index.minTime))
+	binary.LittleEndian.PutUint64(rng[24:32], uint64(index.maxTime))
+	if index.timed {
+		rng[32] |= footerRangeTimed
+	}
+	if index.continued {
+		rng[32] |= footerRangeContinued
+	}
+	trailer := buf[len(buf)-footerTrailerSize:]
+	binary.LittleEndian.PutUint32(trailer[0:4], blockNumber)
+	binary.LittleEndian.PutUint32(trailer[4:8], chunkOffset)
+	binary.LittleEndian.PutUint32(trailer[8:12], footerMagic)
+
+	if _, err := seg.writeWithFlags(buf, entryFlagInternal); err != nil {
+		return err
+	}
+	seg.hasFooter = true
+	return nil
+}
+
+// loadFooter loads the index from the footer at the end of the segment file.
+// The segment file is treated as footerless if the footer is missing or broken.
+func (seg *segment) loadFooter() {
+	size := seg.Size()
+	if size < chunkHeaderSize+footerHeaderSize+footerTrailerSize {
+		return
+	}
+	trailer := make([]byte, footerTrailerSize)
+	if _, err := seg.readFd.ReadAt(trailer, size-footerTrailerSize); err != nil {
+		return
+	}
+	if binary.LittleEndian.Uint32(trailer[8:12]) != footerMagic {
+		return
+	}
+
+	blockNumber := binary.LittleEndian.Uint32(trailer[0:4])
+	chunkOffset := binary.LittleEndian.Uint32(trailer[4:8])
+	if chunkOffset >= blockSize {
+		return
+	}
+	entry, err := seg.readInternal(blockNumber, int64(chunkOffset), readOptions{})
+	if err != nil || entry.flags&entryFlagInternal == 0 {
+		return
+	}
+	// the footer entry must be the last one in the segment file.
+	if int64(entry.next.BlockNumber)*blockSize+entry.next.ChunkOffset < size {
+		return
+	}
+
+	buf := entry.data
+	if len(buf) < footerHeaderSize+footerTrailerSize || buf[0] != internalKindFooter ||
+		buf[1] != footerVersion && buf[1] != footerVersionV1 {
+		return
+	}
+	rangeSize := uint64(footerRangeSize)
+	if buf[1] == footerVersionV1 {
+		rangeSize = 0
+	}
+	entries := binary.LittleEndian.Uint32(buf[2:6])
+	blockCount := binary.LittleEndian.Uint32(buf[6:10])
+	if uint64(len(buf)) != footerHeaderSize+2*uint64(blockCount)+rangeSize+footerTrailerSize {
+		return
+	}
+	blocks := make([]uint16, blockCount)
+	for i := range blocks {
+		blocks[i] = binary.LittleEndian.Uint16(buf[footerHeaderSize+2*i:])
+	}
+
+	index := &segmentIndex{blocks: blocks, entries: entries, complete: true, noRange: rangeSize == 0}
+	if rangeSize > 0 {
+		rng := buf[footerHeaderSize+2*blockCount:]
+		index.firstBlock = binary.LittleEndian.Uint32(rng[0:4])
+		index.firstOffset = int64(binary.LittleEndian.Uint32(rng[4:8]))
+		index.lastBlock = binary.LittleEndian.Uint32(rng[8:12])
+		index.lastOffset = int64(binary.LittleEndian.Uint32(rng[12:16]))
+		index.minTime = int64(binary.LittleEndian.Uint64(rng[16:24]))
+		index.maxTime = int64(binary.LittleEndian.Uint64(rng[24:32]))
+		index.timed = rng[32]&footerRangeTimed != 0
+		index.continued = rng[32]&footerRangeContinued != 0
+	}
+	seg.index = index
+	seg.hasFooter = true
+}
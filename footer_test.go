@@ -0,0 +1,270 @@
+package wal
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSegment_Footer(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "seg-test-footer")
+	seg, err := openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
+	assert.Nil(t, err)
+	defer func() {
+		_ = seg.Remove()
+	}()
+
+	var positions []*ChunkPosition
+	for i := 0; i < 1000; i++ {
+		// mix FULL chunks and the entries spanning several blocks.
+		val := []byte(strings.Repeat("X", (i%10)*blockSize/3))
+		pos, err := seg.Write(val)
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.True(t, seg.index.complete)
+	assert.Nil(t, seg.writeFooter())
+	assert.True(t, seg.hasFooter)
+	assert.Nil(t, seg.Close())
+
+	seg, err = openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
+	assert.Nil(t, err)
+	assert.True(t, seg.hasFooter)
+	assert.True(t, seg.index.complete)
+	assert.Equal(t, uint32(1000), seg.index.entries)
+
+	// the loaded index is the same as the one built by scanning.
+	loaded := seg.index
+	assert.Nil(t, seg.buildIndex())
+	assert.Equal(t, loaded.blocks, seg.index.blocks)
+	assert.Equal(t, loaded.entries, seg.index.entries)
+
+	// every entry can be found by seeking its block.
+	for i, pos := range positions {
+		if i > 0 && positions[i-1].BlockNumber == pos.BlockNumber {
+			continue
+		}
+		blockNumber, chunkOffset, ok := seg.index.seek(pos.BlockNumber)
+		assert.True(t, ok)
+		assert.Equal(t, pos.BlockNumber, blockNumber)
+		assert.Equal(t, pos.ChunkOffset, chunkOffset)
+	}
+
+	// the footer is skipped by the reader.
+	var count int
+	reader := seg.NewReader()
+	for {
+		_, _, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		count++
+	}
+	assert.Equal(t, 1000, count)
+}
+
+func TestSegment_Footer_Broken(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "seg-test-footer-broken")
+	seg, err := openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
+	assert.Nil(t, err)
+	defer func() {
+		_ = seg.Remove()
+	}()
+
+	for i := 0; i < 100; i++ {
+		_, err := seg.Write([]byte(strings.Repeat("X", 1000)))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, seg.Close())
+
+	// footerless segment file.
+	seg, err = openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
+	assert.Nil(t, err)
+	assert.False(t, seg.hasFooter)
+	assert.False(t, seg.index.complete)
+	assert.Nil(t, seg.writeFooter())
+	assert.True(t, seg.index.complete)
+	assert.Equal(t, uint32(100), seg.index.entries)
+	size := seg.Size()
+	assert.Nil(t, seg.Close())
+
+	// break the magic of the trailer.
+	fd, err := os.OpenFile(SegmentFileName(dir, ".SEG", 1), os.O_RDWR, 0)
+	assert.Nil(t, err)
+	_, err = fd.WriteAt([]byte{0}, size-1)
+	assert.Nil(t, err)
+	assert.Nil(t, fd.Close())
+
+	seg, err = openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
+	assert.Nil(t, err)
+	assert.False(t, seg.hasFooter)
+	assert.False(t, seg.index.complete)
+}
+
+func TestWAL_Footer(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-footer")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	testWriteAndIterate(t, wal, 5000, 512)
+	assert.True(t, len(wal.olderSegments) > 0)
+	for _, seg := range wal.olderSegments {
+		assert.True(t, seg.hasFooter)
+	}
+	assert.False(t, wal.activeSegment.hasFooter)
+
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	for _, seg := range wal.olderSegments {
+		assert.True(t, seg.hasFooter)
+	}
+
+	// the reader jumps to the block with the index.
+	reader, err := wal.NewReaderWithStart(&ChunkPosition{SegmentId: 3, BlockNumber: 5, ChunkOffset: 0})
+	assert.Nil(t, err)
+	_, pos, err := reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, SegmentID(3), pos.SegmentId)
+	assert.Equal(t, uint32(5), pos.BlockNumber)
+}
+
+func TestWAL_Footer_SegmentSize(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-footer-segment-size")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	// the sealed segment files with the footers are never larger than SegmentSize.
+	for i := 0; i < 30000; i++ {
+		_, err := wal.Write([]byte(strings.Repeat("X", 100)))
+		assert.Nil(t, err)
+	}
+	// the largest entry whose chunks fit in an empty segment file.
+	_, err = wal.Write([]byte(strings.Repeat("X", int(wal.maxFragmentSize(wal.segmentCapacity())))))
+	assert.Nil(t, err)
+	assert.Nil(t, wal.OpenNewActiveSegment())
+	assert.True(t, len(wal.olderSegments) > 2)
+	for _, seg := range wal.olderSegments {
+		assert.True(t, seg.hasFooter)
+		assert.True(t, seg.Size() <= opts.SegmentSize, "segment %d size %d", seg.id, seg.Size())
+	}
+
+	_, err = wal.Write([]byte(strings.Repeat("X", int(wal.segmentCapacity()))))
+	assert.Equal(t, ErrValueTooLarge, err)
+}
+
+func TestWAL_Footer_Range(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-footer-range")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+
+	// the entries of every segment file are written one second after the previous segment file.
+	base := time.Unix(1700000000, 0)
+	val := []byte(strings.Repeat("X", 100*KB))
+	positions := make(map[SegmentID][]*ChunkPosition)
+	for id := SegmentID(1); id <= 3; id++ {
+		for i := 0; i < 5; i++ {
+			pos, err := wal.WriteWithTime(val, base.Add(time.Duration(id)*time.Second+time.Duration(i)))
+			assert.Nil(t, err)
+			positions[id] = append(positions[id], pos)
+		}
+		assert.Nil(t, wal.OpenNewActiveSegment())
+	}
+	_, err = wal.Write(val)
+	assert.Nil(t, err)
+
+	// the range is loaded from the footers.
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	for id, ps := range positions {
+		index := wal.olderSegments[id].index
+		assert.True(t, index.hasRange())
+		assert.Equal(t, ps[0].BlockNumber, index.firstBlock)
+		assert.Equal(t, ps[0].ChunkOffset, index.firstOffset)
+		assert.Equal(t, ps[4].BlockNumber, index.lastBlock)
+		assert.Equal(t, ps[4].ChunkOffset, index.lastOffset)
+		assert.True(t, index.timed)
+		assert.Equal(t, base.Add(time.Duration(id)*time.Second).UnixNano(), index.minTime)
+		assert.Equal(t, base.Add(time.Duration(id)*time.Second+4).UnixNano(), index.maxTime)
+	}
+
+	// the sealed segment files out of the time range are skipped without reading them.
+	reader, err := wal.NewReaderWithTimeRange(base.Add(2*time.Second), base.Add(3*time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(reader.segmentReaders))
+	assert.Equal(t, SegmentID(2), reader.segmentReaders[0].segment.id)
+	for i := 0; i < 5; i++ {
+		_, pos, err := reader.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, positions[2][i], pos)
+	}
+	_, _, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// the segment file is skipped if the start is after its last entry.
+	last := positions[1][4]
+	reader, err = wal.NewReaderWithStart(&ChunkPosition{SegmentId: 1, BlockNumber: last.BlockNumber, ChunkOffset: last.ChunkOffset + 1})
+	assert.Nil(t, err)
+	_, pos, err := reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, positions[2][0], pos)
+}
+
+func TestWAL_NewReaderWithStart_ConcurrentWrite(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-reader-start-concurrent-write")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    32 * MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+	val := []byte(strings.Repeat("X", 512))
+	start, err := wal.Write(val)
+	assert.Nil(t, err)
+
+	// the index of the active segment file grows while the readers seek it.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			_, err := wal.Write(val)
+			assert.Nil(t, err)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		reader, err := wal.NewReaderWithStart(&ChunkPosition{SegmentId: start.SegmentId, BlockNumber: 10})
+		assert.Nil(t, err)
+		reader.Close()
+	}
+	<-done
+}
@@ -0,0 +1,108 @@
+package wal
+
+import (
+	"io"
+	"os"
+	"runtime"
+)
+
+// FileSystem abstracts the file system operations used by the WAL,
+// so the segment files can be stored in any kind of storage,
+// such as an in-memory or a network file system.
+//
+// The default FileSystem is the operating system's file system.
+type FileSystem interface {
+	// OpenFile opens the named file with the specified flag and perm, like os.OpenFile.
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+
+	// ReadDir reads the named directory and returns all its directory entries, like os.ReadDir.
+	ReadDir(name string) ([]os.DirEntry, error)
+
+	// MkdirAll creates a directory named path, along with any necessary parents, like os.MkdirAll.
+	MkdirAll(path string, perm os.FileMode) error
+
+	// Stat returns the os.FileInfo describing the named file, like os.Stat.
+	Stat(name string) (os.FileInfo, error)
+
+	// Remove removes the named file, like os.Remove.
+	Remove(name string) error
+
+	// Rename renames oldpath to newpath, like os.Rename.
+	Rename(oldpath, newpath string) error
+}
+
+// File represents an open file returned by FileSystem.OpenFile.
+// It is satisfied by *os.File.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.Seeker
+	io.Closer
+
+	// Name returns the name of the file as passed to FileSystem.OpenFile.
+	Name() string
+
+	// Stat returns the os.FileInfo describing the file.
+	Stat() (os.FileInfo, error)
+
+	// Sync commits the current contents of the file to stable storage.
+	Sync() error
+
+	// Truncate changes the size of the file.
+	Truncate(size int64) error
+}
+
+// osFileSystem is the FileSystem backed by the operating system.
+type osFileSystem struct{}
+
+// OSFileSystem is the FileSystem backed by the operating system,
+// it is used if Options.FileSystem is nil.
+var OSFileSystem FileSystem = osFileSystem{}
+
+func (osFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	fd, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return fd, nil
+}
+
+func (osFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFileSystem) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// syncDir syncs the directory, so the files created or renamed in it are durable.
+// Only the directories of the operating system's file system are synced,
+// the other file systems, such as the in-memory one, have no directory to sync,
+// and the directories can't be synced on windows.
+func syncDir(fs FileSystem, dir string) error {
+	if _, ok := fs.(osFileSystem); !ok || runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
@@ -0,0 +1,125 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingFileSystem wraps the OSFileSystem and counts the opened and removed files.
+type countingFileSystem struct {
+	osFileSystem
+	opened  atomic.Int32
+	removed atomic.Int32
+}
+
+func (fs *countingFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	fs.opened.Add(1)
+	return fs.osFileSystem.OpenFile(name, flag, perm)
+}
+
+func (fs *countingFileSystem) Remove(name string) error {
+	fs.removed.Add(1)
+	return fs.osFileSystem.Remove(name)
+}
+
+func TestWAL_FileSystem(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-file-system")
+	fs := &countingFileSystem{}
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		FileSystem:     fs,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+	// every segment file is opened twice, for writes and reads.
+	assert.Equal(t, int32(2), fs.opened.Load())
+
+	testWriteAndIterate(t, wal, 2000, 512)
+	assert.Equal(t, 2*int32(wal.ActiveSegmentID()), fs.opened.Load())
+
+	// the checkpoint file and its temporary file are removed too.
+	err = wal.Delete()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(wal.ActiveSegmentID())+2, fs.removed.Load())
+}
+
+var errNoSpace = errors.New("no space left on device")
+
+// limitedFileSystem wraps the OSFileSystem and fails the writes
+// after the limit of bytes is written, like a full disk.
+// If shortWrite is true, the failed writes return no error like a broken file system,
+// and the truncates fail if failTruncate is true.
+type limitedFileSystem struct {
+	osFileSystem
+	limit        atomic.Int64
+	shortWrite   atomic.Bool
+	failTruncate atomic.Bool
+}
+
+type limitedFile struct {
+	File
+	fs *limitedFileSystem
+}
+
+func (fs *limitedFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := fs.osFileSystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &limitedFile{File: f, fs: fs}, nil
+}
+
+func (f *limitedFile) Write(p []byte) (int, error) {
+	limit := f.fs.limit.Load()
+	if int64(len(p)) <= limit {
+		f.fs.limit.Add(-int64(len(p)))
+		return f.File.Write(p)
+	}
+	n, _ := f.File.Write(p[:limit])
+	f.fs.limit.Add(-int64(n))
+	if f.fs.shortWrite.Load() {
+		return n, nil
+	}
+	return n, errNoSpace
+}
+
+func (f *limitedFile) Truncate(size int64) error {
+	if f.fs.failTruncate.Load() {
+		return errNoSpace
+	}
+	return f.File.Truncate(size)
+}
+
+func TestWAL_SyncDir(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-sync-dir")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    32 * KB,
+		FileSystem:     OSFileSystem,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	// the directory is synced once by Sync after all the rotations.
+	assert.True(t, wal.dirDirty)
+	assert.Nil(t, wal.Sync())
+	assert.False(t, wal.dirDirty)
+	testWriteAndIterate(t, wal, 100, 1024)
+	assert.True(t, wal.ActiveSegmentID() > 1)
+	assert.True(t, wal.dirDirty)
+	assert.Nil(t, wal.Sync())
+	assert.False(t, wal.dirDirty)
+	assert.Nil(t, wal.Sync())
+
+	// the in-memory file system has no directory to sync.
+	assert.Nil(t, syncDir(NewMemoryFileSystem(), dir))
+}
@@ -0,0 +1,229 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+var ErrReadOnly = errors.New("the segment file is read only")
+
+// SegmentLoader loads the segment files which are not in the WAL directory,
+// such as the ones archived to a remote storage and then removed by retention.
+// It makes tiered storage possible: the hot segment files are on the local disk,
+// and the cold ones are fetched on demand.
+type SegmentLoader interface {
+	// LoadSegment returns the content and the size of the segment file.
+	// If the returned io.ReaderAt is also an io.Closer, it is closed when the WAL is closed.
+	// It should return an error wrapping os.ErrNotExist if the segment file can not be found.
+	LoadSegment(id SegmentID) (io.ReaderAt, int64, error)
+}
+
+// readOnlyFile is a File backed by an io.ReaderAt, all the writes to it fail.
+type readOnlyFile struct {
+	name   string
+	r      io.ReaderAt
+	size   int64
+	offset int64
+}
+
+// readOnlyFileInfo describes a readOnlyFile.
+type readOnlyFileInfo struct {
+	name string
+	size int64
+}
+
+// openReaderAtSegment opens a read only segment whose content is read from r,
+// the chunks are verified as the options specify.
+func openReaderAtSegment(id SegmentID, name string, r io.ReaderAt, size int64, options *Options) *segment {
+	fd := &readOnlyFile{name: name, r: r, size: size}
+	seg := &segment{
+		id:                 id,
+		fd:                 fd,
+		readFd:             fd,
+		header:             make([]byte, chunkHeaderSize),
+		checksum:           newChecksummer(options),
+		currentBlockNumber: uint32(size / blockSize),
+		currentBlockSize:   uint32(size % blockSize),
+		startupBlock: &startupBlock{
+			block:       make([]byte, blockSize),
+			blockNumber: -1,
+		},
+		index:                &segmentIndex{complete: size == 0},
+		path:                 name,
+		parallelChecksumSize: options.ParallelChecksumThreshold,
+	}
+	seg.writtenSize.Store(size)
+	if size > 0 {
+		seg.loadFooter()
+	}
+	return seg
+}
+
+// maxMissingSegments is the max number of the segment files remembered as not found,
+// see Options.SegmentLoaderMissTTL, all of them are forgotten when it is reached.
+const maxMissingSegments = 1024
+
+// missingSegment is a segment file not found by options.SegmentLoader.
+type missingSegment struct {
+	err      error
+	expireAt time.Time
+}
+
+// loadSegment loads the segment file by options.SegmentLoader,
+// the loaded segment file is kept until the WAL is closed.
+// It must be called with the WAL lock held.
+func (wal *WAL) loadSegment(id SegmentID) (*segment, error) {
+	if wal.loadedSegments == nil {
+		return nil, ErrClosed
+	}
+	if seg, ok := wal.loadedSegments[id]; ok {
+		return seg, nil
+	}
+	if missing, ok := wal.missingSegments[id]; ok {
+		if time.Now().Before(missing.expireAt) {
+			return nil, missing.err
+		}
+		delete(wal.missingSegments, id)
+	}
+	r, size, err := wal.options.SegmentLoader.LoadSegment(id)
+	if err != nil {
+		err = fmt.Errorf("load segment file %d%s failed: %w", id, wal.options.SegmentFileExt, err)
+		if wal.options.SegmentLoaderMissTTL > 0 && errors.Is(err, os.ErrNotExist) {
+			if wal.missingSegments == nil || len(wal.missingSegments) >= maxMissingSegments {
+				wal.missingSegments = make(map[SegmentID]missingSegment)
+			}
+			wal.missingSegments[id] = missingSegment{err: err, expireAt: time.Now().Add(wal.options.SegmentLoaderMissTTL)}
+		}
+		return nil, err
+	}
+	name := wal.segmentFileName(id)
+	seg := openReaderAtSegment(id, name, r, size, &wal.options)
+	wal.loadedSegments[id] = seg
+	wal.missingSegments = nil
+	return seg, nil
+}
+
+func (f *readOnlyFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *readOnlyFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	if off+int64(len(p)) > f.size {
+		n, err := f.r.ReadAt(p[:f.size-off], off)
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+	return f.r.ReadAt(p, off)
+}
+
+func (f *readOnlyFile) Write([]byte) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (f *readOnlyFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *readOnlyFile) Close() error {
+	if closer, ok := f.r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (f *readOnlyFile) Name() string {
+	return f.name
+}
+
+func (f *readOnlyFile) Stat() (os.FileInfo, error) {
+	return &readOnlyFileInfo{name: f.name, size: f.size}, nil
+}
+
+func (f *readOnlyFile) Sync() error {
+	return nil
+}
+
+func (f *readOnlyFile) Truncate(int64) error {
+	return ErrReadOnly
+}
+
+func (fi *readOnlyFileInfo) Name() string       { return fi.name }
+func (fi *readOnlyFileInfo) Size() int64        { return fi.size }
+func (fi *readOnlyFileInfo) Mode() os.FileMode  { return 0444 }
+func (fi *readOnlyFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi *readOnlyFileInfo) IsDir() bool        { return false }
+func (fi *readOnlyFileInfo) Sys() any           { return nil }
+
+// SegmentExtent describes where a segment file lives in the io.ReaderAt of OpenReaderAt.
+type SegmentExtent struct {
+	// ID is the id of the segment file.
+	ID SegmentID
+	// Offset is where the segment file starts in the io.ReaderAt.
+	Offset int64
+	// Size is the size of the segment file in bytes.
+	Size int64
+}
+
+// OpenReaderAt opens a read only WAL whose segment files are read from r,
+// such as a WAL embedded in a larger file which concatenates the segment files,
+// the segmentLayout describes where every segment file lives in r.
+// The segment file with the highest id is the active one, and the others are the older ones.
+//
+// All the read operations work as the WAL opened by Open, with the same chunk decoding,
+// and the writes return ErrReadOnly. The options are DefaultOptions without a directory.
+// The r is not closed when the WAL is closed.
+func OpenReaderAt(r io.ReaderAt, segmentLayout []SegmentExtent) (*WAL, error) {
+	if len(segmentLayout) == 0 {
+		return nil, errors.New("the segment layout is empty")
+	}
+	options := DefaultOptions
+	options.DirPath = ""
+	options.Logger = nopLogger{}
+	wal := &WAL{
+		options:        options,
+		syncPolicy:     options.syncPolicy(),
+		olderSegments:  make(map[SegmentID]*segment),
+		loadedSegments: make(map[SegmentID]*segment),
+		pendingWrites:  make([][]byte, 0),
+		closeC:         make(chan struct{}),
+		readOnly:       true,
+	}
+
+	for _, extent := range segmentLayout {
+		if extent.Offset < 0 || extent.Size < 0 {
+			return nil, fmt.Errorf("invalid extent of segment file %d%s, offset %d, size %d",
+				extent.ID, options.SegmentFileExt, extent.Offset, extent.Size)
+		}
+		if _, ok := wal.olderSegments[extent.ID]; ok {
+			return nil, fmt.Errorf("segment file %d%s: %w", extent.ID, options.SegmentFileExt, ErrDuplicateSegment)
+		}
+		section := io.NewSectionReader(r, extent.Offset, extent.Size)
+		seg := openReaderAtSegment(extent.ID, wal.segmentFileName(extent.ID), section, extent.Size, &options)
+		wal.olderSegments[extent.ID] = seg
+		if wal.activeSegment == nil || seg.id > wal.activeSegment.id {
+			wal.activeSegment = seg
+		}
+	}
+	delete(wal.olderSegments, wal.activeSegment.id)
+	return wal, nil
+}
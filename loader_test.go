@@ -0,0 +1,197 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dirSegmentLoader loads the segment files archived in a directory.
+type dirSegmentLoader struct {
+	dir   string
+	loads int
+}
+
+func (l *dirSegmentLoader) LoadSegment(id SegmentID) (io.ReaderAt, int64, error) {
+	l.loads++
+	fd, err := os.Open(SegmentFileName(l.dir, ".SEG", id))
+	if err != nil {
+		return nil, 0, err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, 0, err
+	}
+	return fd, stat.Size(), nil
+}
+
+func TestWAL_SegmentLoader(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-segment-loader")
+	archiveDir, _ := os.MkdirTemp("", "wal-test-segment-loader-archive")
+	defer func() {
+		_ = os.RemoveAll(archiveDir)
+	}()
+
+	loader := &dirSegmentLoader{dir: archiveDir}
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		MaxSegments:    2,
+		SegmentLoader:  loader,
+		// archive the sealed segment files before they are evicted.
+		OnSegmentSealed: func(segId SegmentID, path string) error {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(filepath.Join(archiveDir, filepath.Base(path)), data, 0644)
+		},
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	var positions []*ChunkPosition
+	for i := 0; i < 5000; i++ {
+		pos, err := wal.Write([]byte(fmt.Sprintf("wal-%d-%0500d", i, i)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.Equal(t, 1, len(wal.olderSegments))
+
+	for i, pos := range positions {
+		val, err := wal.Read(pos)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("wal-%d-%0500d", i, i), string(val))
+	}
+	// every evicted segment file is loaded only once.
+	assert.Equal(t, int(wal.ActiveSegmentID())-2, loader.loads)
+
+	_, err = wal.Read(&ChunkPosition{SegmentId: wal.ActiveSegmentID() + 10})
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestOpenReaderAt(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-open-reader-at")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    64 * KB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	var positions []*ChunkPosition
+	for i := 0; i < 1000; i++ {
+		pos, err := wal.Write([]byte(fmt.Sprintf("entry-%d-%0200d", i, i)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.Nil(t, wal.Sync())
+
+	// concatenate the segment files after a header, like a single file database.
+	buf := []byte("header")
+	var layout []SegmentExtent
+	for id := SegmentID(1); id <= wal.ActiveSegmentID(); id++ {
+		data, err := os.ReadFile(wal.SegmentPath(id))
+		assert.Nil(t, err)
+		layout = append(layout, SegmentExtent{ID: id, Offset: int64(len(buf)), Size: int64(len(data))})
+		buf = append(buf, data...)
+	}
+
+	_, err = OpenReaderAt(bytes.NewReader(buf), nil)
+	assert.NotNil(t, err)
+	_, err = OpenReaderAt(bytes.NewReader(buf), append(layout, layout[0]))
+	assert.ErrorIs(t, err, ErrDuplicateSegment)
+
+	embedded, err := OpenReaderAt(bytes.NewReader(buf), layout)
+	assert.Nil(t, err)
+	defer func() {
+		_ = embedded.Close()
+	}()
+	assert.Equal(t, wal.ActiveSegmentID(), embedded.ActiveSegmentID())
+	for i, pos := range positions {
+		val, err := embedded.Read(pos)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("entry-%d-%0200d", i, i), string(val))
+	}
+	reader := embedded.NewReader()
+	for i := range positions {
+		val, _, err := reader.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("entry-%d-%0200d", i, i), string(val))
+	}
+	_, _, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+	n, err := embedded.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, len(positions), n)
+
+	_, err = embedded.Write([]byte("data"))
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.ErrorIs(t, embedded.OpenNewActiveSegment(), ErrReadOnly)
+	assert.ErrorIs(t, embedded.Delete(), ErrReadOnly)
+}
+
+func TestWAL_SegmentLoaderMissTTL(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-segment-loader-miss")
+	archiveDir, _ := os.MkdirTemp("", "wal-test-segment-loader-miss-archive")
+	defer func() {
+		_ = os.RemoveAll(archiveDir)
+	}()
+
+	loader := &dirSegmentLoader{dir: archiveDir}
+	opts := Options{
+		DirPath:              dir,
+		SegmentFileExt:       ".SEG",
+		SegmentSize:          MB,
+		SegmentLoader:        loader,
+		SegmentLoaderMissTTL: time.Hour,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	// the missing segment file is only looked up once within the ttl.
+	missing := &ChunkPosition{SegmentId: 100}
+	for i := 0; i < 3; i++ {
+		_, err = wal.Read(missing)
+		assert.True(t, errors.Is(err, os.ErrNotExist))
+	}
+	assert.Equal(t, 1, loader.loads)
+
+	// it is forgotten after a rotation, and found once archived.
+	pos, err := wal.Write([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Nil(t, wal.Sync())
+	data, err := os.ReadFile(SegmentFileName(dir, ".SEG", pos.SegmentId))
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(SegmentFileName(archiveDir, ".SEG", 100), data, 0644))
+	_, err = wal.Read(missing)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	assert.Equal(t, 1, loader.loads)
+	assert.Nil(t, wal.OpenNewActiveSegment())
+	val, err := wal.Read(&ChunkPosition{SegmentId: 100, ChunkOffset: pos.ChunkOffset})
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(val))
+	assert.Equal(t, 2, loader.loads)
+
+	// it is looked up again after the ttl.
+	wal.options.SegmentLoaderMissTTL = time.Millisecond
+	_, err = wal.Read(&ChunkPosition{SegmentId: 200})
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	time.Sleep(2 * time.Millisecond)
+	_, err = wal.Read(&ChunkPosition{SegmentId: 200})
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	assert.Equal(t, 4, loader.loads)
+}
@@ -0,0 +1,35 @@
+package wal
+
+import "time"
+
+// Logger logs the notable events of the WAL, such as the rotations, the repairs
+// and the failed writes, the messages are formatted like fmt.Sprintf.
+// It must be safe for concurrent use, and it is called with the WAL lock held
+// in the most cases, so it should not block.
+type Logger interface {
+	Debugf(format string, args ...any)
+	Warnf(format string, args ...any)
+	Errorf(format string, args ...any)
+}
+
+// nopLogger discards all the messages, it is used if Options.Logger is nil.
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...any) {}
+func (nopLogger) Warnf(string, ...any)  {}
+func (nopLogger) Errorf(string, ...any) {}
+
+// lockContentionThreshold is how long a write waits for the WAL lock before it is logged.
+const lockContentionThreshold = 100 * time.Millisecond
+
+// lock acquires the WAL lock for the writes, and logs if it waits too long.
+func (wal *WAL) lock() {
+	if wal.mu.TryLock() {
+		return
+	}
+	start := time.Now()
+	wal.mu.Lock()
+	if d := time.Since(start); d >= lockContentionThreshold {
+		wal.options.Logger.Debugf("wal: waited %v for the lock to write", d)
+	}
+}
@@ -0,0 +1,71 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordLogger records the messages logged by the WAL.
+type recordLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordLogger) log(level, format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordLogger) Debugf(format string, args ...any) { l.log("DEBUG", format, args...) }
+func (l *recordLogger) Warnf(format string, args ...any)  { l.log("WARN", format, args...) }
+func (l *recordLogger) Errorf(format string, args ...any) { l.log("ERROR", format, args...) }
+
+func (l *recordLogger) contains(s string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range l.messages {
+		if strings.Contains(m, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWAL_Logger(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-logger")
+	logger := &recordLogger{}
+	fs := &limitedFileSystem{}
+	fs.limit.Store(GB)
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		FileSystem:     fs,
+		Logger:         logger,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	assert.Nil(t, wal.OpenNewActiveSegment())
+	assert.True(t, logger.contains("DEBUG wal: rotated the active segment file from 1 to 2"))
+
+	fs.limit.Store(10)
+	_, err = wal.Write([]byte(strings.Repeat("X", 100)))
+	assert.Equal(t, errNoSpace, err)
+	assert.True(t, logger.contains("ERROR wal: write to segment file 2 failed"))
+	fs.limit.Store(GB)
+
+	// the corrupted tail is truncated by Repair.
+	pos, err := wal.Write([]byte("hello"))
+	assert.Nil(t, err)
+	corruptChunk(t, wal, pos)
+	_, _ = wal.Repair()
+	assert.True(t, logger.contains("WARN wal: truncated the corrupted tail of segment file 2"))
+}
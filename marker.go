@@ -0,0 +1,53 @@
+package wal
+
+// internalKindMarker is the kind of the user markers written by WAL.WriteMarker,
+// the data of a marker is the kind followed by the payload.
+const internalKindMarker byte = 3
+
+// ChunkTypeMarker is reported by Reader.CurrentChunkType for the markers written by WAL.WriteMarker.
+// Notice that it is not stored in the chunk headers, whose chunk type only takes 2 bits,
+// the markers are stored as internal entries, so the older versions skip them.
+const ChunkTypeMarker ChunkType = ChunkTypeLast + 1
+
+// WriteMarker writes an application level marker with the payload to the WAL,
+// such as "snapshot taken here", it is ordered with the data written by the other writes.
+// The Reader returns the markers along with the data, the payload is returned by Next,
+// and Reader.CurrentChunkType tells them apart without decoding the payload.
+// WAL.Read reads the payload by the returned position as well.
+//
+// The markers are not counted by Len, not compressed, and skipped by the ReverseReader.
+// It returns ErrValueTooLarge if the payload does not fit in a segment file.
+func (wal *WAL) WriteMarker(payload []byte) (*ChunkPosition, error) {
+	data := make([]byte, 1+len(payload))
+	data[0] = internalKindMarker
+	copy(data[1:], payload)
+
+	wal.lock()
+	defer wal.unlock()
+	if int64(len(data))+chunkHeaderSize > wal.segmentCapacity() {
+		return nil, ErrValueTooLarge
+	}
+	return wal.writeLocked(data, entryFlagInternal)
+}
+
+// CurrentChunkType returns the type of the entry last returned by Next,
+// it is ChunkTypeMarker for a marker written by WAL.WriteMarker, and ChunkTypeFull for the data,
+// which is returned entirely no matter how many chunks it takes up.
+func (r *Reader) CurrentChunkType() ChunkType {
+	if r.lastEntry.flags&entryFlagInternal != 0 {
+		return ChunkTypeMarker
+	}
+	return ChunkTypeFull
+}
+
+// isMarker reports whether the entry is a user marker, whose kind is not stripped yet.
+func (e *chunkEntry) isMarker() bool {
+	return e.flags&entryFlagInternal != 0 && len(e.data) > 0 && e.data[0] == internalKindMarker
+}
+
+// stripMarkerKind strips the kind from the data of the user marker,
+// so only the payload is left. The entry must be a user marker.
+func (e *chunkEntry) stripMarkerKind() {
+	e.data = e.data[1:]
+	e.length--
+}
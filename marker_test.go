@@ -0,0 +1,91 @@
+package wal
+
+import (
+	"io"
+	"os"
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWAL_WriteMarker(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-write-marker")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	_, err = wal.Write([]byte("a"))
+	assert.Nil(t, err)
+	markerPos, err := wal.WriteMarker([]byte("snapshot"))
+	assert.Nil(t, err)
+	_, err = wal.Write([]byte("b"))
+	assert.Nil(t, err)
+	_, err = wal.WriteMarker(make([]byte, MB))
+	assert.Equal(t, ErrValueTooLarge, err)
+
+	val, err := wal.Read(markerPos)
+	assert.Nil(t, err)
+	assert.Equal(t, "snapshot", string(val))
+	results, err := wal.ReadBatch([]*ChunkPosition{markerPos})
+	assert.Nil(t, err)
+	assert.Equal(t, "snapshot", string(results[0]))
+
+	reader := wal.NewReader()
+	expected := []struct {
+		value     string
+		chunkType ChunkType
+	}{
+		{"a", ChunkTypeFull},
+		{"snapshot", ChunkTypeMarker},
+		{"b", ChunkTypeFull},
+	}
+	for _, e := range expected {
+		val, _, err := reader.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, e.value, string(val))
+		assert.Equal(t, e.chunkType, reader.CurrentChunkType())
+	}
+	_, _, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// the markers are not counted.
+	n, err := wal.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestWAL_WriteMarker_Many(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-write-marker-many")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    32 * MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+	for i := 0; i < 50000; i++ {
+		_, err := wal.WriteMarker(nil)
+		assert.Nil(t, err)
+	}
+	_, err = wal.Write([]byte("last"))
+	assert.Nil(t, err)
+
+	// the active segment file is scanned by Len after reopening, the skipped markers
+	// don't grow the stack of the reader.
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+	n, err := wal.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+}
@@ -0,0 +1,297 @@
+package wal
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// memFileSystem is a FileSystem that keeps all the files in memory.
+// It is mostly useful for tests, the data is lost when the process exits.
+type memFileSystem struct {
+	mu    sync.RWMutex
+	files map[string]*memFileData
+	dirs  map[string]struct{}
+}
+
+// memFileData is the content of a file in memFileSystem,
+// it is shared by all the opened handles of the file.
+type memFileData struct {
+	mu      sync.RWMutex
+	buf     []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// memFile is an opened handle of a file in memFileSystem.
+type memFile struct {
+	name   string
+	data   *memFileData
+	flag   int
+	offset int64
+	closed atomic.Bool
+}
+
+// memFileInfo describes a file in memFileSystem.
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	isDir   bool
+}
+
+// NewMemoryFileSystem returns a FileSystem that keeps all the files in memory.
+// The same FileSystem can be passed to Open again to reopen a WAL in it.
+func NewMemoryFileSystem() FileSystem {
+	return &memFileSystem{
+		files: make(map[string]*memFileData),
+		dirs:  make(map[string]struct{}),
+	}
+}
+
+// NewInMemory opens a WAL whose segment files are kept in memory,
+// all the other options are the same as Open.
+// It is mostly useful for tests, the data is lost when the WAL is no longer referenced.
+func NewInMemory(options Options) (*WAL, error) {
+	options.FileSystem = NewMemoryFileSystem()
+	return Open(options)
+}
+
+func (mfs *memFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	name = filepath.Clean(name)
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	data, ok := mfs.files[name]
+	if !ok {
+		if flag&os.O_CREATE == 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		if _, ok := mfs.dirs[filepath.Dir(name)]; !ok {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		data = &memFileData{mode: perm, modTime: time.Now()}
+		mfs.files[name] = data
+	} else if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	}
+
+	if flag&os.O_TRUNC != 0 {
+		data.mu.Lock()
+		data.buf = data.buf[:0]
+		data.mu.Unlock()
+	}
+	return &memFile{name: name, data: data, flag: flag}, nil
+}
+
+func (mfs *memFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	name = filepath.Clean(name)
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+
+	if _, ok := mfs.dirs[name]; !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	var entries []os.DirEntry
+	for path, data := range mfs.files {
+		if filepath.Dir(path) == name {
+			entries = append(entries, fs.FileInfoToDirEntry(data.stat(path)))
+		}
+	}
+	for path := range mfs.dirs {
+		if path != name && filepath.Dir(path) == name {
+			info := &memFileInfo{name: filepath.Base(path), mode: os.ModeDir | os.ModePerm, isDir: true}
+			entries = append(entries, fs.FileInfoToDirEntry(info))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (mfs *memFileSystem) MkdirAll(path string, _ os.FileMode) error {
+	path = filepath.Clean(path)
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	for {
+		mfs.dirs[path] = struct{}{}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return nil
+		}
+		path = parent
+	}
+}
+
+func (mfs *memFileSystem) Stat(name string) (os.FileInfo, error) {
+	name = filepath.Clean(name)
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+
+	if data, ok := mfs.files[name]; ok {
+		return data.stat(name), nil
+	}
+	if _, ok := mfs.dirs[name]; ok {
+		return &memFileInfo{name: filepath.Base(name), mode: os.ModeDir | os.ModePerm, isDir: true}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+func (mfs *memFileSystem) Remove(name string) error {
+	name = filepath.Clean(name)
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	if _, ok := mfs.files[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(mfs.files, name)
+	return nil
+}
+
+func (mfs *memFileSystem) Rename(oldpath, newpath string) error {
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	data, ok := mfs.files[oldpath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	delete(mfs.files, oldpath)
+	mfs.files[newpath] = data
+	return nil
+}
+
+func (data *memFileData) stat(name string) *memFileInfo {
+	data.mu.RLock()
+	defer data.mu.RUnlock()
+	return &memFileInfo{
+		name:    filepath.Base(name),
+		size:    int64(len(data.buf)),
+		mode:    data.mode,
+		modTime: data.modTime,
+	}
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed.Load() {
+		return 0, os.ErrClosed
+	}
+	f.data.mu.RLock()
+	defer f.data.mu.RUnlock()
+
+	if off >= int64(len(f.data.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data.buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if f.closed.Load() {
+		return 0, os.ErrClosed
+	}
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+	}
+	f.data.mu.Lock()
+	defer f.data.mu.Unlock()
+
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.data.buf))
+	}
+	if end := f.offset + int64(len(p)); end > int64(len(f.data.buf)) {
+		f.data.buf = append(f.data.buf, make([]byte, end-int64(len(f.data.buf)))...)
+	}
+	n := copy(f.data.buf[f.offset:], p)
+	f.offset += int64(n)
+	f.data.modTime = time.Now()
+	return n, nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed.Load() {
+		return 0, os.ErrClosed
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		f.data.mu.RLock()
+		offset += int64(len(f.data.buf))
+		f.data.mu.RUnlock()
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Close() error {
+	if !f.closed.CompareAndSwap(false, true) {
+		return os.ErrClosed
+	}
+	return nil
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	if f.closed.Load() {
+		return nil, os.ErrClosed
+	}
+	return f.data.stat(f.name), nil
+}
+
+func (f *memFile) Sync() error {
+	if f.closed.Load() {
+		return os.ErrClosed
+	}
+	return nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	if f.closed.Load() {
+		return os.ErrClosed
+	}
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: os.ErrInvalid}
+	}
+	f.data.mu.Lock()
+	defer f.data.mu.Unlock()
+
+	if size <= int64(len(f.data.buf)) {
+		f.data.buf = f.data.buf[:size]
+	} else {
+		f.data.buf = append(f.data.buf, make([]byte, size-int64(len(f.data.buf)))...)
+	}
+	return nil
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.isDir }
+func (fi *memFileInfo) Sys() any           { return nil }
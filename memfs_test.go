@@ -0,0 +1,89 @@
+package wal
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWAL_NewInMemory(t *testing.T) {
+	opts := Options{
+		DirPath:        filepath.Join(os.TempDir(), "wal-test-in-memory"),
+		SegmentFileExt: ".SEG",
+		SegmentSize:    32 * 1024 * 1024,
+	}
+	newWAL := func(t *testing.T) *WAL {
+		wal, err := NewInMemory(opts)
+		assert.Nil(t, err)
+		t.Cleanup(func() {
+			_ = wal.Close()
+		})
+		return wal
+	}
+
+	t.Run("write-all", func(t *testing.T) {
+		wal := newWAL(t)
+		testWriteAllIterate(t, wal, 0, 10)
+		assert.True(t, wal.IsEmpty())
+		testWriteAllIterate(t, wal, 10000, 512)
+		assert.False(t, wal.IsEmpty())
+	})
+	t.Run("write", func(t *testing.T) {
+		testWriteAndIterate(t, newWAL(t), 20000, 512)
+	})
+	t.Run("write-large", func(t *testing.T) {
+		testWriteAndIterate(t, newWAL(t), 200, 32*1024*3+10)
+	})
+
+	// nothing is written to the disk.
+	_, err := os.Stat(opts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWAL_MemoryFileSystem_Reopen(t *testing.T) {
+	opts := Options{
+		DirPath:          filepath.Join(os.TempDir(), "wal-test-memory-reopen"),
+		SegmentFileExt:   ".SEG",
+		SegmentSize:      8 * 1024 * 1024,
+		EnableCheckpoint: true,
+		FileSystem:       NewMemoryFileSystem(),
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	testWriteAndIterate(t, wal, 20000, 512)
+	assert.Nil(t, wal.Sync())
+	cp, err := wal.Checkpoint()
+	assert.Nil(t, err)
+	assert.NotNil(t, cp)
+	assert.Nil(t, wal.Close())
+
+	wal2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = wal2.Close()
+	}()
+	assert.Equal(t, cp.SegmentId, wal2.ActiveSegmentID())
+	cp2, err := wal2.Checkpoint()
+	assert.Nil(t, err)
+	assert.Equal(t, cp, cp2)
+
+	var count int
+	reader := wal2.NewReader()
+	for {
+		_, _, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		count++
+	}
+	assert.Equal(t, 20000, count)
+
+	assert.Nil(t, wal2.Delete())
+	entries, err := opts.FileSystem.ReadDir(opts.DirPath)
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,149 @@
+package wal
+
+import (
+	"container/heap"
+	"io"
+	"time"
+)
+
+// MergedTimeReader reads several WALs as one timeline, it yields the entries of all the WALs
+// in the order of their write timestamps by a k-way merge, such as to rebuild the timeline
+// of the events from the logs of the shards. The next entry of every WAL is kept in a heap
+// keyed on its timestamp, the entries with the same timestamp are ordered by the WAL index.
+//
+// The entries of one WAL are always yielded in the order they are written, so the timeline is
+// only globally ordered if the timestamps of every WAL are ascending, like the ones of WriteWithTime.
+// The entry not written by WriteWithTime has no timestamp, it is ordered by the timestamp
+// of the previous entry of its WAL, or by the zero time if there is none.
+//
+// Like the Reader, it takes a snapshot of the segment files of every WAL when it is created.
+// A MergedTimeReader is not safe for concurrent use by multiple goroutines.
+type MergedTimeReader struct {
+	readers []*Reader
+	heads   mergeHeap
+	primed  int // the number of the WALs whose first entries are pushed into the heap.
+	// refill is the index of the WAL whose next entry must be pushed into the heap
+	// before the next one is popped, -1 if there is none.
+	refill    int
+	lastTimes []int64 // the timestamp of the last entry of every WAL.
+	last      mergeHead
+}
+
+// mergeHead is the next entry of a WAL in the heap of a MergedTimeReader.
+type mergeHead struct {
+	entry     chunkEntry
+	position  *ChunkPosition
+	timestamp int64
+	source    int
+}
+
+type mergeHeap []mergeHead
+
+func (h mergeHeap) Len() int { return len(h) }
+
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].timestamp != h[j].timestamp {
+		return h[i].timestamp < h[j].timestamp
+	}
+	return h[i].source < h[j].source
+}
+
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap) Push(x any) { *h = append(*h, x.(mergeHead)) }
+
+func (h *mergeHeap) Pop() any {
+	old := *h
+	head := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return head
+}
+
+// NewMergedTimeReader returns a new reader yielding the entries of all the wals
+// in the order of their timestamps, see MergedTimeReader.
+func NewMergedTimeReader(wals ...*WAL) *MergedTimeReader {
+	readers := make([]*Reader, len(wals))
+	for i, wal := range wals {
+		readers[i] = wal.NewReader()
+	}
+	return &MergedTimeReader{
+		readers:   readers,
+		heads:     make(mergeHeap, 0, len(wals)),
+		refill:    -1,
+		lastTimes: make([]int64, len(wals)),
+	}
+}
+
+// Next returns the next entry data of all the WALs and its position in its WAL,
+// you can get the index of the WAL by Source, and the timestamp by Timestamp.
+// If there is no data, io.EOF will be returned.
+// If reading a WAL fails, the error is returned, and the following Next reads it again.
+func (r *MergedTimeReader) Next() ([]byte, *ChunkPosition, error) {
+	for r.primed < len(r.readers) {
+		if err := r.push(r.primed); err != nil {
+			return nil, nil, err
+		}
+		r.primed++
+	}
+	if r.refill >= 0 {
+		if err := r.push(r.refill); err != nil {
+			return nil, nil, err
+		}
+		r.refill = -1
+	}
+	if len(r.heads) == 0 {
+		return nil, nil, io.EOF
+	}
+	r.last = heap.Pop(&r.heads).(mergeHead)
+	r.refill = r.last.source
+	return r.last.entry.data, r.last.position, nil
+}
+
+// push reads the next entry of the WAL of the index into the heap,
+// the reader of the WAL is closed and dropped once it reaches the end.
+func (r *MergedTimeReader) push(source int) error {
+	entry, position, err := r.readers[source].next(false)
+	if err == io.EOF {
+		r.readers[source].Close()
+		r.readers[source] = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if entry.flags&entryFlagTimestamp != 0 {
+		r.lastTimes[source] = entry.timestamp
+	}
+	heap.Push(&r.heads, mergeHead{
+		entry:     entry,
+		position:  position,
+		timestamp: r.lastTimes[source],
+		source:    source,
+	})
+	return nil
+}
+
+// Source returns the index in the WALs passed to NewMergedTimeReader
+// of the WAL which the entry last returned by Next comes from.
+func (r *MergedTimeReader) Source() int {
+	return r.last.source
+}
+
+// Timestamp returns the write timestamp of the entry last returned by Next.
+// It returns the zero time if the entry is not written by WriteWithTime.
+func (r *MergedTimeReader) Timestamp() time.Time {
+	return r.last.entry.Time()
+}
+
+// Close releases the segment files referenced by the readers of all the WALs,
+// the reader can not be used after that.
+func (r *MergedTimeReader) Close() {
+	for i, reader := range r.readers {
+		if reader != nil {
+			reader.Close()
+			r.readers[i] = nil
+		}
+	}
+	r.heads = nil
+	r.primed, r.refill = len(r.readers), -1
+}
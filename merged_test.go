@@ -0,0 +1,74 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMergedTimeReader(t *testing.T) {
+	var wals []*WAL
+	for i := 0; i < 3; i++ {
+		dir, _ := os.MkdirTemp("", "wal-test-merged-time")
+		wal, err := Open(Options{
+			DirPath:        dir,
+			SegmentFileExt: ".SEG",
+			SegmentSize:    32 * KB,
+		})
+		assert.Nil(t, err)
+		defer destroyWAL(wal)
+		wals = append(wals, wal)
+	}
+
+	// the timestamps of the entries are interleaved across the WALs.
+	base := time.Unix(1700000000, 0)
+	var expected []string
+	for i := 0; i < 300; i++ {
+		source := (i * 7) % 3
+		data := fmt.Sprintf("%d-%d-%s", i, source, make([]byte, 500))
+		_, err := wals[source].WriteWithTime([]byte(data), base.Add(time.Duration(i)*time.Millisecond))
+		assert.Nil(t, err)
+		expected = append(expected, data)
+	}
+	// the entry without a timestamp follows the previous entry of its WAL, the last one.
+	_, err := wals[2].Write([]byte("untimed"))
+	assert.Nil(t, err)
+	assert.True(t, wals[0].ActiveSegmentID() > 1)
+
+	reader := NewMergedTimeReader(wals...)
+	defer reader.Close()
+	var i int
+	for ; ; i++ {
+		data, pos, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		if i == len(expected) {
+			assert.Equal(t, "untimed", string(data))
+			assert.Equal(t, 2, reader.Source())
+			assert.True(t, reader.Timestamp().IsZero())
+			continue
+		}
+		assert.Equal(t, expected[i], string(data))
+		assert.Equal(t, (i*7)%3, reader.Source())
+		assert.Equal(t, base.Add(time.Duration(i)*time.Millisecond).UnixNano(), reader.Timestamp().UnixNano())
+		val, err := wals[reader.Source()].Read(pos)
+		assert.Nil(t, err)
+		assert.Equal(t, expected[i], string(val))
+	}
+	assert.Equal(t, len(expected)+1, i)
+
+	// the WALs without any entry are skipped.
+	empty, err := NewInMemory(Options{DirPath: "/wal-test-merged-time", SegmentFileExt: ".SEG", SegmentSize: MB})
+	assert.Nil(t, err)
+	reader = NewMergedTimeReader(empty, wals[1])
+	defer reader.Close()
+	_, _, err = reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, reader.Source())
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package wal
+
+// mmapFile is not supported on this platform, the segment files are read by ReadAt.
+func mmapFile(f File, size int64) ([]byte, error) {
+	return nil, nil
+}
+
+// munmapFile unmaps the data returned by mmapFile.
+func munmapFile(data []byte) error {
+	return nil
+}
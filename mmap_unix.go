@@ -0,0 +1,23 @@
+//go:build unix
+
+package wal
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the first size bytes of the file read only,
+// it returns nil if the file can't be mapped, such as it is not an *os.File.
+func mmapFile(f File, size int64) ([]byte, error) {
+	osFile, ok := f.(*os.File)
+	if !ok || size <= 0 {
+		return nil, nil
+	}
+	return syscall.Mmap(int(osFile.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// munmapFile unmaps the data returned by mmapFile.
+func munmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,80 @@
+package wal
+
+import (
+	"encoding/binary"
+)
+
+// internalKindNamespace is the kind of the entries written by WAL.WriteNamespace,
+// the data is the kind, followed by the namespace id as uvarint and the data of the entry,
+// which is compressed as the one written by Write.
+// The entries are stored as internal entries, since the flags of the chunk header are all taken,
+// and they are decoded as the data entries when they are read, see decodeNamespace.
+const internalKindNamespace byte = 5
+
+// namespaceHeaderSize is the max size of the kind and the namespace id before the data.
+const namespaceHeaderSize = 1 + binary.MaxVarintLen32
+
+// WriteNamespace writes the data to the WAL like Write, and tags it with the namespace id,
+// so several logical streams can share the segment files and the syncs of one WAL.
+// The entries of all the namespaces are ordered in the WAL as they are written,
+// the reader returned by NewReaderForNamespace only returns the entries of one namespace,
+// and the other readers and WAL.Read return the data of all the namespaces as usual.
+// The namespace 0 is the default one, the data written to it is the same as the one written by Write.
+//
+// The data of a namespace other than 0 can't span segment files, it returns ErrValueTooLarge
+// if the data does not fit in a segment file.
+// Notice that the data of the namespaces other than 0 is skipped by the older versions of the WAL.
+func (wal *WAL) WriteNamespace(namespace uint32, data []byte) (*ChunkPosition, error) {
+	if namespace == 0 {
+		return wal.Write(data)
+	}
+	flags := wal.options.Compression.entryFlags()
+	buf := make([]byte, namespaceHeaderSize)
+	buf[0] = internalKindNamespace
+	n := binary.PutUvarint(buf[1:], uint64(namespace))
+	data = append(buf[:1+n], compress(data, flags)...)
+
+	wal.lock()
+	defer wal.unlock()
+	if int64(len(data))+chunkHeaderSize > wal.segmentCapacity() {
+		return nil, ErrValueTooLarge
+	}
+	return wal.writeLocked(data, flags|entryFlagInternal)
+}
+
+// NewReaderForNamespace returns a new reader for the WAL like NewReader,
+// which only returns the entries of the namespace in the order they are written,
+// see WriteNamespace. The entries written by Write are in the namespace 0,
+// as well as the markers written by WriteMarker.
+func (wal *WAL) NewReaderForNamespace(namespace uint32) *Reader {
+	reader := wal.NewReader()
+	for _, segReader := range reader.segmentReaders {
+		segReader.filter = func(entry *chunkEntry) bool {
+			return entry.namespace == namespace
+		}
+	}
+	return reader
+}
+
+// isNamespaceEntry reports whether the raw data written with the flags is the data of a namespace.
+func isNamespaceEntry(data []byte, flags byte) bool {
+	return flags&entryFlagInternal != 0 && flags&entryFlagSpan == 0 && len(data) > 0 && data[0] == internalKindNamespace
+}
+
+// decodeNamespace strips the kind and the namespace id from the data of the namespace,
+// and clears the internal flag, so the entry is read as a data entry.
+// It does nothing if the entry is not an entry of a namespace.
+func (e *chunkEntry) decodeNamespace() error {
+	if !isNamespaceEntry(e.data, e.flags) {
+		return nil
+	}
+	namespace, n := binary.Uvarint(e.data[1:])
+	if n <= 0 || namespace > uint64(^uint32(0)) {
+		return ErrInvalidEntry
+	}
+	e.namespace = uint32(namespace)
+	e.flags &^= entryFlagInternal
+	e.data = e.data[1+n:]
+	e.length -= 1 + n
+	return nil
+}
@@ -0,0 +1,99 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWAL_WriteNamespace(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-write-namespace")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    64 * KB,
+		Compression:    CompressionSnappy,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+
+	// the random data is not compressed much.
+	value := func(namespace uint32, i int) string {
+		r := rand.New(rand.NewSource(int64(namespace)*1000 + int64(i)))
+		data := make([]byte, 1000)
+		for j := range data {
+			data[j] = byte('a' + r.Intn(26))
+		}
+		return fmt.Sprintf("namespace-%d-%d-%s", namespace, i, data)
+	}
+	positions := make(map[uint32][]*ChunkPosition)
+	for i := 0; i < 100; i++ {
+		for _, namespace := range []uint32{0, 1, 300} {
+			pos, err := wal.WriteNamespace(namespace, []byte(value(namespace, i)))
+			assert.Nil(t, err)
+			positions[namespace] = append(positions[namespace], pos)
+		}
+	}
+	_, err = wal.WriteMarker([]byte("marker"))
+	assert.Nil(t, err)
+	assert.True(t, wal.ActiveSegmentID() > 1)
+
+	check := func(wal *WAL) {
+		for namespace, list := range positions {
+			for i, pos := range list {
+				val, err := wal.Read(pos)
+				assert.Nil(t, err)
+				assert.Equal(t, value(namespace, i), string(val))
+			}
+
+			reader := wal.NewReaderForNamespace(namespace)
+			for i := range list {
+				val, _, err := reader.Next()
+				assert.Nil(t, err)
+				assert.Equal(t, value(namespace, i), string(val))
+			}
+			if namespace == 0 {
+				val, _, err := reader.Next()
+				assert.Nil(t, err)
+				assert.Equal(t, "marker", string(val))
+			}
+			_, _, err := reader.Next()
+			assert.Equal(t, io.EOF, err)
+			reader.Close()
+		}
+
+		// the other readers return the entries of all the namespaces.
+		n, err := wal.Len()
+		assert.Nil(t, err)
+		assert.Equal(t, 300, n)
+		reader := wal.NewReverseReader()
+		for i := 99; i >= 0; i-- {
+			for _, namespace := range []uint32{300, 1, 0} {
+				val, _, err := reader.Next()
+				assert.Nil(t, err)
+				assert.Equal(t, value(namespace, i), string(val))
+			}
+		}
+		reader.Close()
+	}
+	check(wal)
+
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	check(wal)
+
+	var large []byte
+	for i := 0; i < 70; i++ {
+		large = append(large, value(2, i)...)
+	}
+	_, err = wal.WriteNamespace(2, large)
+	assert.Equal(t, ErrValueTooLarge, err)
+}
@@ -1,6 +1,7 @@
 package wal
 
 import (
+	"hash/crc32"
 	"os"
 	"time"
 )
@@ -11,6 +12,8 @@ type Options struct {
 	DirPath string
 
 	// SegmentSize specifies the maximum size of each segment file in bytes.
+	// The space of the largest footer, which is appended when a segment file is sealed,
+	// is reserved in it, about 2 bytes for every 32KB block, so the entries take a bit less.
 	SegmentSize int64
 
 	// SegmentFileExt specifies the file extension of the segment files.
@@ -20,6 +23,26 @@ type Options struct {
 	// Not a common usage for most users.
 	SegmentFileExt string
 
+	// SegmentNameFunc returns the file name of the segment file with the id, without the directory,
+	// and SegmentParseFunc returns the id of the segment file with the name, and false if
+	// the file is not a segment file, Open uses it to find the segment files in DirPath.
+	// They must be set together, and if they are nil, the segment files are
+	// named by SegmentFileName, that is, the id padded to 9 digits plus SegmentFileExt.
+	SegmentNameFunc  func(id SegmentID) string
+	SegmentParseFunc func(name string) (SegmentID, bool)
+
+	// InitialSegmentID specifies the id of the first segment file created in an empty DirPath,
+	// such as to continue the segment ids of a WAL migrated from somewhere else.
+	// It is ignored if DirPath has segment files, the one with the max id is the active one as usual.
+	// The first segment file id is 1 if it is zero.
+	InitialSegmentID SegmentID
+
+	// StartNewSegmentOnOpen is whether Open seals the segment file with the max id and creates
+	// a new active segment file, like calling WAL.OpenNewActiveSegment right after opening,
+	// so the data written by the previous run is never modified again.
+	// Nothing is done if the directory is empty, or the segment file with the max id is empty.
+	StartNewSegmentOnOpen bool
+
 	// Sync is whether to synchronize writes through os buffer cache and down onto the actual disk.
 	// Setting sync is required for durability of a single write operation, but also results in slower writes.
 	//
@@ -36,6 +59,228 @@ type Options struct {
 	// SyncInterval is the time duration in which explicit synchronization is performed.
 	// If SyncInterval is zero, no periodic synchronization is performed.
 	SyncInterval time.Duration
+
+	// SyncPolicy decides when the active segment file is synced, see SyncNever, SyncAlways,
+	// SyncEveryBytes and SyncEveryInterval. It is combined with Sync, BytesPerSync and
+	// SyncInterval, which are kept for compatibility, so the segment file is synced
+	// if any of them says so. It is not changed by WAL.SetSync and WAL.SetBytesPerSync,
+	// which return ErrSyncPolicyOverride if it syncs more often than the change.
+	SyncPolicy SyncPolicy
+
+	// OpenSyncFlag is ORed into the flags of opening the segment files for writes, such as
+	// syscall.O_DSYNC or os.O_SYNC, so the kernel syncs the data to the disk on every write syscall
+	// instead of the explicit fsync calls. Since every written chunk is already durable when the write
+	// returns, the fsync calls of the sync policy, WAL.Sync and sealing a segment file are skipped
+	// to avoid syncing twice, the buffered data of WriteBufferSize is still flushed by them.
+	//
+	// It makes every write as durable as SyncAlways, but also as slow, the writes wait for
+	// the disk one by one instead of syncing the writes together, so it suits the deployments
+	// where most writes must be durable anyway and the fsync calls cost more than the writes.
+	// O_DSYNC only syncs the metadata needed to read the data back, such as the file size,
+	// while O_SYNC syncs all the metadata and is slower. It is not used if it is zero.
+	OpenSyncFlag int
+
+	// MaxPendingSize specifies the maximum size in bytes of the data added by
+	// WAL.PendingWrites, WAL.WriteAll returns ErrPendingSizeTooLarge if it is exceeded.
+	// The data of one WriteAll call must be written to one segment file,
+	// so it can't be larger than SegmentSize less the space reserved for the footer,
+	// and it is that if it is zero.
+	MaxPendingSize int64
+
+	// AtomicWriteAll is whether to truncate all the data of a WAL.WriteAll call
+	// from the segment file if it fails partway, so the batch is written entirely or not at all.
+	// If false, the entries written completely before the failure are kept.
+	AtomicWriteAll bool
+
+	// EnableCheckpoint is whether to record the last synced position in a CHECKPOINT file
+	// in DirPath whenever a sync completes, you can get it by calling WAL.Checkpoint().
+	// Writing the checkpoint file costs two extra fsyncs and a rename for every sync,
+	// the temporary file is synced before it is renamed to CHECKPOINT, and DirPath after it.
+	EnableCheckpoint bool
+
+	// MaxTotalSize specifies the maximum total size in bytes of all segment files.
+	// When a write would push the total size past it, the oldest segment files
+	// are removed until the WAL fits in the limit again.
+	// The active segment file is never removed, so the limit may still be
+	// exceeded if the active segment file alone is larger than it.
+	// If MaxTotalSize is zero, no size based retention is performed.
+	MaxTotalSize int64
+
+	// MaxSegments specifies the maximum number of segment files, including the active one.
+	// When a rotation makes the number of segment files exceed it,
+	// the oldest segment files are removed down to the limit.
+	// If MaxSegments is zero, no count based retention is performed.
+	MaxSegments int
+
+	// OnSegmentEvicted is called after a segment file is removed by retention, WAL.TrimFront or WAL.RemoveSegment.
+	// If some readers are still reading it, the segment file is removed from the disk
+	// after they release it, see Reader.
+	// It is called with the WAL lock held, so it must not call any WAL methods.
+	OnSegmentEvicted func(segId SegmentID)
+
+	// OnSegmentSealed is called after a segment file stops being the active one,
+	// with the id and the final path of it. The segment file has been synced,
+	// unless it is sealed by WAL.OpenNewActiveSegmentWithoutSync,
+	// and will never be modified again, so it is safe to archive it.
+	// The rotation is done when it is called, so an error returned by it doesn't fail
+	// the write that triggered the rotation, nor stop the retention, it is logged by Logger.
+	// It is called with the WAL lock held, so the long running work
+	// such as uploading should be done in another goroutine.
+	OnSegmentSealed func(segId SegmentID, path string) error
+
+	// OnRotate is called after the active segment file is rotated by a write or
+	// WAL.OpenNewActiveSegment, with the id of the old active segment file, which has been synced,
+	// and the id of the new one. Unlike OnSegmentSealed, it is called after the WAL lock
+	// is released, so it can do I/O and read the WAL, but it must not write to the WAL,
+	// which may deadlock. The calls are in the order of the rotations.
+	OnRotate func(oldID, newID SegmentID)
+
+	// OnSync is called after every sync of the active segment file, by the sync policy,
+	// WAL.Sync or sealing a segment file, with how long the sync took and its error,
+	// such as to build a histogram of the fsync latency and alert on the stalls of a failing disk.
+	// It is called with the WAL lock held, so it must be fast and must not call the WAL.
+	OnSync func(d time.Duration, err error)
+
+	// SegmentLoader loads the segment files which are not in DirPath when reading,
+	// such as the archived ones removed by retention.
+	// If SegmentLoader is nil, reading a missing segment file returns an error.
+	SegmentLoader SegmentLoader
+
+	// SegmentLoaderMissTTL is how long a segment file not found by SegmentLoader is remembered,
+	// the reads of it within the duration fail fast with the same error instead of calling
+	// SegmentLoader again, which saves the round trips to a remote storage for the repeated
+	// reads of the segment files not archived yet. The remembered segment files are forgotten
+	// when a segment file is created by a rotation or loaded, so a new one is found right away.
+	// Only the errors wrapping os.ErrNotExist are remembered, and nothing is if it is zero.
+	SegmentLoaderMissTTL time.Duration
+
+	// ValidateSegmentSequence is whether to check that the ids of the segment files
+	// form a contiguous range when opening, Open returns ErrSegmentGap if a segment file
+	// is missing, and ErrDuplicateSegment if more than one file has the same id,
+	// such as 1.SEG and 000000001.SEG. It catches the manual mistakes in the directory early.
+	ValidateSegmentSequence bool
+
+	// SkipChecksumOnRead is whether to skip verifying the checksum of the data read by WAL.Read.
+	// Skipping it saves the checksum computation on the hot read path, but the corrupted
+	// data, such as the one damaged by a faulty disk, is returned silently instead of ErrInvalidCRC,
+	// so only set it if the data has been verified, e.g. by WAL.Verify after opening.
+	// The checksum is always computed on writes, and always verified by the Reader and WAL.Verify.
+	// It is false by default, so the checksum is verified unless it is set.
+	SkipChecksumOnRead bool
+
+	// VerifyOnOpen is whether to verify the checksums of all the chunks by WAL.Verify when opening,
+	// Open returns the *CorruptionError of the first corrupted chunk, so a corrupted WAL
+	// fails fast instead of returning ErrInvalidCRC in the middle of the processing.
+	// It reads all the segment files, so it is disabled by default.
+	VerifyOnOpen bool
+
+	// ChecksumType specifies the algorithm of the checksums of the chunks, it is ChecksumCRC32 by default.
+	// ChecksumXXH64 is much faster for the large payloads, its 64-bit hash is truncated to
+	// the lower 32 bits to keep the 4-byte checksum field, so the format of the chunks is unchanged.
+	// The truncated hash misses a random corruption as rarely as CRC-32, 1 in 2^32,
+	// but it loses the guarantee of CRC-32 to detect all the burst errors up to 32 bits.
+	// Notice that the checksum type is not recorded in the segment files, so the WAL must always be
+	// opened with the same one, the chunks written with another one fail with ErrInvalidCRC,
+	// which can be found early by VerifyOnOpen, and the older versions can't read the XXH64 chunks.
+	ChecksumType ChecksumType
+
+	// CRCTable is the table of the CRC-32 checksums of the chunks, used by both the writes and the reads,
+	// such as a table made by crc32.MakeTable(crc32.Castagnoli) to read and write the segment files
+	// of another tool using that polynomial. It is the IEEE table if it is nil, which the data of
+	// rosedb uses, and it is ignored unless ChecksumType is ChecksumCRC32.
+	// Like ChecksumType, the table is not recorded in the segment files, so the WAL must always be
+	// opened with the same one. The checksums of the exported files and the single file are not affected.
+	CRCTable *crc32.Table
+
+	// ParallelChecksumThreshold is the size of the entries whose checksums are verified
+	// by several goroutines, up to GOMAXPROCS, when they are read by WAL.Read, the Reader or WAL.Verify,
+	// which cuts the latency of reading the very large entries, such as the ones of 10MB.
+	// Every chunk carries its own checksum, so the chunks of an entry are verified independently
+	// after all of them are read, the format of the segment files is not changed by it.
+	// The entries smaller than it are verified by the reading goroutine as usual,
+	// it is disabled if it is zero, and the entries in one block are never verified in parallel.
+	ParallelChecksumThreshold int
+
+	// DedupWindow is the number of the recent dedup ids remembered by WAL.WriteDedup,
+	// the data written with an id in the window is not written again.
+	// The ids are loaded by scanning the newest segment files when opening the WAL,
+	// until the window is full or all the segment files are scanned, so a large window
+	// slows down the opening. WriteDedup is disabled if it is zero.
+	DedupWindow int
+
+	// Compression specifies the algorithm to compress the data of the new entries,
+	// the data is not compressed if it is CompressionNone.
+	// The codec is recorded in every entry, so it can be changed when reopening the WAL,
+	// the entries written before are still readable.
+	// Notice that the compressed data can not be read by the older versions of the WAL.
+	Compression CompressionType
+
+	// MMapReads is whether to memory map the sealed segment files, that is, all the segment
+	// files except the active one, and serve the reads by slicing the mappings,
+	// which saves the ReadAt syscalls and the copies into the block buffers.
+	// The checksum is still verified unless SkipChecksumOnRead is set, and always by the Reader.
+	// The active segment file is still read by ReadAt since it is growing.
+	// It only works with the operating system's file system on unix platforms,
+	// the segment files are read by ReadAt otherwise.
+	MMapReads bool
+
+	// WriteBufferSize is the size of the buffer in the user space for the writes,
+	// the written chunks are buffered and written to the segment file together
+	// when the buffer is full, it saves the write syscalls of the small entries.
+	// The buffer is also flushed by Flush, Sync, rotating the active segment and Close,
+	// the buffered data is lost if the process crashes before that.
+	// The data larger than the buffer is written to the segment file directly.
+	// It is disabled if it is zero.
+	WriteBufferSize int
+
+	// TempSegmentFiles is whether to create a new segment file with the ".tmp" suffix
+	// appended to its name, and rename it to the final name when it is synced the first time,
+	// which is done when it is sealed at the latest, or when the WAL is closed.
+	// So a segment file half created by a crash never confuses the segment id scan of Open,
+	// the temporary segment file left by a crash is removed if it is empty,
+	// otherwise it is renamed to the final name by Open.
+	// The temporary segment files are ignored by Open if it is false.
+	TempSegmentFiles bool
+
+	// AllowSegmentSpanning is whether to write the data larger than SegmentSize
+	// instead of returning ErrValueTooLarge. The data is split into fragments,
+	// the first one fills the active segment file, and each of the others takes up
+	// a new segment file, the readers follow the fragments across the segment files.
+	// The segment files of a spanning entry must be removed together, so MaxSegments
+	// must be larger than the number of the segment files an entry takes up.
+	// The segment files with the spanning entries can not be read by the older versions.
+	AllowSegmentSpanning bool
+
+	// SingleFile is whether to store all the segment files in one file named "WAL" in DirPath
+	// instead of the separate files, every segment file is a logical region of it,
+	// and a manifest in it records the regions, so rotating the active segment file
+	// just starts a new region. The region of a removed segment file is zeroed by punching
+	// a hole in it on Linux, or by rewriting it otherwise, and reused by the next segment file.
+	//
+	// The regions are large enough for SegmentSize, which can not be increased after
+	// the file is created, and the manifest holds about a thousand segment files.
+	// The positions, the readers and the writers work as usual, but the segment file paths,
+	// such as the ones passed to OnSegmentSealed, are only accessible through the WAL,
+	// and the separate segment files in DirPath are ignored. Syncing the active segment file
+	// costs an extra fsync to persist its size in the manifest.
+	SingleFile bool
+
+	// FileMode specifies the permission bits of the segment files and the checkpoint file
+	// created by the WAL, it is 0644 if it is zero.
+	FileMode os.FileMode
+
+	// DirMode specifies the permission bits of the directory DirPath if it is created by the WAL,
+	// it is 0777 (os.ModePerm) if it is zero. Both are modified by the umask of the process.
+	DirMode os.FileMode
+
+	// Logger logs the notable events of the WAL, such as the rotations, the repairs,
+	// the skipped corrupted entries and the failed writes.
+	// If Logger is nil, the messages are discarded.
+	Logger Logger
+
+	// FileSystem specifies the file system where the WAL files are stored.
+	// If FileSystem is nil, the operating system's file system is used.
+	FileSystem FileSystem
 }
 
 const (
@@ -52,4 +297,10 @@ var DefaultOptions = Options{
 	Sync:           false,
 	BytesPerSync:   0,
 	SyncInterval:   0,
+	MaxPendingSize: 0,
+	MaxTotalSize:   0,
+	MaxSegments:    0,
+	FileSystem:     OSFileSystem,
+	FileMode:       fileModePerm,
+	DirMode:        os.ModePerm,
 }
@@ -0,0 +1,146 @@
+package wal
+
+import (
+	"runtime"
+	"sync"
+)
+
+// prefetchSize is the number of entries read ahead for each sealed segment file by the parallel reader.
+const prefetchSize = 256
+
+// prefetchedEntry is an entry read ahead by a worker of the parallel reader.
+type prefetchedEntry struct {
+	entry    chunkEntry
+	position *ChunkPosition
+	err      error
+	// the cursor of the segment reader after the entry is read.
+	blockNumber uint32
+	chunkOffset int64
+}
+
+// NewParallelReader returns a new reader for the WAL like NewReader,
+// but the sealed segment files, that is, all the segment files except the active one,
+// are read concurrently by at most workers goroutines, each segment file by one goroutine.
+// The entries are still returned in the order of the WAL, the entries of a segment file
+// are read ahead while the ones of the previous segment files are being returned.
+// The active segment file is read by the caller as NewReader does.
+//
+// The data is always read even if NextPosition is called.
+// Close must be called to stop the goroutines if the reader is not read to the end.
+// It is the same as NewReader if workers is less than 2.
+func (wal *WAL) NewParallelReader(workers int) *Reader {
+	reader := wal.NewReader()
+	if workers < 2 {
+		return reader
+	}
+
+	wal.mu.RLock()
+	activeID := wal.activeSegment.id
+	wal.mu.RUnlock()
+
+	var sealed []*segmentReader
+	for _, segReader := range reader.segmentReaders {
+		if segReader.segment.id != activeID {
+			segReader.prefetched = make(chan prefetchedEntry, prefetchSize)
+			sealed = append(sealed, segReader)
+		}
+	}
+	if len(sealed) == 0 {
+		return reader
+	}
+
+	reader.stopC = make(chan struct{})
+	reader.workers = &sync.WaitGroup{}
+	reader.workers.Add(1)
+	go func() {
+		defer reader.workers.Done()
+		// the segment files are dispatched in order, so the one being returned
+		// always has a worker, and the workers of the later ones can only be blocked by it.
+		sem := make(chan struct{}, workers)
+		for _, segReader := range sealed {
+			select {
+			case sem <- struct{}{}:
+			case <-reader.stopC:
+				return
+			}
+			reader.workers.Add(1)
+			go func(segReader *segmentReader) {
+				defer func() {
+					<-sem
+					reader.workers.Done()
+				}()
+				segReader.prefetch(reader.stopC)
+			}(segReader)
+		}
+	}()
+	return reader
+}
+
+// prefetch reads all the entries of the segment file into the prefetched channel,
+// and closes it after io.EOF or an error is sent. It stops if stopC is closed.
+func (segReader *segmentReader) prefetch(stopC <-chan struct{}) {
+	defer close(segReader.prefetched)
+
+	// the cursor of segReader is updated by the consumer, read by another one.
+	worker := &segmentReader{
+		segment:     segReader.segment,
+		blockNumber: segReader.blockNumber,
+		chunkOffset: segReader.chunkOffset,
+		ctx:         segReader.ctx,
+		keepMarkers: segReader.keepMarkers,
+	}
+	for {
+		entry, position, err := worker.next(false)
+		item := prefetchedEntry{
+			entry:       entry,
+			position:    position,
+			err:         err,
+			blockNumber: worker.blockNumber,
+			chunkOffset: worker.chunkOffset,
+		}
+		select {
+		case segReader.prefetched <- item:
+		case <-stopC:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// nextPrefetched returns the next entry read ahead by the worker,
+// the error is returned repeatedly once it occurs like the segment reader.
+func (segReader *segmentReader) nextPrefetched() (chunkEntry, *ChunkPosition, error) {
+	if segReader.prefetchErr != nil {
+		return chunkEntry{}, nil, segReader.prefetchErr
+	}
+	item, ok := <-segReader.prefetched
+	if !ok {
+		// the reader is closed.
+		item.err = ErrClosed
+	}
+	if item.err != nil {
+		segReader.prefetchErr = item.err
+		return chunkEntry{}, nil, item.err
+	}
+	segReader.blockNumber = item.blockNumber
+	segReader.chunkOffset = item.chunkOffset
+	return item.entry, item.position, nil
+}
+
+// Close releases the segment files referenced by the reader, see Reader,
+// and for the reader created by NewParallelReader, it stops the goroutines
+// and waits for them to exit first. The reader can not be used after that.
+func (r *Reader) Close() {
+	if r.stopC != nil {
+		select {
+		case <-r.stopC:
+		default:
+			close(r.stopC)
+		}
+		r.workers.Wait()
+	}
+	r.releaseSegments()
+	runtime.SetFinalizer(r, nil)
+}
@@ -0,0 +1,58 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWAL_NewParallelReader(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-parallel-reader")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    64 * KB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	var positions []*ChunkPosition
+	for i := 0; i < 2000; i++ {
+		pos, err := wal.Write([]byte(fmt.Sprintf("%d-%s", i, strings.Repeat("X", i%300))))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.True(t, wal.ActiveSegmentID() > 3)
+
+	for _, workers := range []int{0, 2, 4, 16} {
+		reader := wal.NewParallelReader(workers)
+		var i int
+		for {
+			val, pos, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, fmt.Sprintf("%d-%s", i, strings.Repeat("X", i%300)), string(val))
+			assert.Equal(t, positions[i].SegmentId, pos.SegmentId)
+			assert.Equal(t, positions[i].BlockNumber, pos.BlockNumber)
+			assert.Equal(t, positions[i].ChunkOffset, pos.ChunkOffset)
+			i++
+		}
+		assert.Equal(t, len(positions), i)
+		reader.Close()
+	}
+
+	// the goroutines are stopped if the reader is closed before the end.
+	reader := wal.NewParallelReader(4)
+	_, _, err = reader.Next()
+	assert.Nil(t, err)
+	reader.Close()
+	_, _, err = reader.Next()
+	assert.Equal(t, ErrClosed, err)
+}
@@ -0,0 +1,23 @@
+//go:build linux
+
+package wal
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	fallocKeepSize  = 0x01
+	fallocPunchHole = 0x02
+)
+
+// punchHole deallocates the range of the file, which reads back as zeros,
+// it returns false if it is not supported, such as the file is not an *os.File.
+func punchHole(f File, offset, size int64) bool {
+	osFile, ok := f.(*os.File)
+	if !ok {
+		return false
+	}
+	return syscall.Fallocate(int(osFile.Fd()), fallocKeepSize|fallocPunchHole, offset, size) == nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package wal
+
+// punchHole is not supported on this platform, the range is zeroed by writes.
+func punchHole(f File, offset, size int64) bool {
+	return false
+}
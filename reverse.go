@@ -0,0 +1,111 @@
+package wal
+
+import (
+	"errors"
+	"io"
+)
+
+// ReverseReader reads the WAL from the newest entry to the oldest one.
+//
+// The chunks are only linked forward, so when the ReverseReader reaches a segment file,
+// it scans the segment file once to collect the positions of the entries, which are
+// then read backwards, it takes the memory of one position for every entry of the segment file.
+//
+// Like the Reader, it takes a snapshot of the segment files when it is created,
+// and the data written after it is created may not be returned.
+// The committed entries written by WAL.WriteUncommitted are returned at their own positions,
+// and the markers written by WAL.WriteMarker are skipped.
+// The segment files removed by retention are still read by it until it is closed,
+// so Close should be called once it is not used anymore.
+// A ReverseReader is not safe for concurrent use by multiple goroutines.
+type ReverseReader struct {
+	segmentReaders []*segmentReader // sorted by segment id in descending order.
+	currentReader  int
+	positions      []*ChunkPosition // the positions not returned yet of the current segment file.
+	scanned        bool             // whether the current segment file is scanned.
+	// txnStart is the start position of the nearest commit marker after the current entry,
+	// the uncommitted entries before it are not committed by it.
+	txnStart *ChunkPosition
+	// reader holds the references to the segment files.
+	reader *Reader
+}
+
+// NewReverseReader returns a new reverse reader for the WAL,
+// which yields the last entry first, then the previous ones,
+// from the active segment file down to the oldest segment file.
+func (wal *WAL) NewReverseReader() *ReverseReader {
+	reader := wal.NewReader()
+	segmentReaders := make([]*segmentReader, len(reader.segmentReaders))
+	for i, segReader := range reader.segmentReaders {
+		segmentReaders[len(segmentReaders)-1-i] = segReader
+	}
+	return &ReverseReader{segmentReaders: segmentReaders, reader: reader}
+}
+
+// Close releases the segment files referenced by the reverse reader,
+// the reverse reader can not be used after that.
+func (r *ReverseReader) Close() {
+	r.reader.Close()
+}
+
+// Next returns the previous entry data and its position in the WAL.
+// If there is no data, io.EOF will be returned.
+func (r *ReverseReader) Next() ([]byte, *ChunkPosition, error) {
+	for r.currentReader < len(r.segmentReaders) {
+		segReader := r.segmentReaders[r.currentReader]
+		if !r.scanned {
+			if err := r.scan(segReader); err != nil {
+				return nil, nil, err
+			}
+		}
+		if len(r.positions) == 0 {
+			r.currentReader++
+			r.scanned = false
+			continue
+		}
+
+		pos := r.positions[len(r.positions)-1]
+		r.positions = r.positions[:len(r.positions)-1]
+		entry, err := segReader.segment.readInternal(pos.BlockNumber, pos.ChunkOffset, readOptions{})
+		if start, ok := entry.commitStart(); err == nil && ok {
+			r.txnStart = start
+			continue
+		}
+		if err == nil && entry.isMarker() {
+			continue
+		}
+		if err == nil && entry.flags&entryFlagSpan != 0 {
+			entry, err = readSpan(entry, func(id SegmentID) *segment {
+				return findReaderSegment(r.segmentReaders, id)
+			}, readOptions{})
+			if errors.Is(err, ErrIncompleteSpan) {
+				continue
+			}
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if entry.flags&entryFlagUncommitted != 0 && (r.txnStart == nil || pos.before(r.txnStart)) {
+			continue
+		}
+		return entry.data, pos, nil
+	}
+	return nil, nil, io.EOF
+}
+
+// scan collects the positions of all the entries in the segment file.
+func (r *ReverseReader) scan(segReader *segmentReader) error {
+	r.positions = r.positions[:0]
+	for {
+		pos, _, err := segReader.NextPosition()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		r.positions = append(r.positions, pos)
+	}
+	r.scanned = true
+	return nil
+}
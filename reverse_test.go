@@ -0,0 +1,62 @@
+package wal
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWAL_NewReverseReader(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-reverse-reader")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+
+	_, _, err = wal.NewReverseReader().Next()
+	assert.Equal(t, io.EOF, err)
+
+	// the multi-chunk entries are mixed in.
+	var values [][]byte
+	var positions []*ChunkPosition
+	for i := 0; i < 200; i++ {
+		val := []byte(strings.Repeat(string(rune('a'+i%26)), (i%7)*blockSize/2+10))
+		pos, err := wal.Write(val)
+		assert.Nil(t, err)
+		values = append(values, val)
+		positions = append(positions, pos)
+	}
+	assert.True(t, wal.ActiveSegmentID() > 2)
+
+	check := func() {
+		reader := wal.NewReverseReader()
+		for i := len(values) - 1; ; i-- {
+			val, pos, err := reader.Next()
+			if err == io.EOF {
+				assert.Equal(t, -1, i)
+				break
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, values[i], val)
+			assert.Equal(t, positions[i].SegmentId, pos.SegmentId)
+			assert.Equal(t, positions[i].BlockNumber, pos.BlockNumber)
+			assert.Equal(t, positions[i].ChunkOffset, pos.ChunkOffset)
+		}
+	}
+	check()
+
+	// the sealed segment files with footers are read the same way after reopening.
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	check()
+}
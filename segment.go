@@ -1,6 +1,7 @@
 package wal
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"io"
 	"os"
 	"sync"
+	"sync/atomic"
 
 	"github.com/valyala/bytebufferpool"
 )
@@ -23,8 +25,9 @@ const (
 )
 
 var (
-	ErrClosed     = errors.New("the segment file is closed")
-	ErrInvalidCRC = errors.New("invalid crc, the data may be corrupted")
+	ErrClosed          = errors.New("the segment file is closed")
+	ErrInvalidCRC      = errors.New("invalid crc, the data may be corrupted")
+	ErrIncompleteEntry = errors.New("the entry is incomplete, the rest chunks are not written yet")
 )
 
 const (
@@ -38,6 +41,10 @@ const (
 
 	fileModePerm = 0644
 
+	// tempSegmentExt is appended to the name of a new segment file until it is synced,
+	// see Options.TempSegmentFiles.
+	tempSegmentExt = ".tmp"
+
 	// uin32 + uint32 + int64 + uin32
 	// segmentId + BlockNumber + ChunkOffset + ChunkSize
 	maxLen = binary.MaxVarintLen32*3 + binary.MaxVarintLen64
@@ -48,13 +55,36 @@ const (
 // Each block is 32KB, and the data is written in chunks.
 type segment struct {
 	id                 SegmentID
-	fd                 *os.File
+	fd                 File // the append only fd, used for writes.
+	readFd             File // the read only fd, used for reads.
+	fs                 FileSystem
 	currentBlockNumber uint32
 	currentBlockSize   uint32
-	closed             bool
+	writtenSize        atomic.Int64 // the size visible to the readers, which don't hold the WAL lock.
+	closed             atomic.Bool
 	header             []byte
-	startupBlock       *startupBlock
-	isStartupTraversal bool
+	checksum           checksummer // the checksums of the chunks, see Options.ChecksumType and Options.CRCTable.
+	// parallelChecksumSize is the size of the entries whose chunks are verified in parallel,
+	// see Options.ParallelChecksumThreshold.
+	parallelChecksumSize int
+	startupBlock         *startupBlock
+	isStartupTraversal   bool
+	index                *segmentIndex
+	hasFooter            bool
+	mapped               []byte       // the memory mapped sealed segment file, see Options.MMapReads.
+	mappedLock           sync.RWMutex // held by the reads of mapped, so it is not unmapped during them.
+	writeBufferSize      int          // the capacity of writeBuffer, see Options.WriteBufferSize.
+	writeBuffer          []byte       // the written chunks which are not flushed to the segment file yet.
+	syncOnWrite          bool         // whether the writes are synced by the kernel, see Options.OpenSyncFlag.
+	path                 string       // the final path of the segment file.
+	temp                 bool         // whether the segment file is still named with tempSegmentExt.
+	torn                 bool         // whether the torn data of a failed write is not truncated yet.
+	tornSize             int64        // the size to truncate the torn data to.
+	// refs is the number of the readers reading the segment file, and evicted is whether
+	// the segment file is removed from the WAL, it is removed from the disk by the last reader.
+	refLock sync.Mutex
+	refs    int
+	evicted bool
 }
 
 // segmentReader is used to iterate all the data from the segment file.
@@ -64,6 +94,50 @@ type segmentReader struct {
 	segment     *segment
 	blockNumber uint32
 	chunkOffset int64
+	// filter reports whether the entry should be returned by the WAL reader,
+	// all the entries are returned if it is nil.
+	filter func(entry *chunkEntry) bool
+	// ctx cancels the reading if it is not nil.
+	ctx context.Context
+	// prefetched is the entries read ahead by a worker of the parallel reader,
+	// the segment file is read from it instead if it is not nil.
+	prefetched  chan prefetchedEntry
+	prefetchErr error
+	// keepMarkers is whether to return the commit markers and the user markers
+	// instead of skipping them, the WAL reader returns the user markers along with the data,
+	// and needs the commit markers to return the uncommitted entries.
+	keepMarkers bool
+	// bounded is whether the reader stops at end, the size of the segment file
+	// when the snapshot reader is created.
+	bounded bool
+	end     int64
+	// acquired is whether the reader holds a reference to the segment file, see segment.acquire.
+	acquired bool
+	// counted is whether entries is the number of entries of the segment file
+	// when the reader is created, see Reader.Remaining.
+	counted bool
+	entries int
+}
+
+// release drops the reference of the reader to the segment file if it holds one.
+func (segReader *segmentReader) release() error {
+	if !segReader.acquired {
+		return nil
+	}
+	segReader.acquired = false
+	return segReader.segment.release()
+}
+
+// readOptions controls how readInternal reads an entry.
+type readOptions struct {
+	// skipData is whether to skip copying out the data, only the prefix is decoded.
+	skipData bool
+	// skipChecksum is whether to skip verifying the checksum.
+	skipChecksum bool
+	// ctx is checked before reading every block if it is not nil.
+	ctx context.Context
+	// buf is the buffer the data is read into if it is large enough, see WAL.ReadPooled.
+	buf []byte
 }
 
 // There is only one reader(single goroutine) for startup traversal,
@@ -86,6 +160,10 @@ type ChunkPosition struct {
 	ChunkSize uint32
 }
 
+// crcTable is the table to compute the checksum of the chunks,
+// it is made once and shared by the writes and the reads.
+var crcTable = crc32.MakeTable(crc32.IEEE)
+
 var blockPool = sync.Pool{
 	New: func() interface{} {
 		return make([]byte, blockSize)
@@ -100,28 +178,74 @@ func putBuffer(buf []byte) {
 	blockPool.Put(buf)
 }
 
+// maxDataBufferSize is the max capacity of the buffers kept by dataPool,
+// the larger ones of the rare large entries are left to the GC.
+const maxDataBufferSize = 4 * blockSize
+
+// dataPool is the pool of the buffers of the data read by WAL.ReadPooled.
+var dataPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, 0, blockSize)
+	},
+}
+
+func getDataBuffer() []byte {
+	return dataPool.Get().([]byte)
+}
+
+func putDataBuffer(buf []byte) {
+	if cap(buf) > 0 && cap(buf) <= maxDataBufferSize {
+		dataPool.Put(buf[:0])
+	}
+}
+
 // openSegmentFile a new segment file.
-func openSegmentFile(dirPath, extName string, id uint32) (*segment, error) {
-	fd, err := os.OpenFile(
-		SegmentFileName(dirPath, extName, id),
-		os.O_CREATE|os.O_RDWR|os.O_APPEND,
-		fileModePerm,
+// The segment file is opened in the file system specified by the options.
+func openSegmentFile(dirPath, extName string, id uint32, options *Options) (*segment, error) {
+	path := segmentFilePath(options, dirPath, extName, id)
+	name, temp := path, false
+	if options.TempSegmentFiles {
+		// a new segment file is created with the temporary name.
+		if _, err := options.FileSystem.Stat(path); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			name, temp = path+tempSegmentExt, true
+		}
+	}
+	fd, err := options.FileSystem.OpenFile(
+		name,
+		os.O_CREATE|os.O_RDWR|os.O_APPEND|options.OpenSyncFlag,
+		options.FileMode,
 	)
 
 	if err != nil {
 		return nil, err
 	}
+	// open another read only fd for reads, so the reads never touch the append fd.
+	readFd, err := options.FileSystem.OpenFile(name, os.O_RDONLY, options.FileMode)
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
 
-	// set the current block number and block size.
-	offset, err := fd.Seek(0, io.SeekEnd)
+	// set the current block number and block size by the size of the segment file,
+	// stat doesn't move the offset of the fd like seeking to the end.
+	info, err := fd.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("seek to the end of segment file %d%s failed: %v", id, extName, err)
+		_ = fd.Close()
+		_ = readFd.Close()
+		return nil, fmt.Errorf("stat segment file %d%s failed: %v", id, extName, err)
 	}
+	offset := info.Size()
 
-	return &segment{
+	seg := &segment{
 		id:                 id,
 		fd:                 fd,
+		readFd:             readFd,
+		fs:                 options.FileSystem,
 		header:             make([]byte, chunkHeaderSize),
+		checksum:           newChecksummer(options),
 		currentBlockNumber: uint32(offset / blockSize),
 		currentBlockSize:   uint32(offset % blockSize),
 		startupBlock: &startupBlock{
@@ -129,7 +253,29 @@ func openSegmentFile(dirPath, extName string, id uint32) (*segment, error) {
 			blockNumber: -1,
 		},
 		isStartupTraversal: false,
-	}, nil
+		// the index of an empty segment file is complete,
+		// otherwise it is loaded from the footer if present.
+		index:                &segmentIndex{complete: offset == 0},
+		writeBufferSize:      options.WriteBufferSize,
+		parallelChecksumSize: options.ParallelChecksumThreshold,
+		syncOnWrite:          options.OpenSyncFlag != 0,
+		path:                 path,
+		temp:                 temp,
+	}
+	seg.writtenSize.Store(offset)
+	if offset > 0 {
+		seg.loadFooter()
+	}
+	return seg, nil
+}
+
+// readError returns ErrClosed if the read fails because
+// the segment file is closed during the read, otherwise returns err.
+func (seg *segment) readError(err error) error {
+	if seg.closed.Load() {
+		return ErrClosed
+	}
+	return err
 }
 
 // NewReader creates a new segment reader.
@@ -143,33 +289,213 @@ func (seg *segment) NewReader() *segmentReader {
 	}
 }
 
+// Flush writes the buffered data to the segment file.
+func (seg *segment) Flush() error {
+	if seg.closed.Load() {
+		return nil
+	}
+	return seg.flushBuffer()
+}
+
+// flushBuffer writes the write buffer to the segment file and makes it visible to the readers.
+// If it fails, the buffered data is dropped and the segment file is truncated
+// to the data flushed before, so the segment file is still valid for the following writes.
+func (seg *segment) flushBuffer() error {
+	if len(seg.writeBuffer) == 0 {
+		return nil
+	}
+	if err := seg.truncateTorn(); err != nil {
+		return err
+	}
+	n, err := seg.fd.Write(seg.writeBuffer)
+	if err == nil && n < len(seg.writeBuffer) {
+		err = io.ErrShortWrite
+	}
+	seg.writeBuffer = seg.writeBuffer[:0]
+	// the cached block can not be reused again after writes.
+	seg.startupBlock.blockNumber = -1
+	if err != nil {
+		flushedSize := seg.writtenSize.Load()
+		seg.currentBlockNumber = uint32(flushedSize / blockSize)
+		seg.currentBlockSize = uint32(flushedSize % blockSize)
+		// the dropped entries are still in the index, it is rebuilt when needed.
+		seg.index = &segmentIndex{}
+		seg.hasFooter = false
+		if n > 0 {
+			if truncErr := seg.truncate(); truncErr != nil {
+				return fmt.Errorf("%w, and truncate the torn data failed: %v", err, truncErr)
+			}
+		}
+		return err
+	}
+	seg.writtenSize.Store(seg.Size())
+	return nil
+}
+
+// reserveBuffer flushes the write buffer if it may not hold the chunks taking up
+// at most maxSize bytes, so the buffered data is never flushed after the status
+// of a write is changed, and a chunk buffer which does not fit in the write buffer
+// is always written to the segment file right after the flushed data.
+func (seg *segment) reserveBuffer(maxSize int64) error {
+	if seg.writeBufferSize <= 0 || len(seg.writeBuffer) == 0 {
+		return nil
+	}
+	if int64(len(seg.writeBuffer))+maxSize <= int64(seg.writeBufferSize) {
+		return nil
+	}
+	return seg.flushBuffer()
+}
+
+// maxChunksSize returns the maximum bytes the chunks of the data take up
+// in the segment file, including the padding before them.
+func maxChunksSize(dataSize int) int64 {
+	chunkCount := int64(dataSize)/(blockSize-chunkHeaderSize) + 2
+	return int64(dataSize) + (chunkCount+1)*chunkHeaderSize
+}
+
+// isBuffered reports whether the entry at the position is still in the write buffer.
+func (seg *segment) isBuffered(pos *ChunkPosition) bool {
+	return seg.writeBufferSize > 0 &&
+		int64(pos.BlockNumber)*blockSize+pos.ChunkOffset >= seg.writtenSize.Load()
+}
+
+// updateWrittenSize makes the data written to the segment file visible to the readers,
+// the data still in the write buffer is not visible until it is flushed.
+func (seg *segment) updateWrittenSize() {
+	seg.writtenSize.Store(seg.Size() - int64(len(seg.writeBuffer)))
+}
+
 // Sync flushes the segment file to disk.
 func (seg *segment) Sync() error {
-	if seg.closed {
+	if seg.closed.Load() {
 		return nil
 	}
-	return seg.fd.Sync()
+	if err := seg.flushBuffer(); err != nil {
+		return err
+	}
+	// the writes are already synced by the kernel, see Options.OpenSyncFlag.
+	if !seg.syncOnWrite {
+		if err := seg.fd.Sync(); err != nil {
+			return err
+		}
+	}
+	return seg.finalize()
+}
+
+// finalize renames the temporary segment file to its final name, see Options.TempSegmentFiles.
+func (seg *segment) finalize() error {
+	if !seg.temp {
+		return nil
+	}
+	if err := seg.fs.Rename(seg.path+tempSegmentExt, seg.path); err != nil {
+		return fmt.Errorf("rename the temporary segment file %d failed: %v", seg.id, err)
+	}
+	seg.temp = false
+	return nil
 }
 
 // Remove removes the segment file.
 func (seg *segment) Remove() error {
-	if !seg.closed {
-		seg.closed = true
-		if err := seg.fd.Close(); err != nil {
+	if seg.closed.CompareAndSwap(false, true) {
+		if err := seg.closeFiles(); err != nil {
 			return err
 		}
 	}
 
-	return os.Remove(seg.fd.Name())
+	name := seg.path
+	if seg.temp {
+		name += tempSegmentExt
+	}
+	return seg.fs.Remove(name)
+}
+
+// acquire adds a reference of a reader to the segment file,
+// it must be called with the WAL lock held, so the segment file is not evicted yet.
+func (seg *segment) acquire() {
+	seg.refLock.Lock()
+	seg.refs++
+	seg.refLock.Unlock()
+}
+
+// release drops a reference of a reader to the segment file,
+// and removes the segment file if it is evicted and this is the last reference.
+func (seg *segment) release() error {
+	seg.refLock.Lock()
+	seg.refs--
+	remove := seg.refs == 0 && seg.evicted
+	seg.refLock.Unlock()
+	if remove {
+		return seg.Remove()
+	}
+	return nil
+}
+
+// evict removes the segment file if no reader references it,
+// otherwise the removal is deferred until the last reader releases it.
+func (seg *segment) evict() error {
+	seg.refLock.Lock()
+	seg.evicted = true
+	deferred := seg.refs > 0
+	seg.refLock.Unlock()
+	if deferred {
+		return nil
+	}
+	return seg.Remove()
 }
 
 // Close closes the segment file.
 func (seg *segment) Close() error {
-	if seg.closed {
+	if !seg.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	err := seg.flushBuffer()
+	seg.writeBuffer = nil
+	if err == nil {
+		err = seg.finalize()
+	}
+	if closeErr := seg.closeFiles(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// mmap maps the segment file into memory, the reads are served from the mapping
+// instead of ReadAt. It must be called only if the segment file will never be modified.
+func (seg *segment) mmap() error {
+	if seg.mapped != nil {
 		return nil
 	}
+	mapped, err := mmapFile(seg.readFd, seg.writtenSize.Load())
+	if err != nil {
+		return fmt.Errorf("mmap segment file %d failed: %v", seg.id, err)
+	}
+	seg.mappedLock.Lock()
+	seg.mapped = mapped
+	seg.mappedLock.Unlock()
+	return nil
+}
 
-	seg.closed = true
+// closeFiles closes the append fd and the read fd of the segment file,
+// and unmaps the segment file if it is mapped.
+func (seg *segment) closeFiles() error {
+	seg.mappedLock.Lock()
+	mapped := seg.mapped
+	seg.mapped = nil
+	seg.mappedLock.Unlock()
+	if mapped != nil {
+		if err := munmapFile(mapped); err != nil {
+			_ = seg.readFd.Close()
+			_ = seg.fd.Close()
+			return err
+		}
+	}
+
+	if seg.readFd != seg.fd {
+		if err := seg.readFd.Close(); err != nil {
+			_ = seg.fd.Close()
+			return err
+		}
+	}
 	return seg.fd.Close()
 }
 
@@ -185,15 +511,19 @@ func (seg *segment) Size() int64 {
 //
 // Each chunk has a header, and the header contains the length, type and checksum.
 // And the payload of the chunk is the real data you want to Write.
-func (seg *segment) writeToBuffer(data []byte, chunkBuffer *bytebufferpool.ByteBuffer) (*ChunkPosition, error) {
+//
+// The flags are stored in the type byte of every chunk header, see entryFlagTimestamp.
+func (seg *segment) writeToBuffer(data []byte, flags byte, chunkBuffer *bytebufferpool.ByteBuffer) (*ChunkPosition, error) {
 	startBufferLen := chunkBuffer.Len()
 	padding := uint32(0)
 
-	if seg.closed {
+	if seg.closed.Load() {
 		return nil, ErrClosed
 	}
 
-	// if the left block size can not hold the chunk header, padding the block
+	// if the left block size can not hold the chunk header, padding the block,
+	// which wastes at most chunkHeaderSize bytes of a block. The readers rely on it
+	// to locate the next chunk, so it can't be changed without breaking the format.
 	if seg.currentBlockSize+chunkHeaderSize >= blockSize {
 		// padding if necessary
 		if seg.currentBlockSize < blockSize {
@@ -217,7 +547,7 @@ func (seg *segment) writeToBuffer(data []byte, chunkBuffer *bytebufferpool.ByteB
 	dataSize := uint32(len(data))
 	// The entire chunk can fit into the block.
 	if seg.currentBlockSize+dataSize+chunkHeaderSize <= blockSize {
-		seg.appendChunkBuffer(chunkBuffer, data, ChunkTypeFull)
+		seg.appendChunkBuffer(chunkBuffer, data, ChunkTypeFull|flags)
 		position.ChunkSize = dataSize + chunkHeaderSize
 	} else {
 		// If the size of the data exceeds the size of the block,
@@ -249,7 +579,7 @@ func (seg *segment) writeToBuffer(data []byte, chunkBuffer *bytebufferpool.ByteB
 			default: // Middle chunk
 				chunkType = ChunkTypeMiddle
 			}
-			seg.appendChunkBuffer(chunkBuffer, data[dataSize-leftSize:end], chunkType)
+			seg.appendChunkBuffer(chunkBuffer, data[dataSize-leftSize:end], chunkType|flags)
 
 			leftSize -= chunkSize
 			blockCount += 1
@@ -276,10 +606,22 @@ func (seg *segment) writeToBuffer(data []byte, chunkBuffer *bytebufferpool.ByteB
 }
 
 // writeAll write batch data to the segment file.
-func (seg *segment) writeAll(data [][]byte) (positions []*ChunkPosition, err error) {
-	if seg.closed {
+// The data is written by one write operation, if it fails partway,
+// the torn data is truncated from the segment file, and the positions of
+// the entries which are written completely are returned along with the error.
+// If atomic is true, the whole batch is truncated and no position is returned.
+// All the data are written with the given entry flags.
+func (seg *segment) writeAll(data [][]byte, flags byte, atomic bool) (positions []*ChunkPosition, err error) {
+	if seg.closed.Load() {
 		return nil, ErrClosed
 	}
+	var maxSize int64
+	for _, d := range data {
+		maxSize += maxChunksSize(len(d))
+	}
+	if err := seg.reserveBuffer(maxSize); err != nil {
+		return nil, err
+	}
 
 	// if any error occurs, restore the segment status
 	originBlockNumber := seg.currentBlockNumber
@@ -289,35 +631,79 @@ func (seg *segment) writeAll(data [][]byte) (positions []*ChunkPosition, err err
 	chunkBuffer := bytebufferpool.Get()
 	chunkBuffer.Reset()
 	defer func() {
-		if err != nil {
-			seg.currentBlockNumber = originBlockNumber
-			seg.currentBlockSize = originBlockSize
-		}
+		// make the written entries visible to the readers.
+		seg.updateWrittenSize()
 		bytebufferpool.Put(chunkBuffer)
 	}()
 
-	// write all data to the chunk buffer
+	// write all data to the chunk buffer,
+	// and record where each entry ends in it.
 	var pos *ChunkPosition
 	positions = make([]*ChunkPosition, len(data))
+	ends := make([]writeCursor, len(data))
 	for i := 0; i < len(positions); i++ {
-		pos, err = seg.writeToBuffer(data[i], chunkBuffer)
+		pos, err = seg.writeToBuffer(data[i], flags, chunkBuffer)
 		if err != nil {
-			return
+			seg.currentBlockNumber = originBlockNumber
+			seg.currentBlockSize = originBlockSize
+			return nil, err
 		}
 		positions[i] = pos
+		ends[i] = writeCursor{
+			blockNumber: seg.currentBlockNumber,
+			blockSize:   seg.currentBlockSize,
+			end:         chunkBuffer.Len(),
+		}
 	}
+
 	// write the chunk buffer to the segment file
-	if err = seg.writeChunkBuffer(chunkBuffer); err != nil {
-		return
+	n, err := seg.writeChunkBuffer(chunkBuffer)
+	if err != nil {
+		// keep the entries written completely unless the batch is atomic.
+		committed := 0
+		for !atomic && committed < len(ends) && ends[committed].end <= n {
+			committed++
+		}
+		seg.currentBlockNumber = originBlockNumber
+		seg.currentBlockSize = originBlockSize
+		if committed > 0 {
+			seg.currentBlockNumber = ends[committed-1].blockNumber
+			seg.currentBlockSize = ends[committed-1].blockSize
+		}
+		if n > 0 {
+			if truncErr := seg.truncate(); truncErr != nil {
+				return nil, fmt.Errorf("%w, and truncate the torn data failed: %v", err, truncErr)
+			}
+		}
+		positions = positions[:committed]
+	}
+	for i, pos := range positions {
+		timestamp, timed := rawTimestamp(data[i], flags)
+		seg.index.add(pos, timestamp, timed)
 	}
 	return
 }
 
-// Write writes the data to the segment file.
+// writeCursor records the status of the segment after an entry is written to the chunk buffer.
+type writeCursor struct {
+	blockNumber uint32
+	blockSize   uint32
+	end         int
+}
+
 func (seg *segment) Write(data []byte) (pos *ChunkPosition, err error) {
-	if seg.closed {
+	return seg.writeWithFlags(data, 0)
+}
+
+// writeWithFlags writes the data with the given entry flags to the segment file.
+// The data must already contain the prefix required by the flags.
+func (seg *segment) writeWithFlags(data []byte, flags byte) (pos *ChunkPosition, err error) {
+	if seg.closed.Load() {
 		return nil, ErrClosed
 	}
+	if err := seg.reserveBuffer(maxChunksSize(len(data))); err != nil {
+		return nil, err
+	}
 
 	originBlockNumber := seg.currentBlockNumber
 	originBlockSize := seg.currentBlockSize
@@ -330,30 +716,46 @@ func (seg *segment) Write(data []byte) (pos *ChunkPosition, err error) {
 			seg.currentBlockNumber = originBlockNumber
 			seg.currentBlockSize = originBlockSize
 		}
+		// make the written entry visible to the readers.
+		seg.updateWrittenSize()
 		bytebufferpool.Put(chunkBuffer)
 	}()
 
 	// write all data to the chunk buffer
-	pos, err = seg.writeToBuffer(data, chunkBuffer)
+	pos, err = seg.writeToBuffer(data, flags, chunkBuffer)
 	if err != nil {
 		return
 	}
-	// write the chunk buffer to the segment file
-	if err = seg.writeChunkBuffer(chunkBuffer); err != nil {
+	// write the chunk buffer to the segment file,
+	// truncate the torn data if it is written partially.
+	n, err := seg.writeChunkBuffer(chunkBuffer)
+	if err != nil {
+		if n > 0 {
+			seg.currentBlockNumber = originBlockNumber
+			seg.currentBlockSize = originBlockSize
+			if truncErr := seg.truncate(); truncErr != nil {
+				err = fmt.Errorf("%w, and truncate the torn data failed: %v", err, truncErr)
+			}
+		}
 		return
 	}
+	if (flags&entryFlagInternal == 0 || isNamespaceEntry(data, flags)) && (flags&entryFlagSpan == 0 || data[0] == spanFirst) {
+		timestamp, timed := rawTimestamp(data, flags)
+		seg.index.add(pos, timestamp, timed)
+	} else if flags&entryFlagSpan != 0 && pos.BlockNumber == 0 && pos.ChunkOffset == 0 {
+		seg.index.continued = true
+	}
 
 	return
 }
 
-func (seg *segment) appendChunkBuffer(buf *bytebufferpool.ByteBuffer, data []byte, chunkType ChunkType) {
+func (seg *segment) appendChunkBuffer(buf *bytebufferpool.ByteBuffer, data []byte, chunkType byte) {
 	// Length	2 Bytes	index:4-5
 	binary.LittleEndian.PutUint16(seg.header[4:6], uint16(len(data)))
 	// Type	1 Byte	index:6
 	seg.header[6] = chunkType
 	// Checksum	4 Bytes index:0-3
-	sum := crc32.ChecksumIEEE(seg.header[4:])
-	sum = crc32.Update(sum, crc32.IEEETable, data)
+	sum := seg.checksum.sum(seg.header[4:], data)
 	binary.LittleEndian.PutUint32(seg.header[:4], sum)
 
 	// append the header and data to segment chunk buffer
@@ -362,42 +764,126 @@ func (seg *segment) appendChunkBuffer(buf *bytebufferpool.ByteBuffer, data []byt
 }
 
 // write the pending chunk buffer to the segment file
-func (seg *segment) writeChunkBuffer(buf *bytebufferpool.ByteBuffer) error {
+// writeChunkBuffer writes the chunk buffer into the segment file,
+// it returns the number of bytes written, which may be less than the size
+// of the buffer if an error occurs.
+// The chunk buffer is appended to the write buffer instead if it has enough room,
+// the write buffer is always flushed by reserveBuffer before otherwise.
+func (seg *segment) writeChunkBuffer(buf *bytebufferpool.ByteBuffer) (int, error) {
 	if seg.currentBlockSize > blockSize {
-		return errors.New("the current block size exceeds the maximum block size")
+		return 0, errors.New("the current block size exceeds the maximum block size")
+	}
+	if err := seg.truncateTorn(); err != nil {
+		return 0, err
 	}
 
-	// write the data into underlying file
-	if _, err := seg.fd.Write(buf.Bytes()); err != nil {
-		return err
+	var (
+		n   int
+		err error
+	)
+	if seg.writeBufferSize > 0 && len(seg.writeBuffer)+buf.Len() <= seg.writeBufferSize {
+		if seg.writeBuffer == nil {
+			seg.writeBuffer = make([]byte, 0, seg.writeBufferSize)
+		}
+		seg.writeBuffer = append(seg.writeBuffer, buf.Bytes()...)
+		n = buf.Len()
+	} else {
+		// write the data into underlying file,
+		// a short write without error is treated as a failed write as well.
+		n, err = seg.fd.Write(buf.Bytes())
+		if err == nil && n < buf.Len() {
+			err = io.ErrShortWrite
+		}
 	}
 
 	// the cached block can not be reused again after writes.
 	seg.startupBlock.blockNumber = -1
+	// the footer is not at the end of the segment file anymore.
+	seg.hasFooter = false
+	return n, err
+}
+
+// truncate truncates the segment file to the current block number and block size,
+// it is used to remove the torn data of a failed write, such as the one failed by a full disk.
+// If it fails, the torn data is truncated again before the next write,
+// so the following entries are never appended after the torn data.
+func (seg *segment) truncate() error {
+	return seg.truncateTo(int64(seg.currentBlockNumber)*blockSize + int64(seg.currentBlockSize))
+}
+
+// truncateTo truncates the segment file to the size, and records it if the truncate fails.
+func (seg *segment) truncateTo(size int64) error {
+	err := seg.fd.Truncate(size)
+	seg.torn = err != nil
+	seg.tornSize = size
+	return err
+}
+
+// truncateTorn truncates the torn data left by a failed truncate before writing,
+// the cursor may have been moved by the write, so the size of the failed truncate is used.
+func (seg *segment) truncateTorn() error {
+	if !seg.torn {
+		return nil
+	}
+	if err := seg.truncateTo(seg.tornSize); err != nil {
+		return fmt.Errorf("truncate the torn data of segment file %d failed: %w", seg.id, err)
+	}
 	return nil
 }
 
 // Read reads the data from the segment file by the block number and chunk offset.
 func (seg *segment) Read(blockNumber uint32, chunkOffset int64) ([]byte, error) {
-	value, _, err := seg.readInternal(blockNumber, chunkOffset)
-	return value, err
+	entry, err := seg.readInternal(blockNumber, chunkOffset, readOptions{})
+	return entry.data, err
 }
 
-func (seg *segment) readInternal(blockNumber uint32, chunkOffset int64) ([]byte, *ChunkPosition, error) {
-	if seg.closed {
-		return nil, nil, ErrClosed
+// readInternal reads the entry whose first chunk is at the given position.
+// If opts.skipData is true, the data will not be copied out and nil is returned,
+// but the checksum is still verified unless opts.skipChecksum is true.
+// If opts.ctx is done, its error is returned before reading the next block.
+func (seg *segment) readInternal(blockNumber uint32, chunkOffset int64, opts readOptions) (chunkEntry, error) {
+	if seg.closed.Load() {
+		return chunkEntry{}, ErrClosed
 	}
 
 	var (
-		result    []byte
+		entry     = chunkEntry{data: opts.buf[:0]}
+		prefixLen int
 		block     []byte
-		segSize   = seg.Size()
+		segSize   = seg.writtenSize.Load()
 		nextChunk = &ChunkPosition{SegmentId: seg.id}
+		// the checksums of the chunks of a multi-chunk entry are verified after all of them
+		// are read if ParallelChecksumThreshold is set, see verifyChunks.
+		deferred bool
+		chunks   []chunkChecksum
+		payloads []byte // the payloads of the deferred chunks if the data is skipped.
 	)
+	verifyDeferred := func(parallel bool) bool {
+		data := entry.data
+		if opts.skipData {
+			data = payloads
+		}
+		return seg.checksum.verifyChunks(chunks, data, parallel)
+	}
+	// incomplete verifies the chunks read so far before returning them as ErrIncompleteEntry.
+	incomplete := func() (chunkEntry, error) {
+		if deferred && !verifyDeferred(false) {
+			return chunkEntry{}, ErrInvalidCRC
+		}
+		return entry.incomplete()
+	}
+
+	// the mapped segment file is not unmapped until the read is done.
+	seg.mappedLock.RLock()
+	defer seg.mappedLock.RUnlock()
+	mapped := seg.mapped
 
-	if seg.isStartupTraversal {
+	switch {
+	case mapped != nil:
+		// the blocks are sliced from the mapping, no buffer is needed.
+	case seg.isStartupTraversal:
 		block = seg.startupBlock.block
-	} else {
+	default:
 		block = getBuffer()
 		if len(block) != blockSize {
 			block = make([]byte, blockSize)
@@ -405,7 +891,13 @@ func (seg *segment) readInternal(blockNumber uint32, chunkOffset int64) ([]byte,
 		defer putBuffer(block)
 	}
 
-	for {
+	for first := true; ; first = false {
+		if opts.ctx != nil {
+			if err := opts.ctx.Err(); err != nil {
+				return chunkEntry{}, err
+			}
+		}
+
 		size := int64(blockSize)
 		offset := int64(blockNumber) * blockSize
 		if size+offset > segSize {
@@ -413,49 +905,111 @@ func (seg *segment) readInternal(blockNumber uint32, chunkOffset int64) ([]byte,
 		}
 
 		if chunkOffset >= size {
-			return nil, nil, io.EOF
+			if !first {
+				return incomplete()
+			}
+			return chunkEntry{}, io.EOF
 		}
 
-		if seg.isStartupTraversal {
+		if mapped != nil {
+			block = mapped[offset : offset+size]
+		} else if seg.isStartupTraversal {
 			// There are two cases that we should read block from file:
 			// 1. the acquired block is not the cached one
 			// 2. new writes appended to the block, and the block
 			// is still smaller than 32KB, we must read it again because of the new writes.
 			if seg.startupBlock.blockNumber != int64(blockNumber) || size != blockSize {
 				// read block from segment file at the specified offset.
-				_, err := seg.fd.ReadAt(block[0:size], offset)
+				_, err := seg.readFd.ReadAt(block[0:size], offset)
 				if err != nil {
-					return nil, nil, err
+					return chunkEntry{}, seg.readError(err)
 				}
 				// remember the block
 				seg.startupBlock.blockNumber = int64(blockNumber)
 			}
 		} else {
-			if _, err := seg.fd.ReadAt(block[0:size], offset); err != nil {
-				return nil, nil, err
+			if _, err := seg.readFd.ReadAt(block[0:size], offset); err != nil {
+				return chunkEntry{}, seg.readError(err)
+			}
+		}
+
+		// the chunk must be entirely in the segment file,
+		// otherwise it is torn by a crash or the length is corrupted.
+		if chunkOffset+chunkHeaderSize > size {
+			if !first {
+				return incomplete()
 			}
+			return chunkEntry{}, io.ErrUnexpectedEOF
 		}
 
 		// header
 		header := block[chunkOffset : chunkOffset+chunkHeaderSize]
 
+		// the chunks after the first one must be the MIDDLE or LAST ones of the entry,
+		// otherwise the entry is torn, and the chunk is the start of a following entry.
+		if chunkType := header[6] & chunkTypeMask; !first && (chunkType == ChunkTypeFull || chunkType == ChunkTypeFirst) {
+			return incomplete()
+		}
+
+		// the flags of the entry are taken from its first chunk.
+		if first {
+			entry.flags = header[6] &^ chunkTypeMask
+			// the header of the compressed data is also needed to know its length.
+			prefixLen = entryPrefixSize(entry.flags) + codecHeaderSize(entry.flags)
+			// the kind of the fragment is before the prefix, see readSpan.
+			if entry.flags&entryFlagSpan != 0 {
+				prefixLen++
+			}
+			// the namespace id of the internal entry is before the prefix, see decodeNamespace.
+			if entry.flags&entryFlagInternal != 0 {
+				prefixLen += namespaceHeaderSize
+			}
+		}
+
 		// length
 		length := binary.LittleEndian.Uint16(header[4:6])
+		if chunkOffset+chunkHeaderSize+int64(length) > size {
+			if !first {
+				return incomplete()
+			}
+			return chunkEntry{}, io.ErrUnexpectedEOF
+		}
+		entry.length += int(length)
 
-		// copy data
+		// copy data, only the prefix is copied if skipData is true.
 		start := chunkOffset + chunkHeaderSize
-		result = append(result, block[start:start+int64(length)]...)
+		copyLen := int64(length)
+		if opts.skipData && int64(prefixLen-len(entry.data)) < copyLen {
+			copyLen = int64(prefixLen - len(entry.data))
+		}
+		dataStart := len(entry.data)
+		entry.data = append(entry.data, block[start:start+copyLen]...)
 
 		// check sum
 		checksumEnd := chunkOffset + chunkHeaderSize + int64(length)
-		checksum := crc32.ChecksumIEEE(block[chunkOffset+4 : checksumEnd])
-		savedSum := binary.LittleEndian.Uint32(header[:4])
-		if savedSum != checksum {
-			return nil, nil, ErrInvalidCRC
+		if first && !opts.skipChecksum && seg.parallelChecksumSize > 0 && header[6]&chunkTypeMask == ChunkTypeFirst {
+			deferred = true
+		}
+		if deferred {
+			chunk := chunkChecksum{sum: binary.LittleEndian.Uint32(header[:4]), length: length}
+			copy(chunk.header[:], header[4:])
+			if opts.skipData {
+				chunk.data = len(payloads)
+				payloads = append(payloads, block[start:checksumEnd]...)
+			} else {
+				chunk.data = dataStart
+			}
+			chunks = append(chunks, chunk)
+		} else if !opts.skipChecksum {
+			checksum := seg.checksum.sum(block[chunkOffset+4:checksumEnd], nil)
+			savedSum := binary.LittleEndian.Uint32(header[:4])
+			if savedSum != checksum {
+				return chunkEntry{}, ErrInvalidCRC
+			}
 		}
 
 		// type
-		chunkType := header[6]
+		chunkType := header[6] & chunkTypeMask
 
 		if chunkType == ChunkTypeFull || chunkType == ChunkTypeLast {
 			nextChunk.BlockNumber = blockNumber
@@ -471,44 +1025,207 @@ func (seg *segment) readInternal(blockNumber uint32, chunkOffset int64) ([]byte,
 		blockNumber += 1
 		chunkOffset = 0
 	}
-	return result, nextChunk, nil
+
+	if deferred && !verifyDeferred(entry.length >= seg.parallelChecksumSize) {
+		return chunkEntry{}, ErrInvalidCRC
+	}
+	entry.next = nextChunk
+	// the fragment of a spanning entry is decoded by readSpan.
+	if entry.flags&entryFlagSpan != 0 {
+		return entry, nil
+	}
+	if err := entry.decodeNamespace(); err != nil {
+		return chunkEntry{}, err
+	}
+	if err := entry.decodePrefix(); err != nil {
+		return chunkEntry{}, err
+	}
+	if err := entry.decompress(opts.skipData); err != nil {
+		return chunkEntry{}, err
+	}
+	if opts.skipData {
+		entry.data = nil
+	}
+	return entry, nil
+}
+
+// chunkHeader is the header of a chunk read by readHeader.
+type chunkHeader struct {
+	chunkType ChunkType
+	flags     byte
+	length    uint16
+	crc       uint32
+}
+
+// readHeader reads the header of the chunk at the position without reading its payload.
+// It returns io.EOF if there is no chunk at the position.
+func (seg *segment) readHeader(blockNumber uint32, chunkOffset int64) (chunkHeader, error) {
+	offset := int64(blockNumber)*blockSize + chunkOffset
+	segSize := seg.writtenSize.Load()
+	if chunkOffset >= blockSize || offset >= segSize {
+		return chunkHeader{}, io.EOF
+	}
+	if chunkOffset+chunkHeaderSize > blockSize || offset+chunkHeaderSize > segSize {
+		return chunkHeader{}, io.ErrUnexpectedEOF
+	}
+
+	header := make([]byte, chunkHeaderSize)
+	if err := seg.readAt(header, offset); err != nil {
+		return chunkHeader{}, err
+	}
+	return chunkHeader{
+		chunkType: header[6] & chunkTypeMask,
+		flags:     header[6] &^ chunkTypeMask,
+		length:    binary.LittleEndian.Uint16(header[4:6]),
+		crc:       binary.LittleEndian.Uint32(header[:4]),
+	}, nil
+}
+
+// verifyChunk reads the payload of the chunk at the position, whose header is h,
+// and reports whether the checksum in the header matches it.
+// It returns io.ErrUnexpectedEOF if the payload is beyond the block or the segment file.
+func (seg *segment) verifyChunk(blockNumber uint32, chunkOffset int64, h chunkHeader) (bool, error) {
+	offset := int64(blockNumber)*blockSize + chunkOffset
+	end := chunkOffset + chunkHeaderSize + int64(h.length)
+	if end > blockSize || offset-chunkOffset+end > seg.writtenSize.Load() {
+		return false, io.ErrUnexpectedEOF
+	}
+	chunk := make([]byte, chunkHeaderSize+int(h.length))
+	if err := seg.readAt(chunk, offset); err != nil {
+		return false, err
+	}
+	return seg.checksum.sum(chunk[4:], nil) == h.crc, nil
+}
+
+// readAt reads len(p) bytes of the segment file at the offset, from the mapping if it is mapped.
+func (seg *segment) readAt(p []byte, offset int64) error {
+	if seg.closed.Load() {
+		return ErrClosed
+	}
+	// the mapped segment file is not unmapped until the read is done.
+	seg.mappedLock.RLock()
+	defer seg.mappedLock.RUnlock()
+	if seg.mapped != nil {
+		copy(p, seg.mapped[offset:offset+int64(len(p))])
+		return nil
+	}
+	if _, err := seg.readFd.ReadAt(p, offset); err != nil {
+		return seg.readError(err)
+	}
+	return nil
+}
+
+// incomplete returns the data of the chunks read so far along with ErrIncompleteEntry,
+// it is used when the chunks of the entry end before its last chunk.
+// The prefix is stripped if it is read entirely, but the compressed data is not decompressed.
+func (e *chunkEntry) incomplete() (chunkEntry, error) {
+	if e.flags&entryFlagSpan == 0 && len(e.data) >= entryPrefixSize(e.flags) {
+		_ = e.decodePrefix()
+	}
+	return *e, ErrIncompleteEntry
 }
 
 // Next returns the Next chunk data.
 // You can call it repeatedly until io.EOF is returned.
 func (segReader *segmentReader) Next() ([]byte, *ChunkPosition, error) {
-	// The segment file is closed
-	if segReader.segment.closed {
-		return nil, nil, ErrClosed
-	}
+	entry, chunkPosition, err := segReader.next(false)
+	return entry.data, chunkPosition, err
+}
 
-	// this position describes the current chunk info
-	chunkPosition := &ChunkPosition{
-		SegmentId:   segReader.segment.id,
-		BlockNumber: segReader.blockNumber,
-		ChunkOffset: segReader.chunkOffset,
-	}
+// NextPosition returns the position and the data length of the Next chunk,
+// the data is not copied out.
+// You can call it repeatedly until io.EOF is returned.
+func (segReader *segmentReader) NextPosition() (*ChunkPosition, int, error) {
+	entry, chunkPosition, err := segReader.next(true)
+	return chunkPosition, entry.length, err
+}
 
-	value, nextChunk, err := segReader.segment.readInternal(
-		segReader.blockNumber,
-		segReader.chunkOffset,
-	)
-	if err != nil {
-		return nil, nil, err
+func (segReader *segmentReader) next(skipData bool) (chunkEntry, *ChunkPosition, error) {
+	if segReader.prefetched != nil {
+		return segReader.nextPrefetched()
 	}
+	for {
+		// The segment file is closed
+		if segReader.segment.closed.Load() {
+			return chunkEntry{}, nil, ErrClosed
+		}
 
-	// Calculate the chunk size.
-	// Remember that the chunk size is just an estimated value,
-	// not accurate, so don't use it for any important logic.
-	chunkPosition.ChunkSize =
-		nextChunk.BlockNumber*blockSize + uint32(nextChunk.ChunkOffset) -
-			(segReader.blockNumber*blockSize + uint32(segReader.chunkOffset))
+		// the snapshot reader doesn't see the data written after it is created.
+		if segReader.bounded && int64(segReader.blockNumber)*blockSize+segReader.chunkOffset >= segReader.end {
+			return chunkEntry{}, nil, io.EOF
+		}
 
-	// update the position
-	segReader.blockNumber = nextChunk.BlockNumber
-	segReader.chunkOffset = nextChunk.ChunkOffset
+		// this position describes the current chunk info
+		chunkPosition := &ChunkPosition{
+			SegmentId:   segReader.segment.id,
+			BlockNumber: segReader.blockNumber,
+			ChunkOffset: segReader.chunkOffset,
+		}
 
-	return value, chunkPosition, nil
+		entry, err := segReader.segment.readInternal(
+			segReader.blockNumber,
+			segReader.chunkOffset,
+			readOptions{skipData: skipData, ctx: segReader.ctx},
+		)
+		// the last entry is being written, or torn by a crash,
+		// there is no more data to read for now.
+		if err == ErrIncompleteEntry {
+			err = io.EOF
+		}
+		if err != nil {
+			return chunkEntry{}, nil, err
+		}
+		nextChunk := entry.next
+
+		// the data of the internal entry is always read to tell the markers.
+		if entry.flags&entryFlagInternal != 0 && segReader.keepMarkers && skipData {
+			entry, err = segReader.segment.readInternal(segReader.blockNumber, segReader.chunkOffset,
+				readOptions{ctx: segReader.ctx})
+			if err != nil {
+				return chunkEntry{}, nil, err
+			}
+		}
+		_, isCommit := entry.commitStart()
+		isMarker := isCommit || entry.isMarker()
+
+		// skip the internal entries written by the WAL itself, such as the footer,
+		// and the fragments following the first one of a spanning entry.
+		if entry.flags&entryFlagInternal != 0 && !(isMarker && segReader.keepMarkers) || entry.isSpanContinuation() {
+			segReader.blockNumber = nextChunk.BlockNumber
+			segReader.chunkOffset = nextChunk.ChunkOffset
+			continue
+		}
+
+		// Calculate the chunk size.
+		// Remember that the chunk size is just an estimated value,
+		// not accurate, so don't use it for any important logic.
+		chunkPosition.ChunkSize =
+			nextChunk.BlockNumber*blockSize + uint32(nextChunk.ChunkOffset) -
+				(segReader.blockNumber*blockSize + uint32(segReader.chunkOffset))
+
+		// update the position
+		segReader.blockNumber = nextChunk.BlockNumber
+		segReader.chunkOffset = nextChunk.ChunkOffset
+
+		return entry, chunkPosition, nil
+	}
+}
+
+// end returns the position immediately after the chunks, the ChunkSize of it is 0.
+// If the left space of the block can not hold a chunk header, it is padded,
+// and the start of the next block is returned.
+func (cp *ChunkPosition) end() *ChunkPosition {
+	offset := int64(cp.BlockNumber)*blockSize + cp.ChunkOffset + int64(cp.ChunkSize)
+	end := &ChunkPosition{
+		SegmentId:   cp.SegmentId,
+		BlockNumber: uint32(offset / blockSize),
+		ChunkOffset: offset % blockSize,
+	}
+	if end.ChunkOffset+chunkHeaderSize >= blockSize {
+		end.BlockNumber += 1
+		end.ChunkOffset = 0
+	}
+	return end
 }
 
 // Encode encodes the chunk position to a byte slice.
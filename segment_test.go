@@ -2,6 +2,7 @@ package wal
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
 	"math"
 	"os"
@@ -9,11 +10,12 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/valyala/bytebufferpool"
 )
 
 func TestSegment_Write_FULL1(t *testing.T) {
 	dir, _ := os.MkdirTemp("", "seg-test-full1")
-	seg, err := openSegmentFile(dir, ".SEG", 1)
+	seg, err := openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
 	assert.Nil(t, err)
 	defer func() {
 		_ = seg.Remove()
@@ -47,7 +49,7 @@ func TestSegment_Write_FULL1(t *testing.T) {
 
 func TestSegment_Write_FULL2(t *testing.T) {
 	dir, _ := os.MkdirTemp("", "seg-test-full2")
-	seg, err := openSegmentFile(dir, ".SEG", 1)
+	seg, err := openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
 	assert.Nil(t, err)
 	defer func() {
 		_ = seg.Remove()
@@ -75,7 +77,7 @@ func TestSegment_Write_FULL2(t *testing.T) {
 
 func TestSegment_Write_Padding(t *testing.T) {
 	dir, _ := os.MkdirTemp("", "seg-test-padding")
-	seg, err := openSegmentFile(dir, ".SEG", 1)
+	seg, err := openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
 	assert.Nil(t, err)
 	defer func() {
 		_ = seg.Remove()
@@ -98,7 +100,7 @@ func TestSegment_Write_Padding(t *testing.T) {
 
 func TestSegment_Write_NOT_FULL(t *testing.T) {
 	dir, _ := os.MkdirTemp("", "seg-test-not-full")
-	seg, err := openSegmentFile(dir, ".SEG", 1)
+	seg, err := openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
 	assert.Nil(t, err)
 	defer func() {
 		_ = seg.Remove()
@@ -136,7 +138,7 @@ func TestSegment_Write_NOT_FULL(t *testing.T) {
 
 func TestSegment_Reader_FULL(t *testing.T) {
 	dir, _ := os.MkdirTemp("", "seg-test-reader-full")
-	seg, err := openSegmentFile(dir, ".SEG", 1)
+	seg, err := openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
 	assert.Nil(t, err)
 	defer func() {
 		_ = seg.Remove()
@@ -168,7 +170,7 @@ func TestSegment_Reader_FULL(t *testing.T) {
 
 func TestSegment_Reader_Padding(t *testing.T) {
 	dir, _ := os.MkdirTemp("", "seg-test-reader-padding")
-	seg, err := openSegmentFile(dir, ".SEG", 1)
+	seg, err := openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
 	assert.Nil(t, err)
 	defer func() {
 		_ = seg.Remove()
@@ -202,7 +204,7 @@ func TestSegment_Reader_Padding(t *testing.T) {
 
 func TestSegment_Reader_NOT_FULL(t *testing.T) {
 	dir, _ := os.MkdirTemp("", "seg-test-reader-not-full")
-	seg, err := openSegmentFile(dir, ".SEG", 1)
+	seg, err := openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
 	assert.Nil(t, err)
 	defer func() {
 		_ = seg.Remove()
@@ -248,7 +250,7 @@ func TestSegment_Reader_NOT_FULL(t *testing.T) {
 
 func TestSegment_Reader_ManyChunks_FULL(t *testing.T) {
 	dir, _ := os.MkdirTemp("", "seg-test-reader-ManyChunks_FULL")
-	seg, err := openSegmentFile(dir, ".SEG", 1)
+	seg, err := openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
 	assert.Nil(t, err)
 	defer func() {
 		_ = seg.Remove()
@@ -285,7 +287,7 @@ func TestSegment_Reader_ManyChunks_FULL(t *testing.T) {
 
 func TestSegment_Reader_ManyChunks_NOT_FULL(t *testing.T) {
 	dir, _ := os.MkdirTemp("", "seg-test-reader-ManyChunks_NOT_FULL")
-	seg, err := openSegmentFile(dir, ".SEG", 1)
+	seg, err := openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
 	assert.Nil(t, err)
 	defer func() {
 		_ = seg.Remove()
@@ -334,7 +336,7 @@ func TestSegment_Write_LargeSize(t *testing.T) {
 
 func testSegmentReaderLargeSize(t *testing.T, size int, count int) {
 	dir, _ := os.MkdirTemp("", "seg-test-reader-ManyChunks_large_size")
-	seg, err := openSegmentFile(dir, ".SEG", 1)
+	seg, err := openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
 	assert.Nil(t, err)
 	defer func() {
 		_ = seg.Remove()
@@ -369,6 +371,44 @@ func testSegmentReaderLargeSize(t *testing.T, size int, count int) {
 	assert.Equal(t, count, len(values))
 }
 
+func TestSegment_ReadFd(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "seg-test-read-fd")
+	seg, err := openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
+	assert.Nil(t, err)
+	defer func() {
+		_ = seg.Remove()
+	}()
+	assert.NotEqual(t, seg.fd, seg.readFd)
+
+	val := []byte(strings.Repeat("X", 100))
+	for i := 0; i < 1000; i++ {
+		pos, err := seg.Write(val)
+		assert.Nil(t, err)
+		res, err := seg.Read(pos.BlockNumber, pos.ChunkOffset)
+		assert.Nil(t, err)
+		assert.Equal(t, val, res)
+
+		// the reads never move the offset of the append fd.
+		offset, err := seg.fd.Seek(0, io.SeekCurrent)
+		assert.Nil(t, err)
+		assert.Equal(t, seg.Size(), offset)
+	}
+
+	// the read fd is read only.
+	_, err = seg.readFd.Write(val)
+	assert.NotNil(t, err)
+
+	// the size is taken by stat when reopening, the offset of the fd is not moved.
+	size := seg.Size()
+	assert.Nil(t, seg.Close())
+	seg, err = openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, size, seg.Size())
+	offset, err := seg.fd.Seek(0, io.SeekCurrent)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), offset)
+}
+
 func TestChunkPosition_Encode(t *testing.T) {
 	validate := func(pos *ChunkPosition) {
 		res := pos.Encode()
@@ -395,3 +435,146 @@ func TestChunkPosition_EncodeFixedSize(t *testing.T) {
 	validate(&ChunkPosition{0, 0, 0, 0})
 	validate(&ChunkPosition{math.MaxUint32, math.MaxUint32, math.MaxInt64, math.MaxUint32})
 }
+
+func BenchmarkSegment_appendChunkBuffer(b *testing.B) {
+	seg := &segment{header: make([]byte, chunkHeaderSize)}
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+	for _, size := range []int{16, KB, blockSize - chunkHeaderSize} {
+		data := []byte(strings.Repeat("X", size))
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				seg.appendChunkBuffer(buf, data, ChunkTypeFull)
+			}
+		})
+	}
+}
+
+func TestSegment_Write_Allocs(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "seg-test-write-allocs")
+	seg, err := openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
+	assert.Nil(t, err)
+	defer func() {
+		_ = seg.Remove()
+	}()
+
+	// only the returned position is allocated, the chunk buffers
+	// and the paddings are not allocated for every write.
+	// Notice that the buffers larger than the calibrated size of bytebufferpool
+	// are not kept in the pool, so only the small writes are checked.
+	val := []byte(strings.Repeat("X", 100))
+	allocs := testing.AllocsPerRun(1000, func() {
+		_, err := seg.Write(val)
+		assert.Nil(t, err)
+	})
+	assert.Equal(t, float64(1), allocs)
+}
+
+func TestSegment_MMap(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "seg-test-mmap")
+	seg, err := openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
+	assert.Nil(t, err)
+	defer func() {
+		_ = seg.Remove()
+	}()
+
+	var positions []*ChunkPosition
+	for i := 0; i < 100; i++ {
+		pos, err := seg.Write([]byte(strings.Repeat("X", i*1000)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.Nil(t, seg.writeFooter())
+	assert.Nil(t, seg.mmap())
+	assert.NotNil(t, seg.mapped)
+
+	for i, pos := range positions {
+		res, err := seg.Read(pos.BlockNumber, pos.ChunkOffset)
+		assert.Nil(t, err)
+		assert.Equal(t, i*1000, len(res))
+	}
+	reader := seg.NewReader()
+	for i := 0; ; i++ {
+		_, length, err := reader.NextPosition()
+		if err == io.EOF {
+			assert.Equal(t, len(positions), i)
+			break
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, i*1000, length)
+	}
+
+	// the mapping is released when closing.
+	assert.Nil(t, seg.Close())
+	assert.Nil(t, seg.mapped)
+	_, err = seg.Read(positions[0].BlockNumber, positions[0].ChunkOffset)
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestSegment_Read_Incomplete(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "seg-test-read-incomplete")
+	seg, err := openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
+	assert.Nil(t, err)
+	defer func() {
+		_ = seg.Remove()
+	}()
+
+	val := []byte(strings.Repeat("X", 3*blockSize))
+	pos, err := seg.Write(val)
+	assert.Nil(t, err)
+
+	// only the first chunks are written, in the middle of the third chunk.
+	size := int64(2*blockSize + 100)
+	seg.writtenSize.Store(size)
+	res, err := seg.Read(pos.BlockNumber, pos.ChunkOffset)
+	assert.Equal(t, ErrIncompleteEntry, err)
+	assert.Equal(t, val[:2*(blockSize-chunkHeaderSize)], res)
+
+	// the header of the next chunk is not written.
+	seg.writtenSize.Store(blockSize)
+	res, err = seg.Read(pos.BlockNumber, pos.ChunkOffset)
+	assert.Equal(t, ErrIncompleteEntry, err)
+	assert.Equal(t, val[:blockSize-chunkHeaderSize], res)
+
+	// the reader stops before the incomplete entry.
+	_, _, err = seg.NewReader().Next()
+	assert.Equal(t, io.EOF, err)
+
+	seg.writtenSize.Store(seg.Size())
+	res, err = seg.Read(pos.BlockNumber, pos.ChunkOffset)
+	assert.Nil(t, err)
+	assert.Equal(t, val, res)
+}
+
+func TestSegment_Read_TornChain(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "seg-test-read-torn-chain")
+	seg, err := openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
+	assert.Nil(t, err)
+	val := []byte(strings.Repeat("X", 3*blockSize))
+	pos, err := seg.Write(val)
+	assert.Nil(t, err)
+	assert.Nil(t, seg.Close())
+
+	// only the FIRST chunk survives a crash, and a new entry is written after it.
+	path := SegmentFileName(dir, ".SEG", 1)
+	assert.Nil(t, os.Truncate(path, blockSize))
+	seg, err = openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
+	assert.Nil(t, err)
+	defer func() {
+		_ = seg.Remove()
+	}()
+	small, err := seg.Write([]byte("small"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), small.BlockNumber)
+
+	// the FULL chunk of the new entry is not joined to the torn entry.
+	res, err := seg.Read(pos.BlockNumber, pos.ChunkOffset)
+	assert.Equal(t, ErrIncompleteEntry, err)
+	assert.Equal(t, val[:blockSize-chunkHeaderSize], res)
+	res, err = seg.Read(small.BlockNumber, small.ChunkOffset)
+	assert.Nil(t, err)
+	assert.Equal(t, "small", string(res))
+}
@@ -0,0 +1,192 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// shardDirPrefix is the prefix of the directories of the shards of a ShardedWAL.
+const shardDirPrefix = "shard-"
+
+var (
+	ErrInvalidShard    = errors.New("the shard of the position is not in the sharded WAL")
+	ErrShardCountDiffs = errors.New("the number of the shards differs from the one in the directory")
+)
+
+// ShardedWAL fans the writes across several independent WALs by the shard keys,
+// each shard has its own active segment file and lock, so the writes to different shards
+// run in parallel, which raises the write throughput on the machines with many cores.
+// The shards are stored in the subdirectories of options.DirPath, named shard-0, shard-1 and so on.
+//
+// The entries of a shard are ordered as they are written, but there is no order
+// between the entries of different shards, so the entries that must be read in order,
+// such as the ones of the same record, should be written with the same shard key.
+type ShardedWAL struct {
+	shards []*WAL
+}
+
+// ShardPosition is the position of an entry in a ShardedWAL,
+// the shard the entry is written to along with its position in the WAL of the shard.
+type ShardPosition struct {
+	Shard    int
+	Position *ChunkPosition
+}
+
+// OpenSharded opens a ShardedWAL of the number of shards with the options,
+// every shard is a WAL opened by Open with the options, except that the directory is
+// the one of the shard in options.DirPath.
+// The number of shards must be the same whenever the ShardedWAL is opened,
+// since the shard of a key depends on it, ErrShardCountDiffs is returned otherwise.
+func OpenSharded(options Options, shards int) (*ShardedWAL, error) {
+	if shards <= 0 {
+		return nil, errors.New("the number of the shards must be positive")
+	}
+	fs := options.FileSystem
+	if fs == nil {
+		fs = OSFileSystem
+	}
+	if err := fs.MkdirAll(options.DirPath, options.DirMode); err != nil {
+		return nil, err
+	}
+	entries, err := fs.ReadDir(options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	var existing int
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), shardDirPrefix) {
+			existing++
+		}
+	}
+	if existing > 0 && existing != shards {
+		return nil, fmt.Errorf("%w: %d shards in %s, %d expected", ErrShardCountDiffs, existing, options.DirPath, shards)
+	}
+
+	s := &ShardedWAL{shards: make([]*WAL, 0, shards)}
+	dirPath := options.DirPath
+	for i := 0; i < shards; i++ {
+		options.DirPath = filepath.Join(dirPath, fmt.Sprintf("%s%d", shardDirPrefix, i))
+		wal, err := Open(options)
+		if err != nil {
+			_ = s.Close()
+			return nil, fmt.Errorf("open shard %d failed: %w", i, err)
+		}
+		s.shards = append(s.shards, wal)
+	}
+	return s, nil
+}
+
+// Shards returns the WALs of the shards, which can be used for the other operations,
+// such as writing to a known shard or reading one shard only.
+func (s *ShardedWAL) Shards() []*WAL {
+	return s.shards
+}
+
+// ShardOf returns the shard which the data written with the key goes to.
+func (s *ShardedWAL) ShardOf(key []byte) int {
+	return int(xxhash.Sum64(key) % uint64(len(s.shards)))
+}
+
+// Write writes the data to the shard of the key, like WAL.Write.
+func (s *ShardedWAL) Write(key []byte, data []byte) (*ShardPosition, error) {
+	shard := s.ShardOf(key)
+	pos, err := s.shards[shard].Write(data)
+	if err != nil {
+		return nil, err
+	}
+	return &ShardPosition{Shard: shard, Position: pos}, nil
+}
+
+// Read reads the data from the shard of the position, like WAL.Read.
+func (s *ShardedWAL) Read(pos *ShardPosition) ([]byte, error) {
+	if pos.Shard < 0 || pos.Shard >= len(s.shards) {
+		return nil, fmt.Errorf("shard %d: %w", pos.Shard, ErrInvalidShard)
+	}
+	return s.shards[pos.Shard].Read(pos.Position)
+}
+
+// Sync syncs the active segment files of all the shards.
+func (s *ShardedWAL) Sync() error {
+	var errs []error
+	for _, wal := range s.shards {
+		errs = append(errs, wal.Sync())
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes all the shards, and returns their errors joined by errors.Join.
+func (s *ShardedWAL) Close() error {
+	var errs []error
+	for _, wal := range s.shards {
+		errs = append(errs, wal.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// NewReader returns a new reader of all the shards, which yields all the entries
+// of the shard 0, then the ones of the shard 1, and so on.
+// Like the Reader, it takes a snapshot of the segment files of the shards when it is created.
+func (s *ShardedWAL) NewReader() *ShardedReader {
+	readers := make([]*Reader, 0, len(s.shards))
+	for _, wal := range s.shards {
+		readers = append(readers, wal.NewReader())
+	}
+	return &ShardedReader{readers: readers}
+}
+
+// ShardedReader reads the entries of all the shards of a ShardedWAL, see ShardedWAL.NewReader.
+// A ShardedReader is not safe for concurrent use by multiple goroutines.
+type ShardedReader struct {
+	readers []*Reader
+	current int
+}
+
+// Next returns the next entry data and its position in the ShardedWAL.
+// If there is no data, io.EOF will be returned.
+func (r *ShardedReader) Next() ([]byte, *ShardPosition, error) {
+	for r.current < len(r.readers) {
+		data, pos, err := r.readers[r.current].Next()
+		if err == io.EOF {
+			r.readers[r.current].Close()
+			r.current++
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return data, &ShardPosition{Shard: r.current, Position: pos}, nil
+	}
+	return nil, nil, io.EOF
+}
+
+// Close releases the segment files referenced by the reader of every shard,
+// the reader can not be used after that.
+func (r *ShardedReader) Close() {
+	for _, reader := range r.readers[r.current:] {
+		reader.Close()
+	}
+	r.current = len(r.readers)
+}
+
+// Encode encodes the shard position to a byte slice.
+// You can decode it by calling wal.DecodeShardPosition().
+func (p *ShardPosition) Encode() []byte {
+	buf := binary.AppendUvarint(nil, uint64(p.Shard))
+	return append(buf, p.Position.Encode()...)
+}
+
+// DecodeShardPosition decodes the shard position from a byte slice.
+// You can encode it by calling wal.ShardPosition.Encode().
+func DecodeShardPosition(buf []byte) *ShardPosition {
+	shard, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil
+	}
+	return &ShardPosition{Shard: int(shard), Position: DecodeChunkPosition(buf[n:])}
+}
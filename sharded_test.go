@@ -0,0 +1,69 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedWAL(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-sharded")
+	defer os.RemoveAll(dir)
+	opts := DefaultOptions
+	opts.DirPath = dir
+	s, err := OpenSharded(opts, 4)
+	assert.Nil(t, err)
+
+	positions := make(map[string]*ShardPosition)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		pos, err := s.Write([]byte(key), []byte("value-"+key))
+		assert.Nil(t, err)
+		assert.Equal(t, s.ShardOf([]byte(key)), pos.Shard)
+		positions[key] = pos
+	}
+	for key, pos := range positions {
+		data, err := s.Read(DecodeShardPosition(pos.Encode()))
+		assert.Nil(t, err)
+		assert.Equal(t, "value-"+key, string(data))
+	}
+	_, err = s.Read(&ShardPosition{Shard: 4, Position: positions["key-0"].Position})
+	assert.ErrorIs(t, err, ErrInvalidShard)
+
+	// all the entries are read, in order within every shard.
+	assert.Nil(t, s.Sync())
+	reader := s.NewReader()
+	var count int
+	last := make(map[int]*ChunkPosition)
+	for {
+		data, pos, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		key := string(data[len("value-"):])
+		assert.Equal(t, positions[key].Shard, pos.Shard)
+		if prev := last[pos.Shard]; prev != nil {
+			assert.True(t, prev.ChunkOffset < pos.Position.ChunkOffset)
+		}
+		last[pos.Shard] = pos.Position
+		count++
+	}
+	reader.Close()
+	assert.Equal(t, 100, count)
+	assert.Equal(t, 4, len(last))
+	assert.Nil(t, s.Close())
+
+	// the shards must be reopened with the same number.
+	_, err = OpenSharded(opts, 3)
+	assert.ErrorIs(t, err, ErrShardCountDiffs)
+	s, err = OpenSharded(opts, 4)
+	assert.Nil(t, err)
+	defer s.Close()
+	data, err := s.Read(positions["key-42"])
+	assert.Nil(t, err)
+	assert.Equal(t, "value-key-42", string(data))
+}
@@ -0,0 +1,600 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// In the SingleFile mode, all the segment files are the logical regions of one file
+// named singleFileName in DirPath, see Options.SingleFile.
+//
+//	+------------+------------+--------+--------+--- ... ---+
+//	| Manifest 0 | Manifest 1 | Slot 0 | Slot 1 |    ...    |
+//	+------------+------------+--------+--------+--- ... ---+
+//
+// Every segment file takes up a slot, whose size is fixed when the file is created
+// and large enough for a full segment file with its footer.
+// The manifest records the name and the size of the segment file in every slot:
+//
+//	+----------+---------+--------------+-------------+--- ... ---+----------+
+//	| Magic(8) | Seq(8B) | SlotSize(8B) | Records(4B) |  Records  | CRC(4B)  |
+//	+----------+---------+--------------+-------------+--- ... ---+----------+
+//
+//	Record = Slot(4B) + Size(8B) + Name length(2B) + Name
+//
+// The record whose name is empty is a free slot which still has the data of a removed segment file,
+// it is zeroed before being reused. The two copies of the manifest are written alternately,
+// the valid one with the larger seq is used, so a torn manifest write never loses it.
+//
+// The sizes are persisted when a segment file is synced, created, renamed or removed,
+// the data written after that is found by scanning the slot from the persisted size,
+// the unused space of a slot is always zeroed, so the scan stops at the end of the data.
+const (
+	singleFileName       = "WAL"
+	singleFileMagic      = "RWALSF\x00\x01"
+	manifestSize         = blockSize
+	manifestHeaderSize   = 28
+	manifestRecordSize   = 14
+	singleFileDataOffset = 2 * manifestSize
+)
+
+// singleFileSystem is the FileSystem of the SingleFile mode, the segment files in DirPath
+// are stored in the slots of one file, the other files are passed to the underlying FileSystem.
+type singleFileSystem struct {
+	FileSystem
+	options  *Options
+	dirPath  string
+	file     File
+	slotSize int64
+
+	mu      sync.Mutex
+	seq     uint64
+	entries map[string]*singleFileEntry // the segment files by name.
+	slots   []*singleFileEntry          // the segment files by slot, nil if the slot is free.
+	dirty   map[uint32]int64            // the size of the data left in the free slots.
+	changed atomic.Bool                 // whether the sizes are changed since the manifest is persisted.
+	writeMu sync.Mutex                  // serializes the writes, which seek before writing.
+}
+
+// singleFileEntry is a segment file in the single file.
+type singleFileEntry struct {
+	name    string
+	slot    uint32
+	size    atomic.Int64
+	removed atomic.Bool
+}
+
+// singleFileSegment is an opened handle of a segment file in the single file.
+type singleFileSegment struct {
+	fs     *singleFileSystem
+	entry  *singleFileEntry
+	name   string
+	append bool
+	offset int64
+}
+
+// openSingleFileSystem opens the single file in options.DirPath on top of options.FileSystem,
+// it is created if not exists. The segment files written after the last persisted size are recovered.
+func openSingleFileSystem(options *Options) (*singleFileSystem, error) {
+	path := filepath.Join(options.DirPath, singleFileName)
+	file, err := options.FileSystem.OpenFile(path, os.O_CREATE|os.O_RDWR, options.FileMode)
+	if err != nil {
+		return nil, err
+	}
+	sfs := &singleFileSystem{
+		FileSystem: options.FileSystem,
+		options:    options,
+		dirPath:    filepath.Clean(options.DirPath),
+		file:       file,
+		entries:    make(map[string]*singleFileEntry),
+		dirty:      make(map[uint32]int64),
+	}
+	if err := sfs.load(); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("open single file %s failed: %w", path, err)
+	}
+	return sfs, nil
+}
+
+// load loads the manifest, or writes the first one if the single file is empty.
+func (sfs *singleFileSystem) load() error {
+	info, err := sfs.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		// the slot holds a full segment file and its footer.
+		size := sfs.options.SegmentSize + footerHeaderSize + footerTrailerSize +
+			2*(sfs.options.SegmentSize/blockSize+1) + 2*blockSize
+		sfs.slotSize = (size + blockSize - 1) / blockSize * blockSize
+		return sfs.persist()
+	}
+
+	var loaded bool
+	buf := make([]byte, manifestSize)
+	for i := int64(0); i < 2; i++ {
+		if _, err := sfs.file.ReadAt(buf, i*manifestSize); err != nil && err != io.EOF {
+			return err
+		}
+		seq, ok := decodeManifestSeq(buf)
+		if !ok || loaded && seq <= sfs.seq {
+			continue
+		}
+		if err := sfs.decode(buf); err != nil {
+			return err
+		}
+		loaded = true
+	}
+	if !loaded {
+		return errors.New("the manifest is corrupted")
+	}
+	if sfs.options.SegmentSize > sfs.slotSize-footerHeaderSize-footerTrailerSize-2*blockSize {
+		return fmt.Errorf("the segment size %d is too large for the slot size %d", sfs.options.SegmentSize, sfs.slotSize)
+	}
+
+	for _, entry := range sfs.entries {
+		if err := sfs.recoverSize(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeManifestSeq returns the seq of the manifest, and false if the manifest is invalid.
+func decodeManifestSeq(buf []byte) (uint64, bool) {
+	if string(buf[:8]) != singleFileMagic {
+		return 0, false
+	}
+	n := int(binary.LittleEndian.Uint32(buf[24:28]))
+	offset := manifestHeaderSize
+	for i := 0; i < n; i++ {
+		if offset+manifestRecordSize > len(buf)-4 {
+			return 0, false
+		}
+		offset += manifestRecordSize + int(binary.LittleEndian.Uint16(buf[offset+12:offset+14]))
+	}
+	if offset+4 > len(buf) || crc32.Checksum(buf[:offset], crcTable) != binary.LittleEndian.Uint32(buf[offset:]) {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(buf[8:16]), true
+}
+
+// decode decodes the manifest validated by decodeManifestSeq.
+func (sfs *singleFileSystem) decode(buf []byte) error {
+	sfs.seq = binary.LittleEndian.Uint64(buf[8:16])
+	sfs.slotSize = int64(binary.LittleEndian.Uint64(buf[16:24]))
+	sfs.entries = make(map[string]*singleFileEntry)
+	sfs.slots = sfs.slots[:0]
+	sfs.dirty = make(map[uint32]int64)
+
+	n := int(binary.LittleEndian.Uint32(buf[24:28]))
+	offset := manifestHeaderSize
+	for i := 0; i < n; i++ {
+		slot := binary.LittleEndian.Uint32(buf[offset : offset+4])
+		size := int64(binary.LittleEndian.Uint64(buf[offset+4 : offset+12]))
+		nameLen := int(binary.LittleEndian.Uint16(buf[offset+12 : offset+14]))
+		name := string(buf[offset+manifestRecordSize : offset+manifestRecordSize+nameLen])
+		offset += manifestRecordSize + nameLen
+
+		for uint32(len(sfs.slots)) <= slot {
+			sfs.slots = append(sfs.slots, nil)
+		}
+		if name == "" {
+			sfs.dirty[slot] = size
+			continue
+		}
+		if sfs.slots[slot] != nil || sfs.entries[name] != nil {
+			return fmt.Errorf("the slot %d or the name %s is duplicated in the manifest", slot, name)
+		}
+		entry := &singleFileEntry{name: name, slot: slot}
+		entry.size.Store(size)
+		sfs.entries[name] = entry
+		sfs.slots[slot] = entry
+	}
+	return nil
+}
+
+// persist writes the manifest to the older copy and syncs it.
+// It must be called with sfs.mu held, except when loading.
+func (sfs *singleFileSystem) persist() error {
+	buf := make([]byte, manifestHeaderSize, manifestSize)
+	copy(buf, singleFileMagic)
+	binary.LittleEndian.PutUint64(buf[8:16], sfs.seq+1)
+	binary.LittleEndian.PutUint64(buf[16:24], uint64(sfs.slotSize))
+
+	var n uint32
+	record := make([]byte, manifestRecordSize)
+	appendRecord := func(slot uint32, size int64, name string) {
+		binary.LittleEndian.PutUint32(record[:4], slot)
+		binary.LittleEndian.PutUint64(record[4:12], uint64(size))
+		binary.LittleEndian.PutUint16(record[12:14], uint16(len(name)))
+		buf = append(append(buf, record...), name...)
+		n++
+	}
+	sfs.changed.Store(false)
+	for slot, entry := range sfs.slots {
+		if entry != nil {
+			appendRecord(uint32(slot), entry.size.Load(), entry.name)
+		} else if size := sfs.dirty[uint32(slot)]; size > 0 {
+			appendRecord(uint32(slot), size, "")
+		}
+	}
+	binary.LittleEndian.PutUint32(buf[24:28], n)
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.Checksum(buf, crcTable))
+	if len(buf) > manifestSize {
+		sfs.changed.Store(true)
+		return errors.New("too many segment files in the single file, the manifest is full")
+	}
+
+	// the data must be durable before the sizes covering it.
+	if err := sfs.file.Sync(); err != nil {
+		return err
+	}
+	if _, err := sfs.writeAt(buf, int64((sfs.seq+1)%2)*manifestSize); err != nil {
+		return err
+	}
+	if err := sfs.file.Sync(); err != nil {
+		return err
+	}
+	sfs.seq++
+	return nil
+}
+
+// recoverSize scans the slot of the segment file from the persisted size,
+// and extends the size to the end of the last valid chunk.
+func (sfs *singleFileSystem) recoverSize(entry *singleFileEntry) error {
+	info, err := sfs.file.Stat()
+	if err != nil {
+		return err
+	}
+	// the single file may end in the slot.
+	scanSize := min(sfs.slotSize, info.Size()-sfs.slotOffset(entry.slot))
+	size := entry.size.Load()
+	if scanSize <= size {
+		return nil
+	}
+	section := io.NewSectionReader(sfs.file, sfs.slotOffset(entry.slot), scanSize)
+	seg := openReaderAtSegment(0, entry.name, section, scanSize, sfs.options)
+	reader := seg.NewReader()
+	reader.blockNumber = uint32(size / blockSize)
+	reader.chunkOffset = size % blockSize
+	err = reader.scan(nil)
+	if _, ok := err.(*CorruptionError); err != nil && !ok {
+		return err
+	}
+	if end := int64(reader.blockNumber)*blockSize + reader.chunkOffset; end > size {
+		entry.size.Store(end)
+		sfs.changed.Store(true)
+	}
+	return nil
+}
+
+// slotOffset returns the offset of the slot in the single file.
+func (sfs *singleFileSystem) slotOffset(slot uint32) int64 {
+	return singleFileDataOffset + int64(slot)*sfs.slotSize
+}
+
+// writeAt writes the data at the offset of the single file, File has no WriteAt.
+func (sfs *singleFileSystem) writeAt(p []byte, offset int64) (int, error) {
+	sfs.writeMu.Lock()
+	defer sfs.writeMu.Unlock()
+	if _, err := sfs.file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return sfs.file.Write(p)
+}
+
+// zero zeroes the data of the slot in [offset, offset+size).
+func (sfs *singleFileSystem) zero(slot uint32, offset, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	offset += sfs.slotOffset(slot)
+	if punchHole(sfs.file, offset, size) {
+		return nil
+	}
+	zeros := make([]byte, blockSize)
+	for size > 0 {
+		n := min(size, int64(len(zeros)))
+		if _, err := sfs.writeAt(zeros[:n], offset); err != nil {
+			return err
+		}
+		offset += n
+		size -= n
+	}
+	return nil
+}
+
+// segmentName returns the name of the segment file in the single file,
+// and false if the path is not a segment file in DirPath.
+func (sfs *singleFileSystem) segmentName(path string) (string, bool) {
+	dir, name := filepath.Split(path)
+	if filepath.Clean(dir) != sfs.dirPath {
+		return "", false
+	}
+	_, ok := parseSegmentID(sfs.options, strings.TrimSuffix(name, tempSegmentExt))
+	return name, ok
+}
+
+func (sfs *singleFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	segName, ok := sfs.segmentName(name)
+	if !ok {
+		return sfs.FileSystem.OpenFile(name, flag, perm)
+	}
+
+	sfs.mu.Lock()
+	defer sfs.mu.Unlock()
+	entry := sfs.entries[segName]
+	if entry == nil {
+		if flag&os.O_CREATE == 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		var err error
+		if entry, err = sfs.create(segName); err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+	} else if flag&os.O_TRUNC != 0 {
+		if err := sfs.truncate(entry, 0); err != nil {
+			return nil, err
+		}
+	}
+	return &singleFileSegment{fs: sfs, entry: entry, name: name, append: flag&os.O_APPEND != 0}, nil
+}
+
+// create allocates the lowest free slot for the segment file,
+// the data left in the slot is zeroed first. It must be called with sfs.mu held.
+func (sfs *singleFileSystem) create(name string) (*singleFileEntry, error) {
+	slot := uint32(len(sfs.slots))
+	for i, e := range sfs.slots {
+		if e == nil {
+			slot = uint32(i)
+			break
+		}
+	}
+	if err := sfs.zero(slot, 0, sfs.dirty[slot]); err != nil {
+		return nil, err
+	}
+	entry := &singleFileEntry{name: name, slot: slot}
+	if slot == uint32(len(sfs.slots)) {
+		sfs.slots = append(sfs.slots, nil)
+	}
+	sfs.slots[slot] = entry
+	sfs.entries[name] = entry
+	dirty := sfs.dirty[slot]
+	delete(sfs.dirty, slot)
+	if err := sfs.persist(); err != nil {
+		sfs.slots[slot] = nil
+		delete(sfs.entries, name)
+		sfs.dirty[slot] = dirty
+		return nil, err
+	}
+	return entry, nil
+}
+
+// truncate changes the size of the segment file, the truncated data is zeroed.
+func (sfs *singleFileSystem) truncate(entry *singleFileEntry, size int64) error {
+	if size < 0 || size > sfs.slotSize {
+		return &os.PathError{Op: "truncate", Path: entry.name, Err: os.ErrInvalid}
+	}
+	if old := entry.size.Load(); size < old {
+		if err := sfs.zero(entry.slot, size, old-size); err != nil {
+			return err
+		}
+	}
+	entry.size.Store(size)
+	sfs.changed.Store(true)
+	return nil
+}
+
+func (sfs *singleFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	entries, err := sfs.FileSystem.ReadDir(name)
+	if err != nil || filepath.Clean(name) != sfs.dirPath {
+		return entries, err
+	}
+	// the segment files out of the single file are ignored.
+	var result []os.DirEntry
+	for _, entry := range entries {
+		if entry.Name() == singleFileName {
+			continue
+		}
+		if _, ok := sfs.segmentName(filepath.Join(name, entry.Name())); !ok || entry.IsDir() {
+			result = append(result, entry)
+		}
+	}
+	sfs.mu.Lock()
+	for _, entry := range sfs.entries {
+		result = append(result, fs.FileInfoToDirEntry(sfs.stat(entry)))
+	}
+	sfs.mu.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name() < result[j].Name()
+	})
+	return result, nil
+}
+
+func (sfs *singleFileSystem) Stat(name string) (os.FileInfo, error) {
+	segName, ok := sfs.segmentName(name)
+	if !ok {
+		return sfs.FileSystem.Stat(name)
+	}
+	sfs.mu.Lock()
+	defer sfs.mu.Unlock()
+	entry := sfs.entries[segName]
+	if entry == nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return sfs.stat(entry), nil
+}
+
+// stat returns the os.FileInfo of the segment file.
+func (sfs *singleFileSystem) stat(entry *singleFileEntry) os.FileInfo {
+	return &memFileInfo{name: entry.name, size: entry.size.Load(), mode: sfs.options.FileMode, modTime: time.Time{}}
+}
+
+func (sfs *singleFileSystem) Remove(name string) error {
+	segName, ok := sfs.segmentName(name)
+	if !ok {
+		return sfs.FileSystem.Remove(name)
+	}
+	sfs.mu.Lock()
+	defer sfs.mu.Unlock()
+	entry := sfs.entries[segName]
+	if entry == nil {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+
+	// the slot is recorded as dirty until it is zeroed.
+	entry.removed.Store(true)
+	delete(sfs.entries, segName)
+	sfs.slots[entry.slot] = nil
+	sfs.dirty[entry.slot] = entry.size.Load()
+	if err := sfs.persist(); err != nil {
+		return err
+	}
+	if err := sfs.zero(entry.slot, 0, entry.size.Load()); err != nil {
+		return err
+	}
+	delete(sfs.dirty, entry.slot)
+	return nil
+}
+
+func (sfs *singleFileSystem) Rename(oldpath, newpath string) error {
+	oldName, ok := sfs.segmentName(oldpath)
+	if !ok {
+		return sfs.FileSystem.Rename(oldpath, newpath)
+	}
+	newName, ok := sfs.segmentName(newpath)
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrInvalid}
+	}
+	sfs.mu.Lock()
+	defer sfs.mu.Unlock()
+	entry := sfs.entries[oldName]
+	if entry == nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	if sfs.entries[newName] != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrExist}
+	}
+	delete(sfs.entries, oldName)
+	entry.name = newName
+	sfs.entries[newName] = entry
+	return sfs.persist()
+}
+
+// sync syncs the single file, and persists the sizes if they are changed.
+func (sfs *singleFileSystem) sync() error {
+	if !sfs.changed.Load() {
+		return sfs.file.Sync()
+	}
+	sfs.mu.Lock()
+	defer sfs.mu.Unlock()
+	return sfs.persist()
+}
+
+// close persists the sizes and closes the single file.
+func (sfs *singleFileSystem) close() error {
+	err := sfs.sync()
+	if closeErr := sfs.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// remove closes and removes the single file.
+func (sfs *singleFileSystem) remove() error {
+	_ = sfs.file.Close()
+	return sfs.FileSystem.Remove(filepath.Join(sfs.dirPath, singleFileName))
+}
+
+func (f *singleFileSegment) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *singleFileSegment) ReadAt(p []byte, off int64) (int, error) {
+	if f.entry.removed.Load() {
+		return 0, os.ErrClosed
+	}
+	size := f.entry.size.Load()
+	if off >= size {
+		return 0, io.EOF
+	}
+	base := f.fs.slotOffset(f.entry.slot)
+	if off+int64(len(p)) > size {
+		n, err := f.fs.file.ReadAt(p[:size-off], base+off)
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+	return f.fs.file.ReadAt(p, base+off)
+}
+
+func (f *singleFileSegment) Write(p []byte) (int, error) {
+	if f.entry.removed.Load() {
+		return 0, os.ErrClosed
+	}
+	off := f.offset
+	if f.append {
+		off = f.entry.size.Load()
+	}
+	if off+int64(len(p)) > f.fs.slotSize {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: errors.New("the slot of the single file is full")}
+	}
+	n, err := f.fs.writeAt(p, f.fs.slotOffset(f.entry.slot)+off)
+	f.offset = off + int64(n)
+	if f.offset > f.entry.size.Load() {
+		f.entry.size.Store(f.offset)
+		f.fs.changed.Store(true)
+	}
+	return n, err
+}
+
+func (f *singleFileSegment) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.entry.size.Load()
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *singleFileSegment) Close() error {
+	return nil
+}
+
+func (f *singleFileSegment) Name() string {
+	return f.name
+}
+
+func (f *singleFileSegment) Stat() (os.FileInfo, error) {
+	return f.fs.stat(f.entry), nil
+}
+
+func (f *singleFileSegment) Sync() error {
+	return f.fs.sync()
+}
+
+func (f *singleFileSegment) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return f.fs.truncate(f.entry, size)
+}
@@ -0,0 +1,101 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWAL_SingleFile(t *testing.T) {
+	t.Run("os", func(t *testing.T) {
+		testSingleFile(t, OSFileSystem)
+	})
+	// the memory file system can't punch holes, the removed segment files are zeroed by writes.
+	t.Run("memory", func(t *testing.T) {
+		testSingleFile(t, NewMemoryFileSystem())
+	})
+}
+
+func testSingleFile(t *testing.T, fs FileSystem) {
+	dir, _ := os.MkdirTemp("", "wal-test-single-file")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    64 * KB,
+		MaxSegments:    3,
+		SingleFile:     true,
+		FileSystem:     fs,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+
+	value := func(i int) string {
+		return fmt.Sprintf("entry-%d-%0200d", i, i)
+	}
+	var positions []*ChunkPosition
+	for i := 0; i < 1000; i++ {
+		pos, err := wal.Write([]byte(value(i)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.True(t, wal.ActiveSegmentID() > 3)
+
+	// all the segment files are in one file.
+	entries, err := fs.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, singleFileName, entries[0].Name())
+	// the slots of the removed segment files are reused, the new active one is created before the eviction.
+	info, err := fs.Stat(filepath.Join(dir, singleFileName))
+	assert.Nil(t, err)
+	assert.True(t, info.Size() <= singleFileDataOffset+int64(opts.MaxSegments+1)*wal.singleFile.slotSize)
+
+	check := func(wal *WAL) {
+		first := wal.firstSegmentID()
+		var start int
+		for i, pos := range positions {
+			if pos.SegmentId < first {
+				start = i + 1
+				continue
+			}
+			val, err := wal.Read(pos)
+			assert.Nil(t, err)
+			assert.Equal(t, value(i), string(val))
+		}
+		reader := wal.NewReader()
+		for i := start; i < len(positions); i++ {
+			val, _, err := reader.Next()
+			assert.Nil(t, err)
+			assert.Equal(t, value(i), string(val))
+		}
+		_, _, err := reader.Next()
+		assert.Equal(t, io.EOF, err)
+	}
+	check(wal)
+
+	// the data written after the last sync is recovered when the process crashes,
+	// which is simulated by opening the single file again without closing the WAL.
+	crashed, err := Open(opts)
+	assert.Nil(t, err)
+	check(crashed)
+	assert.Nil(t, crashed.Close())
+
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	check(wal)
+
+	_, err = Open(Options{DirPath: dir, SegmentFileExt: ".SEG", SegmentSize: MB, SingleFile: true, FileSystem: fs})
+	assert.NotNil(t, err)
+
+	assert.Nil(t, wal.Delete())
+	_, err = fs.Stat(filepath.Join(dir, singleFileName))
+	assert.True(t, os.IsNotExist(err))
+}
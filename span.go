@@ -0,0 +1,140 @@
+package wal
+
+import (
+	"errors"
+	"io"
+)
+
+// The kinds of the fragments of an entry spanning segment files, see Options.AllowSegmentSpanning.
+// The first fragment is at the end of a segment file, and each of the others is
+// the first entry of the next segment file, the last one ends the entry.
+const (
+	spanFirst byte = iota + 1
+	spanMiddle
+	spanLast
+)
+
+// minSpanFragmentSize is the min data size of the first fragment,
+// a new segment file is opened for the entry if the active one can not hold it,
+// which makes sure the prefix of the entry is in the first fragment.
+const minSpanFragmentSize = 1 * KB
+
+var ErrIncompleteSpan = errors.New("the entry spanning segment files is incomplete, some fragments are missing")
+
+// writeSpan writes the data larger than a segment file as the fragments in
+// the active segment file and the new ones, and returns the position of the first fragment.
+// It must be called with the WAL lock held.
+func (wal *WAL) writeSpan(data []byte, flags byte) (*ChunkPosition, error) {
+	var first *ChunkPosition
+	var stats WriteStats
+	kind := spanFirst
+	for len(data) > 0 {
+		// the max fragment size the active segment file can hold, including the kind byte.
+		maxSize := wal.maxFragmentSize(wal.segmentCapacity() - wal.activeSegment.Size())
+		if kind == spanFirst && maxSize < minSpanFragmentSize {
+			maxSize = wal.maxFragmentSize(wal.segmentCapacity())
+		} else if kind != spanFirst {
+			// the other fragments start a new segment file.
+			maxSize = wal.maxFragmentSize(wal.segmentCapacity())
+		}
+
+		size := int(maxSize) - 1
+		if size >= len(data) {
+			size = len(data)
+			if kind != spanFirst {
+				kind = spanLast
+			}
+		}
+		fragment := make([]byte, 1+size)
+		fragment[0] = kind
+		copy(fragment[1:], data[:size])
+		data = data[size:]
+
+		pos, err := wal.writeLocked(fragment, flags|entryFlagSpan)
+		if err != nil {
+			return nil, err
+		}
+		if first == nil {
+			first = pos
+		}
+		stats.add(wal.lastWrite)
+		if kind == spanFirst {
+			kind = spanMiddle
+		}
+		if len(data) > 0 && wal.activeSegment.Size() > 0 {
+			// the next fragment must be the first entry of a new segment file.
+			if err := wal.rotateActiveSegment(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	stats.Entries = 1
+	wal.lastWrite = stats
+	return first, nil
+}
+
+// maxFragmentSize returns the max size of the data the segment file
+// can hold in the left space, that is, maxDataWriteSize of it is not larger than left.
+func (wal *WAL) maxFragmentSize(left int64) int64 {
+	size := left - 2*chunkHeaderSize - (left/blockSize+1)*chunkHeaderSize
+	for size > 0 && wal.maxDataWriteSize(size) > left {
+		size--
+	}
+	if size < 0 {
+		return 0
+	}
+	return size
+}
+
+// readSpan reads the other fragments of the entry spanning segment files
+// whose first fragment is entry, and returns the whole entry decoded.
+// The segment files are found by findSegment, and ErrIncompleteSpan is returned
+// if any fragment is missing.
+func readSpan(entry chunkEntry, findSegment func(id SegmentID) *segment, opts readOptions) (chunkEntry, error) {
+	if len(entry.data) == 0 || entry.data[0] != spanFirst {
+		return chunkEntry{}, ErrIncompleteSpan
+	}
+	data, length := entry.data[1:], entry.length-1
+	for id := entry.next.SegmentId + 1; ; id++ {
+		seg := findSegment(id)
+		if seg == nil {
+			return chunkEntry{}, ErrIncompleteSpan
+		}
+		fragment, err := seg.readInternal(0, 0, opts)
+		if err == io.EOF {
+			return chunkEntry{}, ErrIncompleteSpan
+		}
+		if err != nil {
+			return chunkEntry{}, err
+		}
+		if fragment.flags&entryFlagSpan == 0 || len(fragment.data) == 0 || fragment.data[0] == spanFirst {
+			return chunkEntry{}, ErrIncompleteSpan
+		}
+		if !opts.skipData {
+			data = append(data, fragment.data[1:]...)
+		}
+		length += fragment.length - 1
+		if fragment.data[0] == spanLast {
+			break
+		}
+	}
+
+	entry.flags &^= entryFlagSpan
+	entry.data, entry.length = data, length
+	if err := entry.decodePrefix(); err != nil {
+		return chunkEntry{}, err
+	}
+	if err := entry.decompress(opts.skipData); err != nil {
+		return chunkEntry{}, err
+	}
+	if opts.skipData {
+		entry.data = nil
+	}
+	return entry, nil
+}
+
+// isSpanContinuation reports whether the entry is a fragment following
+// the first one of an entry spanning segment files.
+func (e *chunkEntry) isSpanContinuation() bool {
+	return e.flags&entryFlagSpan != 0 && (len(e.data) == 0 || e.data[0] != spanFirst)
+}
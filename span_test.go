@@ -0,0 +1,133 @@
+package wal
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWAL_AllowSegmentSpanning(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-segment-spanning")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    64 * KB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() { destroyWAL(wal) }()
+
+	giant := make([]byte, 200*KB)
+	rand.New(rand.NewSource(1)).Read(giant)
+	_, err = wal.Write(giant)
+	assert.Equal(t, ErrValueTooLarge, err)
+	assert.Nil(t, wal.Close())
+
+	opts.AllowSegmentSpanning = true
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+
+	values := [][]byte{[]byte("before"), giant, []byte("between"), giant[:70*KB], []byte("after")}
+	var positions []*ChunkPosition
+	for _, value := range values[:4] {
+		pos, err := wal.Write(value)
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	now := time.Now()
+	pos, err := wal.WriteWithTime(values[4], now)
+	assert.Nil(t, err)
+	positions = append(positions, pos)
+	assert.True(t, wal.ActiveSegmentID() > 4)
+
+	check := func() {
+		for i, pos := range positions {
+			val, err := wal.Read(pos)
+			assert.Nil(t, err)
+			assert.True(t, bytes.Equal(values[i], val))
+		}
+		results, err := wal.ReadBatch(positions)
+		assert.Nil(t, err)
+		assert.Equal(t, values, results)
+
+		reader := wal.NewReader()
+		for i := range values {
+			val, pos, err := reader.Next()
+			assert.Nil(t, err)
+			assert.True(t, bytes.Equal(values[i], val))
+			assert.Equal(t, positions[i].SegmentId, pos.SegmentId)
+		}
+		assert.Equal(t, now.UnixNano(), reader.Timestamp().UnixNano())
+		_, _, err = reader.Next()
+		assert.Equal(t, io.EOF, err)
+
+		reader = wal.NewReader()
+		for i := range values {
+			_, length, err := reader.NextPosition()
+			assert.Nil(t, err)
+			assert.Equal(t, len(values[i]), length)
+		}
+
+		reverse := wal.NewReverseReader()
+		for i := len(values) - 1; i >= 0; i-- {
+			val, _, err := reverse.Next()
+			assert.Nil(t, err)
+			assert.True(t, bytes.Equal(values[i], val))
+		}
+		_, _, err = reverse.Next()
+		assert.Equal(t, io.EOF, err)
+
+		n, err := wal.Len()
+		assert.Nil(t, err)
+		assert.Equal(t, len(values), n)
+	}
+	check()
+
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	check()
+}
+
+func TestWAL_AllowSegmentSpanning_Incomplete(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-segment-spanning-incomplete")
+	opts := Options{
+		DirPath:              dir,
+		SegmentFileExt:       ".SEG",
+		SegmentSize:          64 * KB,
+		AllowSegmentSpanning: true,
+		Compression:          CompressionSnappy,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() { destroyWAL(wal) }()
+
+	giant := make([]byte, 300*KB)
+	rand.New(rand.NewSource(1)).Read(giant)
+	pos, err := wal.Write(giant)
+	assert.Nil(t, err)
+	_, err = wal.Write([]byte("after"))
+	assert.Nil(t, err)
+	val, err := wal.Read(pos)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(giant, val))
+	assert.Nil(t, wal.Close())
+
+	// remove a middle fragment.
+	assert.Nil(t, os.Remove(SegmentFileName(dir, ".SEG", pos.SegmentId+2)))
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = wal.Read(pos)
+	assert.Equal(t, ErrIncompleteSpan, err)
+
+	// the incomplete entry is skipped by the reader.
+	reader := wal.NewReader()
+	val, _, err = reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "after", string(val))
+}
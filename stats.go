@@ -0,0 +1,124 @@
+package wal
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// syncLatencyBounds are the upper bounds of the sync latency buckets.
+var syncLatencyBounds = [...]time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// Stats represents the statistics of a WAL since it was opened.
+type Stats struct {
+	// WriteCount is the number of entries written to the WAL.
+	WriteCount uint64
+	// BytesWritten is the number of bytes written to the segment files,
+	// including the chunk headers, the block paddings and the segment footers.
+	BytesWritten uint64
+	// SyncCount is the number of syncs of the active segment file.
+	SyncCount uint64
+	// SyncDuration is the total time spent on syncing the active segment file.
+	SyncDuration time.Duration
+	// SyncLatencyBuckets is the number of syncs whose latency falls in each bucket,
+	// the upper bound of each bucket is the one at the same index of SyncLatencyBounds(),
+	// and the last extra bucket counts the syncs exceeding all the bounds.
+	SyncLatencyBuckets []uint64
+	// RotationCount is the number of times a new active segment file is created.
+	RotationCount uint64
+}
+
+// WriteStats represents the space taken up by a write in the segment files, see WAL.LastWriteStats.
+type WriteStats struct {
+	// Entries is the number of entries written, it is more than 1 for WriteAll.
+	Entries int
+	// DataBytes is the size of the data in the chunks, including the prefix such as the timestamp,
+	// it is the size after compression if the data is compressed.
+	DataBytes int64
+	// HeaderBytes is the size of the chunk headers, one for every block the entry occupies.
+	HeaderBytes int64
+	// PaddingBytes is the size of the zero paddings at the end of the blocks,
+	// which are added before an entry if the left space of the block can not hold a chunk header.
+	PaddingBytes int64
+}
+
+// newWriteStats returns the stats of the entries written at the positions,
+// which take up size bytes of the segment file including the paddings.
+func newWriteStats(positions []*ChunkPosition, size int64) WriteStats {
+	ws := WriteStats{Entries: len(positions), PaddingBytes: size}
+	for _, pos := range positions {
+		// the chunks of an entry fill the blocks one by one, and each chunk has a header.
+		start := int64(pos.BlockNumber)*blockSize + pos.ChunkOffset
+		end := start + int64(pos.ChunkSize)
+		chunks := (end-1)/blockSize - start/blockSize + 1
+		ws.HeaderBytes += chunks * chunkHeaderSize
+		ws.DataBytes += int64(pos.ChunkSize) - chunks*chunkHeaderSize
+		ws.PaddingBytes -= int64(pos.ChunkSize)
+	}
+	return ws
+}
+
+// add accumulates the stats of the fragments of an entry spanning segment files.
+func (ws *WriteStats) add(other WriteStats) {
+	ws.DataBytes += other.DataBytes
+	ws.HeaderBytes += other.HeaderBytes
+	ws.PaddingBytes += other.PaddingBytes
+}
+
+// SyncLatencyBounds returns the upper bounds of the buckets in Stats.SyncLatencyBuckets.
+func SyncLatencyBounds() []time.Duration {
+	return append([]time.Duration(nil), syncLatencyBounds[:]...)
+}
+
+// stats holds the counters of a WAL, all of them are updated atomically.
+type stats struct {
+	writeCount         atomic.Uint64
+	bytesWritten       atomic.Uint64
+	syncCount          atomic.Uint64
+	syncDuration       atomic.Int64
+	syncLatencyBuckets [len(syncLatencyBounds) + 1]atomic.Uint64
+	rotationCount      atomic.Uint64
+}
+
+func (s *stats) addWrites(count int, bytes int64) {
+	s.writeCount.Add(uint64(count))
+	s.bytesWritten.Add(uint64(bytes))
+}
+
+func (s *stats) addSync(d time.Duration) {
+	s.syncCount.Add(1)
+	s.syncDuration.Add(int64(d))
+	i := 0
+	for i < len(syncLatencyBounds) && d > syncLatencyBounds[i] {
+		i++
+	}
+	s.syncLatencyBuckets[i].Add(1)
+}
+
+func (s *stats) snapshot() Stats {
+	buckets := make([]uint64, len(s.syncLatencyBuckets))
+	for i := range s.syncLatencyBuckets {
+		buckets[i] = s.syncLatencyBuckets[i].Load()
+	}
+	return Stats{
+		WriteCount:         s.writeCount.Load(),
+		BytesWritten:       s.bytesWritten.Load(),
+		SyncCount:          s.syncCount.Load(),
+		SyncDuration:       time.Duration(s.syncDuration.Load()),
+		SyncLatencyBuckets: buckets,
+		RotationCount:      s.rotationCount.Load(),
+	}
+}
@@ -0,0 +1,83 @@
+package wal
+
+import "time"
+
+// SyncPolicy decides when the active segment file is synced by the WAL,
+// the segment file is always synced when it is sealed regardless of the policy.
+// The policies can be combined by Or, the segment file is synced if any of them says so.
+//
+// The zero value is SyncNever.
+type SyncPolicy struct {
+	always   bool
+	bytes    uint32
+	interval time.Duration
+}
+
+var (
+	// SyncNever never syncs the active segment file after the writes,
+	// it is synced only if Sync is called or it is sealed.
+	SyncNever = SyncPolicy{}
+	// SyncAlways syncs the active segment file after every Write,
+	// the same as Options.Sync being true.
+	SyncAlways = SyncPolicy{always: true}
+)
+
+// SyncEveryBytes syncs the active segment file after n bytes are written since the last sync,
+// the same as Options.BytesPerSync. It is SyncNever if n is zero.
+func SyncEveryBytes(n uint32) SyncPolicy {
+	return SyncPolicy{bytes: n}
+}
+
+// SyncEveryInterval syncs the active segment file periodically in the background every d,
+// the same as Options.SyncInterval. It is SyncNever if d is zero or negative.
+func SyncEveryInterval(d time.Duration) SyncPolicy {
+	if d < 0 {
+		d = 0
+	}
+	return SyncPolicy{interval: d}
+}
+
+// Or returns the policy syncing the active segment file whenever p or other does,
+// the smaller byte count and interval are taken if both have one.
+func (p SyncPolicy) Or(other SyncPolicy) SyncPolicy {
+	p.always = p.always || other.always
+	if other.bytes > 0 && (p.bytes == 0 || other.bytes < p.bytes) {
+		p.bytes = other.bytes
+	}
+	if other.interval > 0 && (p.interval == 0 || other.interval < p.interval) {
+		p.interval = other.interval
+	}
+	return p
+}
+
+// Always returns whether the active segment file is synced after every Write.
+func (p SyncPolicy) Always() bool {
+	return p.always
+}
+
+// Bytes returns the number of bytes written before the active segment file is synced,
+// zero if it is not synced by the bytes written.
+func (p SyncPolicy) Bytes() uint32 {
+	return p.bytes
+}
+
+// Interval returns the interval of the periodic sync, zero if there is no periodic sync.
+func (p SyncPolicy) Interval() time.Duration {
+	return p.interval
+}
+
+// shouldSync reports whether the active segment file should be synced after a Write,
+// bytesWritten is the number of bytes written since the last sync.
+func (p SyncPolicy) shouldSync(bytesWritten uint32) bool {
+	return p.always || (p.bytes > 0 && bytesWritten >= p.bytes)
+}
+
+// syncPolicy returns the sync policy of the options,
+// that is SyncPolicy combined with the legacy Sync, BytesPerSync and SyncInterval.
+func (o *Options) syncPolicy() SyncPolicy {
+	policy := o.SyncPolicy.Or(SyncEveryBytes(o.BytesPerSync)).Or(SyncEveryInterval(o.SyncInterval))
+	if o.Sync {
+		policy = policy.Or(SyncAlways)
+	}
+	return policy
+}
@@ -0,0 +1,228 @@
+package wal
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncPolicy(t *testing.T) {
+	assert.False(t, SyncNever.shouldSync(1<<31))
+	assert.True(t, SyncAlways.shouldSync(0))
+	assert.False(t, SyncEveryBytes(100).shouldSync(99))
+	assert.True(t, SyncEveryBytes(100).shouldSync(100))
+	assert.Equal(t, time.Duration(0), SyncEveryInterval(-time.Second).Interval())
+
+	policy := SyncEveryBytes(100).Or(SyncEveryBytes(50)).Or(SyncEveryInterval(time.Second))
+	assert.False(t, policy.Always())
+	assert.Equal(t, uint32(50), policy.Bytes())
+	assert.Equal(t, time.Second, policy.Interval())
+	assert.True(t, policy.Or(SyncAlways).Always())
+
+	// the legacy options are combined.
+	opts := Options{SyncPolicy: SyncEveryBytes(100), BytesPerSync: 10, SyncInterval: time.Minute}
+	assert.Equal(t, SyncPolicy{bytes: 10, interval: time.Minute}, opts.syncPolicy())
+	opts = Options{Sync: true}
+	assert.True(t, opts.syncPolicy().Always())
+	assert.Equal(t, SyncNever, (&Options{}).syncPolicy())
+}
+
+func TestWAL_SyncPolicy(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-sync-policy")
+	opts := DefaultOptions
+	opts.DirPath = dir
+	opts.SyncPolicy = SyncEveryBytes(1024)
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	for i := 0; i < 10; i++ {
+		_, err := wal.Write(make([]byte, 100))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, uint64(1), wal.Stats().SyncCount)
+
+	_, err = wal.Write(make([]byte, 1024))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), wal.Stats().SyncCount)
+}
+
+func TestWAL_SetSync(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-set-sync")
+	opts := DefaultOptions
+	opts.DirPath = dir
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	_, err = wal.Write([]byte("bulk"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), wal.Stats().SyncCount)
+
+	// synced immediately once enabled.
+	assert.Nil(t, wal.SetSync(true))
+	assert.Equal(t, uint64(1), wal.Stats().SyncCount)
+	_, err = wal.Write([]byte("steady"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), wal.Stats().SyncCount)
+
+	assert.Nil(t, wal.SetSync(false))
+	_, err = wal.Write(make([]byte, 100))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), wal.Stats().SyncCount)
+
+	// the written bytes already reach the new value.
+	assert.Nil(t, wal.SetBytesPerSync(200))
+	assert.Equal(t, uint64(2), wal.Stats().SyncCount)
+	assert.Nil(t, wal.SetBytesPerSync(50))
+	assert.Equal(t, uint64(3), wal.Stats().SyncCount)
+	_, err = wal.Write(make([]byte, 100))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), wal.Stats().SyncCount)
+
+	assert.Nil(t, wal.SetBytesPerSync(0))
+	_, err = wal.Write(make([]byte, 100))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), wal.Stats().SyncCount)
+}
+
+func TestWAL_SetSync_SyncPolicy(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-set-sync-policy")
+	opts := DefaultOptions
+	opts.DirPath = dir
+	opts.SyncPolicy = SyncAlways
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+
+	// the changes overridden by the sync policy are rejected.
+	assert.Equal(t, ErrSyncPolicyOverride, wal.SetSync(false))
+	assert.Equal(t, ErrSyncPolicyOverride, wal.SetBytesPerSync(100))
+	_, err = wal.Write([]byte("steady"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), wal.Stats().SyncCount)
+	assert.Nil(t, wal.SetSync(true))
+	assert.Nil(t, wal.Close())
+
+	opts.SyncPolicy = SyncEveryBytes(100)
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrSyncPolicyOverride, wal.SetBytesPerSync(0))
+	assert.Equal(t, ErrSyncPolicyOverride, wal.SetBytesPerSync(200))
+	assert.Nil(t, wal.SetBytesPerSync(50))
+	assert.Nil(t, wal.SetSync(false))
+}
+
+// syncFlagFileSystem wraps the OSFileSystem, records the flags of the files opened for writes
+// and counts the fsync calls, which fail if failSync is true.
+type syncFlagFileSystem struct {
+	osFileSystem
+	flags    atomic.Int64
+	syncs    atomic.Int32
+	failSync atomic.Bool
+}
+
+type syncFlagFile struct {
+	File
+	fs *syncFlagFileSystem
+}
+
+func (fs *syncFlagFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&os.O_RDWR != 0 {
+		fs.flags.Store(int64(flag))
+	}
+	fd, err := fs.osFileSystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &syncFlagFile{File: fd, fs: fs}, nil
+}
+
+func (f *syncFlagFile) Sync() error {
+	f.fs.syncs.Add(1)
+	if f.fs.failSync.Load() {
+		return errNoSpace
+	}
+	return f.File.Sync()
+}
+
+func TestWAL_OpenSyncFlag(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-open-sync-flag")
+	fs := &syncFlagFileSystem{}
+	opts := Options{
+		DirPath:         dir,
+		SegmentFileExt:  ".SEG",
+		SegmentSize:     MB,
+		Sync:            true,
+		WriteBufferSize: 4 * KB,
+		FileSystem:      fs,
+		OpenSyncFlag:    os.O_SYNC,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	// the write fd of the segment file is opened with the flag.
+	assert.NotZero(t, fs.flags.Load()&int64(os.O_SYNC))
+
+	// the fsync calls are skipped, but the buffered data is still flushed by them.
+	pos, err := wal.Write([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Nil(t, wal.Sync())
+	assert.Nil(t, wal.OpenNewActiveSegment())
+	assert.Equal(t, int32(0), fs.syncs.Load())
+	fd, err := os.Open(SegmentFileName(dir, ".SEG", pos.SegmentId))
+	assert.Nil(t, err)
+	info, err := fd.Stat()
+	assert.Nil(t, err)
+	assert.True(t, info.Size() > 0)
+	_ = fd.Close()
+
+	val, err := wal.Read(pos)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(val))
+}
+
+func TestWAL_OnSync(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-on-sync")
+	fs := &syncFlagFileSystem{}
+	var durations []time.Duration
+	var errs []error
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		SyncPolicy:     SyncAlways,
+		FileSystem:     fs,
+		OnSync: func(d time.Duration, err error) {
+			durations = append(durations, d)
+			errs = append(errs, err)
+		},
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	// every sync of the active segment file is observed, including the failed ones.
+	for i := 0; i < 3; i++ {
+		_, err = wal.Write([]byte("hello"))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, wal.Sync())
+	fs.failSync.Store(true)
+	_, err = wal.Write([]byte("hello"))
+	assert.ErrorIs(t, err, errNoSpace)
+	fs.failSync.Store(false)
+
+	assert.Equal(t, 5, len(durations))
+	assert.Equal(t, []error{nil, nil, nil, nil, errNoSpace}, errs)
+	for _, d := range durations {
+		assert.True(t, d > 0)
+	}
+	assert.Equal(t, uint64(4), wal.Stats().SyncCount)
+}
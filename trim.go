@@ -0,0 +1,149 @@
+package wal
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// trimFileName is the file persisting the position set by TrimFront.
+const trimFileName = "TRIM"
+
+// TrimFront removes all the entries before pos from the WAL, pos is kept.
+// The segment files whose ids are less than the segment id of pos are removed,
+// and the entries before pos in its segment file are hidden by a logical start position,
+// which is persisted in the TRIM file of the directory, so it survives reopening the WAL.
+// After that, reading the positions before pos returns ErrPositionTruncated,
+// and the readers and FirstPosition start from pos.
+// The start position only moves forward, ErrPositionTruncated is returned if pos is before it.
+//
+// The pos should be the start of an entry, such as the one returned by Write
+// or Reader.CurrentChunkPosition, otherwise the readers fail to read from it.
+func (wal *WAL) TrimFront(pos *ChunkPosition) error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	if wal.olderSegments == nil {
+		return ErrClosed
+	}
+	if wal.readOnly {
+		return ErrReadOnly
+	}
+	seg := wal.findSegment(pos.SegmentId)
+	if seg == nil || seg.id != wal.activeSegment.id && wal.olderSegments[seg.id] == nil {
+		if pos.SegmentId < wal.firstSegmentID() {
+			return fmt.Errorf("segment file %d%s: %w", pos.SegmentId, wal.options.SegmentFileExt, ErrPositionTruncated)
+		}
+		return fmt.Errorf("segment file %d%s: %w", pos.SegmentId, wal.options.SegmentFileExt, ErrSegmentNotFound)
+	}
+	// the start position never moves backwards, the trimmed entries stay trimmed.
+	if wal.trimStart != nil && (pos.SegmentId < wal.trimStart.SegmentId || wal.isTrimmed(pos)) {
+		return fmt.Errorf("position %d/%d of segment file %d%s is before the start position: %w",
+			pos.BlockNumber, pos.ChunkOffset, pos.SegmentId, wal.options.SegmentFileExt, ErrPositionTruncated)
+	}
+	if chunkOffset(pos) > seg.Size() {
+		return fmt.Errorf("the position %d/%d is beyond the segment file %d%s",
+			pos.BlockNumber, pos.ChunkOffset, pos.SegmentId, wal.options.SegmentFileExt)
+	}
+
+	// persist the start position before removing the segment files,
+	// so the entries before it are never visible again even if it fails partway.
+	start := &ChunkPosition{SegmentId: pos.SegmentId, BlockNumber: pos.BlockNumber, ChunkOffset: pos.ChunkOffset}
+	if err := writePositionFile(wal.options.FileSystem, wal.trimFilePath(), wal.options.FileMode, start); err != nil {
+		return err
+	}
+	wal.trimStart = start
+	for _, id := range wal.sortedOlderSegmentIDs() {
+		if id >= pos.SegmentId {
+			break
+		}
+		if err := wal.evictSegment(wal.olderSegments[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveSegment removes the sealed segment file with the id from the WAL and the disk,
+// like the retention does, the readers reading it still read it until they release it.
+// It returns ErrActiveSegment if it is the active segment file, and ErrSegmentNotFound
+// if there is no such segment file.
+//
+// Notice that removing a segment file in the middle leaves a gap in the segment ids,
+// so Open fails with ErrSegmentGap if Options.ValidateSegmentSequence is set,
+// and the entries spanning it are skipped by the readers.
+func (wal *WAL) RemoveSegment(id SegmentID) error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	if wal.olderSegments == nil {
+		return ErrClosed
+	}
+	if wal.readOnly {
+		return ErrReadOnly
+	}
+	if id == wal.activeSegment.id {
+		return fmt.Errorf("segment file %d%s: %w", id, wal.options.SegmentFileExt, ErrActiveSegment)
+	}
+	seg := wal.olderSegments[id]
+	if seg == nil {
+		return fmt.Errorf("segment file %d%s: %w", id, wal.options.SegmentFileExt, ErrSegmentNotFound)
+	}
+	return wal.evictSegment(seg)
+}
+
+// trimFilePath returns the path of the trim file.
+func (wal *WAL) trimFilePath() string {
+	return filepath.Join(wal.options.DirPath, trimFileName)
+}
+
+// loadTrimStart loads the position from the trim file, the trim file is
+// removed if the segment file of the position has been removed by retention.
+func (wal *WAL) loadTrimStart() error {
+	start, err := readPositionFile(wal.options.FileSystem, wal.trimFilePath())
+	if err != nil || start == nil {
+		return err
+	}
+	if start.SegmentId < wal.firstSegmentID() {
+		return removePositionFile(wal.options.FileSystem, wal.trimFilePath())
+	}
+	wal.trimStart = start
+	return nil
+}
+
+// isTrimmed reports whether the position is before the start position set by TrimFront.
+// It must be called with the WAL lock held.
+func (wal *WAL) isTrimmed(pos *ChunkPosition) bool {
+	return wal.isTrimmedSegment(pos.SegmentId) && chunkOffset(pos) < chunkOffset(wal.trimStart)
+}
+
+// trimmedError returns the error of reading the position trimmed by TrimFront.
+func (wal *WAL) trimmedError(pos *ChunkPosition) error {
+	return fmt.Errorf("position %d/%d of segment file %d%s is trimmed: %w",
+		pos.BlockNumber, pos.ChunkOffset, pos.SegmentId, wal.options.SegmentFileExt, ErrPositionTruncated)
+}
+
+// chunkOffset returns the offset of the position in the segment file.
+func chunkOffset(pos *ChunkPosition) int64 {
+	return int64(pos.BlockNumber)*blockSize + pos.ChunkOffset
+}
+
+// isTrimmedSegment reports whether the start position set by TrimFront is in the segment file.
+func (wal *WAL) isTrimmedSegment(id SegmentID) bool {
+	return wal.trimStart != nil && wal.trimStart.SegmentId == id
+}
+
+// newSegmentReader returns a reader of the segment file, which starts from
+// the start position set by TrimFront if it is in the segment file,
+// the number of entries of the segment file is recorded if it is known.
+// It must be called with the WAL lock held.
+func (wal *WAL) newSegmentReader(seg *segment) *segmentReader {
+	reader := seg.NewReader()
+	if wal.isTrimmedSegment(seg.id) {
+		reader.blockNumber = wal.trimStart.BlockNumber
+		reader.chunkOffset = wal.trimStart.ChunkOffset
+	} else if seg.index.complete {
+		reader.counted = true
+		reader.entries = int(seg.index.entries)
+	}
+	return reader
+}
@@ -0,0 +1,147 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWAL_TrimFront(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-trim-front")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    64 * KB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+
+	var positions []*ChunkPosition
+	for i := 0; i < 1000; i++ {
+		pos, err := wal.Write([]byte(fmt.Sprintf("entry-%d-%0200d", i, i)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.True(t, wal.ActiveSegmentID() > 2)
+
+	// trim in the middle of a segment file which is not the first one.
+	trimAt := 500
+	for positions[trimAt].SegmentId == 1 || positions[trimAt].SegmentId != positions[trimAt-1].SegmentId {
+		trimAt++
+	}
+	assert.Nil(t, wal.TrimFront(positions[trimAt]))
+
+	check := func(wal *WAL) {
+		first, err := wal.FirstPosition()
+		assert.Nil(t, err)
+		assert.Equal(t, positions[trimAt].SegmentId, first.SegmentId)
+		assert.Equal(t, positions[trimAt].BlockNumber, first.BlockNumber)
+		assert.Equal(t, positions[trimAt].ChunkOffset, first.ChunkOffset)
+
+		for i, pos := range positions {
+			val, err := wal.Read(pos)
+			if i < trimAt {
+				assert.True(t, errors.Is(err, ErrPositionTruncated))
+				continue
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, fmt.Sprintf("entry-%d-%0200d", i, i), string(val))
+		}
+
+		// the trimmed position in the segment file of the start is not read by ReadBatch either.
+		_, err = wal.ReadBatch([]*ChunkPosition{positions[trimAt], positions[trimAt-1]})
+		assert.True(t, errors.Is(err, ErrPositionTruncated))
+		values, err := wal.ReadBatch(positions[trimAt : trimAt+2])
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("entry-%d-%0200d", trimAt, trimAt), string(values[0]))
+
+		n, err := wal.Len()
+		assert.Nil(t, err)
+		assert.Equal(t, len(positions)-trimAt, n)
+
+		reader := wal.NewReader()
+		for i := trimAt; i < len(positions); i++ {
+			val, _, err := reader.Next()
+			assert.Nil(t, err)
+			assert.Equal(t, fmt.Sprintf("entry-%d-%0200d", i, i), string(val))
+		}
+		_, _, err = reader.Next()
+		assert.Equal(t, io.EOF, err)
+	}
+	check(wal)
+	_, err = os.Stat(wal.SegmentPath(positions[trimAt].SegmentId - 1))
+	assert.True(t, os.IsNotExist(err))
+
+	// the start position survives reopening the WAL.
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	check(wal)
+
+	// the trimmed positions can not be trimmed again.
+	err = wal.TrimFront(positions[0])
+	assert.True(t, errors.Is(err, ErrPositionTruncated))
+	err = wal.TrimFront(&ChunkPosition{SegmentId: wal.ActiveSegmentID() + 1})
+	assert.True(t, errors.Is(err, ErrSegmentNotFound))
+
+	// the start position never moves backwards in its segment file, even after reopening.
+	err = wal.TrimFront(positions[trimAt-1])
+	assert.True(t, errors.Is(err, ErrPositionTruncated))
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	check(wal)
+	assert.Nil(t, wal.TrimFront(positions[trimAt]))
+	check(wal)
+}
+
+func TestWAL_RemoveSegment(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-remove-segment")
+	var evicted []SegmentID
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		OnSegmentEvicted: func(segId SegmentID) {
+			evicted = append(evicted, segId)
+		},
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	for i := 1; i <= 3; i++ {
+		_, err := wal.Write([]byte(fmt.Sprintf("segment-%d", i)))
+		assert.Nil(t, err)
+		assert.Nil(t, wal.OpenNewActiveSegment())
+	}
+
+	assert.Nil(t, wal.RemoveSegment(2))
+	assert.Equal(t, []SegmentID{2}, evicted)
+	_, err = os.Stat(SegmentFileName(dir, ".SEG", 2))
+	assert.True(t, os.IsNotExist(err))
+
+	var values []string
+	reader := wal.NewReader()
+	for {
+		val, _, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		values = append(values, string(val))
+	}
+	assert.Equal(t, []string{"segment-1", "segment-3"}, values)
+
+	err = wal.RemoveSegment(2)
+	assert.True(t, errors.Is(err, ErrSegmentNotFound))
+	err = wal.RemoveSegment(wal.ActiveSegmentID())
+	assert.True(t, errors.Is(err, ErrActiveSegment))
+}
@@ -0,0 +1,101 @@
+package wal
+
+// A transaction is a batch of entries written by WAL.WriteUncommitted and ended by WAL.Commit,
+// which appends a commit marker as an internal entry, recording the position of
+// the first entry of the batch:
+//
+//	+----------+--------------------------------+
+//	| Kind(1B) | Start position(maxLen bytes)   |
+//	+----------+--------------------------------+
+//
+// The Reader holds the uncommitted entries until it reads the commit marker following them,
+// so the entries of a batch torn by a crash, whose commit marker is never written, are never returned.
+// A commit marker only commits the uncommitted entries at or after its start position,
+// the ones before it belong to a batch which has not been committed before the next one started.
+const internalKindCommit byte = 2
+
+// commitMarkerSize is the data size of a commit marker.
+const commitMarkerSize = 1 + maxLen
+
+// txnEntry is an uncommitted entry held by the Reader.
+type txnEntry struct {
+	entry    chunkEntry
+	position *ChunkPosition
+}
+
+// WriteUncommitted writes the data to the WAL like Write, but the data is not returned
+// by the readers until Commit is called, the entries written by WriteUncommitted since
+// the last Commit are committed together, so they are all returned or none of them.
+// The entries written by Write in the meantime are returned at once, so they are returned
+// before the uncommitted ones, which are returned in order after the commit marker is read.
+//
+// Only one batch can be written at a time, the uncommitted entries left by a crash
+// are never returned, even if a new batch is committed after reopening the WAL.
+// WAL.Read still reads the uncommitted entries by their positions, and the Reader holds
+// the uncommitted entries in memory until their commit marker is read.
+//
+// Notice that the uncommitted entries can not be read by the older versions of the WAL.
+func (wal *WAL) WriteUncommitted(data []byte) (*ChunkPosition, error) {
+	flags := entryFlagUncommitted | wal.options.Compression.entryFlags()
+	data = compress(data, flags)
+
+	wal.lock()
+	defer wal.unlock()
+	pos, err := wal.writeData(data, flags)
+	if err != nil {
+		return nil, err
+	}
+	if wal.txnStart == nil {
+		wal.txnStart = pos
+	}
+	return pos, nil
+}
+
+// Commit writes the commit marker of the entries written by WriteUncommitted since the last Commit,
+// the readers return them after reading the marker. It does nothing if there is no uncommitted entry.
+// The marker is synced according to the sync options like the other writes,
+// call Sync after Commit to make sure the batch survives a machine crash.
+func (wal *WAL) Commit() error {
+	wal.lock()
+	defer wal.unlock()
+
+	if wal.txnStart == nil {
+		return nil
+	}
+	data := make([]byte, 1, commitMarkerSize)
+	data[0] = internalKindCommit
+	data = append(data, wal.txnStart.EncodeFixedSize()...)
+	if _, err := wal.writeLocked(data, entryFlagInternal); err != nil {
+		return err
+	}
+	wal.txnStart = nil
+	return nil
+}
+
+// commitStart returns the start position recorded in the entry, and false if
+// the entry is not a commit marker.
+func (e *chunkEntry) commitStart() (*ChunkPosition, bool) {
+	if e.flags&entryFlagInternal == 0 || len(e.data) != commitMarkerSize || e.data[0] != internalKindCommit {
+		return nil, false
+	}
+	return DecodeChunkPosition(e.data[1:]), true
+}
+
+// commit moves the held uncommitted entries at or after the start position to
+// the committed ones, the others are discarded.
+func (r *Reader) commit(start *ChunkPosition) {
+	for _, e := range r.uncommitted {
+		if !e.position.before(start) {
+			r.committed = append(r.committed, e)
+		}
+	}
+	r.uncommitted = r.uncommitted[:0]
+}
+
+// before reports whether the position is before the other one in the WAL.
+func (cp *ChunkPosition) before(other *ChunkPosition) bool {
+	if cp.SegmentId != other.SegmentId {
+		return cp.SegmentId < other.SegmentId
+	}
+	return chunkOffset(cp) < chunkOffset(other)
+}
@@ -0,0 +1,87 @@
+package wal
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWAL_WriteUncommitted(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-write-uncommitted")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+
+	readAll := func(reader *Reader) []string {
+		var values []string
+		for {
+			val, _, err := reader.Next()
+			if err == io.EOF {
+				return values
+			}
+			assert.Nil(t, err)
+			values = append(values, string(val))
+		}
+	}
+
+	_, err = wal.Write([]byte("a"))
+	assert.Nil(t, err)
+	posB, err := wal.WriteUncommitted([]byte("b"))
+	assert.Nil(t, err)
+	_, err = wal.WriteUncommitted([]byte("c"))
+	assert.Nil(t, err)
+	_, err = wal.Write([]byte("d"))
+	assert.Nil(t, err)
+
+	// the uncommitted entries are not returned before the commit,
+	// but they can be read by the positions.
+	assert.Equal(t, []string{"a", "d"}, readAll(wal.NewReader()))
+	val, err := wal.Read(posB)
+	assert.Nil(t, err)
+	assert.Equal(t, "b", string(val))
+
+	// the reader returns the entries once it reads the commit marker.
+	reader := wal.NewReader()
+	assert.Equal(t, []string{"a", "d"}, readAll(reader))
+	assert.Nil(t, wal.Commit())
+	reader = wal.NewReader()
+	assert.Equal(t, []string{"a", "d", "b", "c"}, readAll(reader))
+	// nothing to commit.
+	assert.Nil(t, wal.Commit())
+
+	// the uncommitted entry left by a crash is never committed.
+	_, err = wal.WriteUncommitted([]byte("e"))
+	assert.Nil(t, err)
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = wal.WriteUncommitted([]byte("f"))
+	assert.Nil(t, err)
+	assert.Nil(t, wal.Commit())
+
+	assert.Equal(t, []string{"a", "d", "b", "c", "f"}, readAll(wal.NewReader()))
+	n, err := wal.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 6, n)
+
+	var values []string
+	reverse := wal.NewReverseReader()
+	for {
+		val, _, err := reverse.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		values = append(values, string(val))
+	}
+	assert.Equal(t, []string{"f", "d", "c", "b", "a"}, values)
+}
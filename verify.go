@@ -0,0 +1,191 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// CorruptionError describes a chunk which fails the verification of WAL.Verify.
+type CorruptionError struct {
+	// Position is where the corrupted entry starts.
+	Position *ChunkPosition
+	// Err is the reason of the corruption, such as ErrInvalidCRC.
+	Err error
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("corrupted chunk at segment %d, block %d, offset %d: %v",
+		e.Position.SegmentId, e.Position.BlockNumber, e.Position.ChunkOffset, e.Err)
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return e.Err
+}
+
+// Verify walks all the segment files and recomputes the checksum of every chunk,
+// it returns a *CorruptionError for the first corrupted chunk, or nil if the WAL is clean.
+//
+// If collectAll is true, it goes on with the next segment file after a corruption,
+// because the chunks after a corrupted one can not be located reliably,
+// and returns the first corruption of every segment file joined by errors.Join.
+//
+// Like the Reader, it doesn't hold the WAL lock, so it can run along with the writes.
+func (wal *WAL) Verify(collectAll bool) error {
+	reader := wal.NewReader()
+	defer reader.Close()
+	var errs []error
+	for _, segReader := range reader.segmentReaders {
+		err := segReader.scan(nil)
+		if err == nil {
+			continue
+		}
+		if _, ok := err.(*CorruptionError); !ok || !collectAll {
+			return err
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Repair scans all the segment files, and truncates the active segment file
+// back to the end of its last valid chunk if it has a corrupted or torn tail,
+// which is usually left by a crash during the write.
+// It returns the position of the last valid entry in the active segment file,
+// or nil if there is no valid entry in it.
+//
+// The older segment files are never truncated, since that would lose the data after
+// the corruption, their corruptions are returned as *CorruptionError joined by errors.Join
+// along with the position, so the active segment file is repaired even if an error is returned.
+func (wal *WAL) Repair() (*ChunkPosition, error) {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	ids := make([]SegmentID, 0, len(wal.olderSegments))
+	for id := range wal.olderSegments {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	var errs []error
+	for _, id := range ids {
+		if err := wal.olderSegments[id].NewReader().scan(nil); err != nil {
+			if _, ok := err.(*CorruptionError); !ok {
+				return nil, err
+			}
+			wal.options.Logger.Warnf("wal: segment file %d is corrupted: %v", id, err)
+			errs = append(errs, err)
+		}
+	}
+
+	sizeBefore := wal.activeSegment.Size()
+	last, err := wal.activeSegment.repair()
+	if err != nil {
+		return nil, err
+	}
+	if size := wal.activeSegment.Size(); size < sizeBefore {
+		wal.options.Logger.Warnf("wal: truncated the corrupted tail of segment file %d from %d to %d bytes",
+			wal.activeSegment.id, sizeBefore, size)
+	}
+	return last, errors.Join(errs...)
+}
+
+// scan iterates the segment reader to the end, or to the first corrupted chunk,
+// which is returned as a *CorruptionError.
+// The fn is called with the position of every valid entry if it is not nil,
+// the data of the entry is not read.
+func (segReader *segmentReader) scan(fn func(pos *ChunkPosition, entry *chunkEntry)) error {
+	for {
+		pos := &ChunkPosition{
+			SegmentId:   segReader.segment.id,
+			BlockNumber: segReader.blockNumber,
+			ChunkOffset: segReader.chunkOffset,
+		}
+		entry, chunkPos, err := segReader.next(true)
+		if err == io.EOF {
+			return nil
+		}
+		if err == ErrClosed {
+			return err
+		}
+		if err != nil {
+			return &CorruptionError{Position: pos, Err: err}
+		}
+		if fn != nil {
+			fn(chunkPos, &entry)
+		}
+	}
+}
+
+// hasTornTail reports whether the tail of the segment file is torn by a crash,
+// that is its last chunk is partially written, or is the FIRST or MIDDLE chunk
+// of an entry whose LAST chunk is never written.
+// Only the last block is read, since every block starts with a chunk.
+func (seg *segment) hasTornTail() (bool, error) {
+	size := seg.Size()
+	if size == 0 {
+		return false, nil
+	}
+	blockNumber := uint32((size - 1) / blockSize)
+	last := ChunkTypeFull
+	for chunkOffset := int64(0); chunkOffset+chunkHeaderSize < blockSize; {
+		header, err := seg.readHeader(blockNumber, chunkOffset)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		chunkOffset += chunkHeaderSize + int64(header.length)
+		if int64(blockNumber)*blockSize+chunkOffset > size {
+			return true, nil
+		}
+		last = header.chunkType
+	}
+	return last == ChunkTypeFirst || last == ChunkTypeMiddle, nil
+}
+
+// repair truncates the segment file to the end of the last valid chunk,
+// and returns the position of the last valid entry.
+// It must be called with the WAL lock held.
+func (seg *segment) repair() (*ChunkPosition, error) {
+	// the buffered data must be in the segment file to be scanned.
+	if err := seg.flushBuffer(); err != nil {
+		return nil, err
+	}
+	var last *ChunkPosition
+	index := &segmentIndex{complete: true, continued: seg.startsWithSpan()}
+	reader := seg.NewReader()
+	err := reader.scan(func(pos *ChunkPosition, entry *chunkEntry) {
+		timestamp, timed := entry.writeTime()
+		index.add(pos, timestamp, timed)
+		last = pos
+	})
+	if err == nil {
+		// the reader takes a torn multi-chunk entry, whose LAST chunk is never written,
+		// as the end of the segment file, see segmentReader.next, but it is corrupted here:
+		// the following writes would be joined to it.
+		if _, err := seg.readInternal(reader.blockNumber, reader.chunkOffset, readOptions{skipData: true}); err != ErrIncompleteEntry {
+			return last, nil
+		}
+	} else if _, ok := err.(*CorruptionError); !ok {
+		return nil, err
+	}
+
+	// the reader stops right after the last valid chunk, or at the start of the torn entry.
+	seg.currentBlockNumber = reader.blockNumber
+	seg.currentBlockSize = uint32(reader.chunkOffset)
+	if err := seg.truncate(); err != nil {
+		return nil, fmt.Errorf("truncate segment file %d failed: %v", seg.id, err)
+	}
+	seg.writtenSize.Store(seg.Size())
+	seg.startupBlock.blockNumber = -1
+	seg.hasFooter = false
+	seg.index = index
+	return last, nil
+}
@@ -0,0 +1,364 @@
+package wal
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// corruptChunk flips a byte of the chunk data at the given position.
+func corruptChunk(t *testing.T, wal *WAL, pos *ChunkPosition) {
+	path := SegmentFileName(wal.options.DirPath, wal.options.SegmentFileExt, pos.SegmentId)
+	fd, err := os.OpenFile(path, os.O_RDWR, 0)
+	assert.Nil(t, err)
+	defer fd.Close()
+
+	offset := int64(pos.BlockNumber)*blockSize + pos.ChunkOffset + chunkHeaderSize
+	b := make([]byte, 1)
+	_, err = fd.ReadAt(b, offset)
+	assert.Nil(t, err)
+	b[0] ^= 0xff
+	_, err = fd.WriteAt(b, offset)
+	assert.Nil(t, err)
+}
+
+func TestWAL_Verify(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-verify")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	var positions []*ChunkPosition
+	for i := 0; i < 500; i++ {
+		pos, err := wal.Write([]byte(strings.Repeat("X", 5*KB)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.True(t, wal.ActiveSegmentID() > 1)
+	assert.Nil(t, wal.Verify(false))
+	assert.Nil(t, wal.Verify(true))
+
+	first, last := positions[10], positions[len(positions)-10]
+	corruptChunk(t, wal, first)
+	corruptChunk(t, wal, last)
+
+	err = wal.Verify(false)
+	var corruption *CorruptionError
+	assert.True(t, errors.As(err, &corruption))
+	assert.True(t, errors.Is(err, ErrInvalidCRC))
+	assert.Equal(t, first.SegmentId, corruption.Position.SegmentId)
+	assert.Equal(t, first.BlockNumber, corruption.Position.BlockNumber)
+	assert.Equal(t, first.ChunkOffset, corruption.Position.ChunkOffset)
+
+	// the first corruption of every segment file is collected.
+	err = wal.Verify(true)
+	joined, ok := err.(interface{ Unwrap() []error })
+	assert.True(t, ok)
+	errs := joined.Unwrap()
+	assert.Equal(t, 2, len(errs))
+	assert.True(t, errors.As(errs[1], &corruption))
+	assert.Equal(t, last.SegmentId, corruption.Position.SegmentId)
+	assert.Equal(t, last.ChunkOffset, corruption.Position.ChunkOffset)
+}
+
+func TestWAL_VerifyOnOpen(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-verify-on-open")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		VerifyOnOpen:   true,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+
+	var positions []*ChunkPosition
+	for i := 0; i < 500; i++ {
+		pos, err := wal.Write([]byte(strings.Repeat("X", 5*KB)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.Nil(t, wal.Close())
+
+	// a clean WAL is opened as usual.
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	corruptChunk(t, wal, positions[10])
+	assert.Nil(t, wal.Close())
+
+	_, err = Open(opts)
+	var corruption *CorruptionError
+	assert.True(t, errors.As(err, &corruption))
+	assert.True(t, errors.Is(err, ErrInvalidCRC))
+	assert.Equal(t, positions[10].ChunkOffset, corruption.Position.ChunkOffset)
+
+	// the corruption is only found by the reads without it.
+	opts.VerifyOnOpen = false
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = wal.Read(positions[10])
+	assert.Equal(t, ErrInvalidCRC, err)
+	assert.Nil(t, wal.Close())
+}
+
+func TestWAL_Verify_TornWrite(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-verify-torn-write")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+
+	pos, err := wal.Write([]byte(strings.Repeat("X", 100)))
+	assert.Nil(t, err)
+	_, err = wal.Write([]byte(strings.Repeat("X", 100)))
+	assert.Nil(t, err)
+	assert.Nil(t, wal.Close())
+
+	// cut the last chunk in half as if the process crashed during the write.
+	path := SegmentFileName(dir, ".SEG", pos.SegmentId)
+	assert.Nil(t, os.Truncate(path, pos.ChunkOffset+int64(pos.ChunkSize)+50))
+	// the torn tail of the active segment file is truncated on Open, so make it an older one.
+	fd, err := os.Create(SegmentFileName(dir, ".SEG", pos.SegmentId+1))
+	assert.Nil(t, err)
+	assert.Nil(t, fd.Close())
+
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	err = wal.Verify(false)
+	var corruption *CorruptionError
+	assert.True(t, errors.As(err, &corruption))
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	assert.Equal(t, pos.ChunkOffset+int64(pos.ChunkSize), corruption.Position.ChunkOffset)
+}
+
+func TestWAL_Repair(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-repair")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+
+	val := []byte(strings.Repeat("X", 5*KB))
+	var positions []*ChunkPosition
+	for i := 0; i < 300; i++ {
+		pos, err := wal.Write(val)
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	activeId := wal.ActiveSegmentID()
+	assert.True(t, activeId > 1)
+
+	// a clean WAL is not changed.
+	last, err := wal.Repair()
+	assert.Nil(t, err)
+	assert.Equal(t, positions[len(positions)-1], last)
+
+	// corrupt an older segment file and tear the tail of the active one.
+	corruptChunk(t, wal, positions[10])
+	torn := positions[len(positions)-1]
+	assert.Nil(t, wal.Close())
+	path := SegmentFileName(dir, ".SEG", activeId)
+	assert.Nil(t, os.Truncate(path, int64(torn.BlockNumber)*blockSize+torn.ChunkOffset+100))
+
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	last, err = wal.Repair()
+	var corruption *CorruptionError
+	assert.True(t, errors.As(err, &corruption))
+	assert.Equal(t, positions[10].SegmentId, corruption.Position.SegmentId)
+	assert.Equal(t, positions[len(positions)-2], last)
+
+	// the active segment file is valid for the following writes.
+	pos, err := wal.Write([]byte("repaired"))
+	assert.Nil(t, err)
+	assert.Equal(t, torn.BlockNumber, pos.BlockNumber)
+	assert.Equal(t, torn.ChunkOffset, pos.ChunkOffset)
+	res, err := wal.Read(pos)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("repaired"), res)
+	res, err = wal.Read(last)
+	assert.Nil(t, err)
+	assert.Equal(t, val, res)
+
+	err = wal.Verify(true)
+	joined, ok := err.(interface{ Unwrap() []error })
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(joined.Unwrap()))
+}
+
+func TestWAL_SkipChecksumOnRead(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-skip-checksum-on-read")
+	// the checksum is verified by the zero value of the options.
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	val := []byte(strings.Repeat("X", 100))
+	pos, err := wal.Write(val)
+	assert.Nil(t, err)
+	corruptChunk(t, wal, pos)
+	_, err = wal.Read(pos)
+	assert.Equal(t, ErrInvalidCRC, err)
+
+	// the corrupted data is returned without the verification,
+	// but the Reader and Verify still detect it.
+	wal.options.SkipChecksumOnRead = true
+	res, err := wal.Read(pos)
+	assert.Nil(t, err)
+	assert.NotEqual(t, val, res)
+	_, _, err = wal.NewReader().Next()
+	assert.Equal(t, ErrInvalidCRC, err)
+	assert.True(t, errors.Is(wal.Verify(false), ErrInvalidCRC))
+}
+
+func TestWAL_RepairTornEntry(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-repair-torn-entry")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+
+	small, err := wal.Write([]byte("before"))
+	assert.Nil(t, err)
+	large, err := wal.Write([]byte(strings.Repeat("X", 100*KB)))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), large.BlockNumber)
+
+	// tear the large entry right after its FIRST chunk, the LAST chunk is never written.
+	assert.Nil(t, wal.Close())
+	path := SegmentFileName(dir, ".SEG", large.SegmentId)
+	assert.Nil(t, os.Truncate(path, blockSize))
+
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	last, err := wal.Repair()
+	assert.Nil(t, err)
+	assert.Equal(t, small, last)
+	assert.Equal(t, large.ChunkOffset, wal.activeSegment.Size())
+
+	// the following writes are not joined to the torn entry.
+	after, err := wal.Write([]byte("after"))
+	assert.Nil(t, err)
+	assert.Equal(t, large.BlockNumber, after.BlockNumber)
+	assert.Equal(t, large.ChunkOffset, after.ChunkOffset)
+
+	reader := wal.NewReader()
+	defer reader.Close()
+	var values []string
+	for {
+		val, _, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		values = append(values, string(val))
+	}
+	assert.Equal(t, []string{"before", "after"}, values)
+}
+
+func TestWAL_RepairTornChain(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-repair-torn-chain")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+
+	// a new entry is written after the FIRST chunk of a torn entry,
+	// such as by an older version which doesn't truncate the torn tail on Open.
+	seg, err := openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
+	assert.Nil(t, err)
+	_, err = seg.Write([]byte(strings.Repeat("X", 3*blockSize)))
+	assert.Nil(t, err)
+	assert.Nil(t, seg.Close())
+	assert.Nil(t, os.Truncate(SegmentFileName(dir, ".SEG", 1), blockSize))
+	seg, err = openSegmentFile(dir, ".SEG", 1, &DefaultOptions)
+	assert.Nil(t, err)
+	_, err = seg.Write([]byte("small"))
+	assert.Nil(t, err)
+	assert.Nil(t, seg.Close())
+
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+	assert.Equal(t, int64(blockSize+chunkHeaderSize+5), wal.activeSegment.Size())
+
+	// the segment file is truncated at the torn FIRST chunk.
+	last, err := wal.Repair()
+	assert.Nil(t, err)
+	assert.Nil(t, last)
+	assert.Equal(t, int64(0), wal.activeSegment.Size())
+}
+
+func TestWAL_OpenTornTail(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-open-torn-tail")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+	before, err := wal.Write([]byte("before"))
+	assert.Nil(t, err)
+	_, err = wal.Write([]byte(strings.Repeat("X", 3*blockSize)))
+	assert.Nil(t, err)
+
+	// only the FIRST chunk of the large entry survives a crash.
+	assert.Nil(t, wal.Close())
+	assert.Nil(t, os.Truncate(SegmentFileName(dir, ".SEG", 1), blockSize))
+
+	// the torn tail is truncated on Open, so the new entry is not joined to it.
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, before.end().ChunkOffset, wal.activeSegment.Size())
+	small, err := wal.Write([]byte("small"))
+	assert.Nil(t, err)
+	val, err := wal.Read(small)
+	assert.Nil(t, err)
+	assert.Equal(t, "small", string(val))
+
+	n, err := wal.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+}
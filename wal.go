@@ -1,11 +1,14 @@
 package wal
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
 	"sync"
@@ -18,7 +21,16 @@ const (
 
 var (
 	ErrValueTooLarge       = errors.New("the data size can't larger than segment size")
-	ErrPendingSizeTooLarge = errors.New("the upper bound of pendingWrites can't larger than segment size")
+	ErrPendingSizeTooLarge = errors.New("the upper bound of pendingWrites can't larger than max pending size")
+	ErrSegmentIDOverflow   = errors.New("the segment id reaches the max value, no more segment file can be created")
+	ErrSegmentGap          = errors.New("the segment ids are not contiguous, some segment files may be missing")
+	ErrDuplicateSegment    = errors.New("more than one segment file has the same id")
+	ErrByteBudgetExceeded  = errors.New("the data returned by the reader reaches the byte budget")
+	ErrPositionTruncated   = errors.New("the segment file of the position has been removed from the WAL")
+	ErrSegmentNotFound     = errors.New("the segment file of the position is not found")
+	ErrActiveSegment       = errors.New("the active segment file can not be removed")
+	ErrPositionConflict    = errors.New("the last position of the WAL is not the expected one")
+	ErrSyncPolicyOverride  = errors.New("the change takes no effect since Options.SyncPolicy syncs more often")
 )
 
 // WAL represents a Write-Ahead Log structure that provides durability
@@ -34,6 +46,7 @@ var (
 type WAL struct {
 	activeSegment     *segment               // active segment file, used for new incoming writes.
 	olderSegments     map[SegmentID]*segment // older segment files, only used for read.
+	loadedSegments    map[SegmentID]*segment // segment files loaded by options.SegmentLoader.
 	options           Options
 	mu                sync.RWMutex
 	bytesWrite        uint32
@@ -43,6 +56,27 @@ type WAL struct {
 	pendingWritesLock sync.Mutex
 	closeC            chan struct{}
 	syncTicker        *time.Ticker
+	syncPolicy        SyncPolicy // options.SyncPolicy combined with the legacy sync options.
+	stats             stats
+	notifyChans       []chan struct{}
+	rotations         []rotation // the rotations whose options.OnRotate is not called yet.
+	rotateHookLock    sync.Mutex
+	trimStart         *ChunkPosition // the start position set by TrimFront, nil if not set.
+	txnStart          *ChunkPosition // the first uncommitted entry since the last Commit, nil if none.
+	readOnly          bool           // whether the WAL is opened by OpenReaderAt.
+	singleFile        *singleFileSystem
+	lastWrite         WriteStats   // the stats of the last write, see LastWriteStats.
+	dedup             *dedupWindow // the recent ids of WriteDedup, nil if options.DedupWindow is zero.
+	dirDirty          bool         // whether a segment file is created since the directory is synced, see syncDir.
+	// missingSegments are the segment files not found by options.SegmentLoader recently,
+	// see Options.SegmentLoaderMissTTL.
+	missingSegments map[SegmentID]missingSegment
+}
+
+// rotation records a rotation of the active segment file.
+type rotation struct {
+	oldID SegmentID
+	newID SegmentID
 }
 
 // Reader represents a reader for the WAL.
@@ -51,65 +85,171 @@ type WAL struct {
 // and currentReader, which is the index of the current segmentReader in the slice.
 //
 // The currentReader field is used to iterate over the segmentReaders slice.
+//
+// The Reader takes a snapshot of the segment files when it is created,
+// and doesn't hold the WAL lock when iterating, so it never blocks the writes and rotations.
+// The isolation it provides:
+//   - The rotations never affect it, the sealed segment files stay open and are never modified.
+//   - It sees the data written to the active segment file after it was created,
+//     but not the segment files created after it, so it returns a prefix of the WAL
+//     which may grow until the active segment file is sealed.
+//   - NewSnapshotReader returns a reader which only sees the data written before it was created.
+//   - The segment files removed by retention, WAL.TrimFront or WAL.RemoveSegment after it was created
+//     are still read by it, they are removed from the disk once all the readers reading them release them,
+//     that is when the reader reads past them, or Close is called.
+//   - ErrClosed is returned if the WAL is closed during the iteration.
+//
+// Call Close if the reader is not read to the end, otherwise the removed segment files
+// stay on the disk until the reader is garbage collected.
+//
+// A Reader is not safe for concurrent use by multiple goroutines.
 type Reader struct {
 	segmentReaders []*segmentReader
 	currentReader  int
+	lastEntry      chunkEntry
+	limit          int   // the max number of entries to return, no limit if it is zero.
+	count          int   // the number of entries returned.
+	byteBudget     int64 // the max size of the data to return, no budget if it is zero.
+	bytesRead      int64 // the size of the data returned since the budget is set.
+	// stopC and workers stop and wait for the goroutines of the parallel reader.
+	stopC   chan struct{}
+	workers *sync.WaitGroup
+	logger  Logger
+	// uncommitted is the uncommitted entries waiting for their commit marker,
+	// and committed is the ones whose commit marker is read, which are returned first.
+	uncommitted []txnEntry
+	committed   []txnEntry
+	// peeked is the entry returned by Peek, which is returned by the following Next.
+	peeked *txnEntry
+	// batchData and batchPositions are the slices returned by NextBatch, reused by the next call.
+	batchData      [][]byte
+	batchPositions []*ChunkPosition
 }
 
 // Open opens a WAL with the given options.
 // It will create the directory if not exists, and open all segment files in the directory.
 // If there is no segment file in the directory, it will create a new one.
+//
+// If the active segment file has no footer, e.g. it is written by an older version or the process
+// crashed before sealing it, it is scanned once to build its index when it is sealed, which holds
+// the WAL lock and blocks the writes for the time of reading the whole segment file.
+// If the tail of the active segment file is torn by a crash, it is truncated like Repair
+// before any write is appended to it.
 func Open(options Options) (*WAL, error) {
-	if !strings.HasPrefix(options.SegmentFileExt, ".") {
+	if options.SegmentNameFunc == nil && !strings.HasPrefix(options.SegmentFileExt, ".") {
 		return nil, fmt.Errorf("segment file extension must start with '.'")
 	}
+	if (options.SegmentNameFunc == nil) != (options.SegmentParseFunc == nil) {
+		return nil, fmt.Errorf("segment name func and segment parse func must be set together")
+	}
+	if options.FileSystem == nil {
+		options.FileSystem = OSFileSystem
+	}
+	if options.FileMode == 0 {
+		options.FileMode = fileModePerm
+	}
+	if options.DirMode == 0 {
+		options.DirMode = os.ModePerm
+	}
+	if options.Logger == nil {
+		options.Logger = nopLogger{}
+	}
 	wal := &WAL{
-		options:       options,
-		olderSegments: make(map[SegmentID]*segment),
-		pendingWrites: make([][]byte, 0),
-		closeC:        make(chan struct{}),
+		options:        options,
+		syncPolicy:     options.syncPolicy(),
+		olderSegments:  make(map[SegmentID]*segment),
+		loadedSegments: make(map[SegmentID]*segment),
+		pendingWrites:  make([][]byte, 0),
+		closeC:         make(chan struct{}),
 	}
 
 	// create the directory if not exists.
-	if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
+	if err := options.FileSystem.MkdirAll(options.DirPath, options.DirMode); err != nil {
 		return nil, err
 	}
 
+	// the segment files are stored in one file in the single file mode.
+	if options.SingleFile {
+		sfs, err := openSingleFileSystem(&options)
+		if err != nil {
+			return nil, err
+		}
+		options.FileSystem = sfs
+		wal.options.FileSystem = sfs
+		wal.singleFile = sfs
+	}
+
 	// iterate the dir and open all segment files.
-	entries, err := os.ReadDir(options.DirPath)
+	entries, err := options.FileSystem.ReadDir(options.DirPath)
 	if err != nil {
 		return nil, err
 	}
 
 	// get all segment file ids.
 	var segmentIDs []int
+	var hasTrimFile bool
 	for _, entry := range entries {
 		if entry.IsDir() {
 			continue
 		}
-		var id int
-		_, err := fmt.Sscanf(entry.Name(), "%d"+options.SegmentFileExt, &id)
-		if err != nil {
+		name := entry.Name()
+		if name == trimFileName {
+			hasTrimFile = true
+			continue
+		}
+		temp := strings.HasSuffix(name, tempSegmentExt)
+		if temp {
+			name = strings.TrimSuffix(name, tempSegmentExt)
+		}
+		id, ok := parseSegmentID(&options, name)
+		if !ok {
 			continue
 		}
+		if temp {
+			// the temporary segment file is left by a crash, see Options.TempSegmentFiles.
+			if !options.TempSegmentFiles {
+				continue
+			}
+			kept, err := recoverTempSegment(&options, name)
+			if err != nil {
+				return nil, err
+			}
+			if !kept {
+				options.Logger.Warnf("wal: removed the empty temporary segment file %s", entry.Name())
+				continue
+			}
+			options.Logger.Warnf("wal: recovered the temporary segment file %s", entry.Name())
+		}
+		if id > math.MaxUint32 {
+			return nil, fmt.Errorf("segment file %s: %w", entry.Name(), ErrSegmentIDOverflow)
+		}
 		segmentIDs = append(segmentIDs, id)
 	}
 
 	// empty directory, just initialize a new segment file.
 	if len(segmentIDs) == 0 {
-		segment, err := openSegmentFile(options.DirPath, options.SegmentFileExt,
-			initialSegmentFileID)
+		id := SegmentID(initialSegmentFileID)
+		if options.InitialSegmentID > 0 {
+			id = options.InitialSegmentID
+		}
+		segment, err := openSegmentFile(options.DirPath, options.SegmentFileExt, id, &wal.options)
 		if err != nil {
 			return nil, err
 		}
 		wal.activeSegment = segment
+		wal.dirDirty = true
 	} else {
 		// open the segment files in order, get the max one as the active segment file.
 		sort.Ints(segmentIDs)
+		if options.ValidateSegmentSequence {
+			if err := validateSegmentSequence(segmentIDs, options.SegmentFileExt); err != nil {
+				return nil, err
+			}
+		}
 
 		for i, segId := range segmentIDs {
 			segment, err := openSegmentFile(options.DirPath, options.SegmentFileExt,
-				uint32(segId))
+				uint32(segId), &wal.options)
 			if err != nil {
 				return nil, err
 			}
@@ -117,13 +257,68 @@ func Open(options Options) (*WAL, error) {
 				wal.activeSegment = segment
 			} else {
 				wal.olderSegments[segment.id] = segment
+				if options.MMapReads {
+					if err := segment.mmap(); err != nil {
+						return nil, err
+					}
+				}
 			}
 		}
 	}
 
-	// only start the sync operation if the SyncInterval is greater than 0.
-	if wal.options.SyncInterval > 0 {
-		wal.syncTicker = time.NewTicker(wal.options.SyncInterval)
+	if hasTrimFile {
+		if err := wal.loadTrimStart(); err != nil {
+			return nil, err
+		}
+	}
+
+	// the torn tail of the active segment file left by a crash is truncated first,
+	// otherwise the following writes would be joined to the torn entry.
+	torn, err := wal.activeSegment.hasTornTail()
+	if err != nil {
+		_ = wal.Close()
+		return nil, err
+	}
+	if torn {
+		sizeBefore := wal.activeSegment.Size()
+		if _, err := wal.activeSegment.repair(); err != nil {
+			_ = wal.Close()
+			return nil, err
+		}
+		options.Logger.Warnf("wal: truncated the torn tail of segment file %d from %d to %d bytes",
+			wal.activeSegment.id, sizeBefore, wal.activeSegment.Size())
+	}
+
+	if options.VerifyOnOpen {
+		if err := wal.Verify(false); err != nil {
+			_ = wal.Close()
+			return nil, err
+		}
+	}
+
+	if options.DedupWindow > 0 {
+		wal.dedup = newDedupWindow(options.DedupWindow)
+		if err := wal.loadDedupWindow(); err != nil {
+			_ = wal.Close()
+			return nil, err
+		}
+	}
+
+	// seal the last segment file of the previous run, unless nothing is written to it.
+	// The lock is taken so that the OnRotate hook is called by unlock before Open returns.
+	if options.StartNewSegmentOnOpen && wal.activeSegment.Size() > 0 {
+		wal.mu.Lock()
+		err := wal.rotateActiveSegment()
+		wal.unlock()
+		if err != nil {
+			_ = wal.Close()
+			return nil, err
+		}
+	}
+
+	// only start the sync operation if the sync interval is greater than 0.
+	if interval := wal.syncPolicy.Interval(); interval > 0 {
+		wal.syncTicker = time.NewTicker(interval)
 		go func() {
 			for {
 				select {
@@ -140,11 +335,91 @@ func Open(options Options) (*WAL, error) {
 	return wal, nil
 }
 
+// validateSegmentSequence checks that the sorted segment ids form a contiguous range.
+func validateSegmentSequence(segmentIDs []int, extName string) error {
+	for i := 1; i < len(segmentIDs); i++ {
+		prev, id := segmentIDs[i-1], segmentIDs[i]
+		if id == prev {
+			return fmt.Errorf("segment file %d%s: %w", id, extName, ErrDuplicateSegment)
+		}
+		if id != prev+1 {
+			return fmt.Errorf("segment files between %d%s and %d%s: %w", prev, extName, id, extName, ErrSegmentGap)
+		}
+	}
+	return nil
+}
+
+// parseSegmentID returns the id of the segment file by its name,
+// and false if it is not a segment file.
+func parseSegmentID(options *Options, name string) (int, bool) {
+	if options.SegmentParseFunc != nil {
+		id, ok := options.SegmentParseFunc(name)
+		return int(id), ok
+	}
+	var id int
+	if _, err := fmt.Sscanf(name, "%d"+options.SegmentFileExt, &id); err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// recoverTempSegment removes the temporary segment file if it is empty,
+// otherwise renames it to the given final name, and returns whether it is kept.
+func recoverTempSegment(options *Options, name string) (bool, error) {
+	path := filepath.Join(options.DirPath, name)
+	info, err := options.FileSystem.Stat(path + tempSegmentExt)
+	if err != nil {
+		return false, err
+	}
+	if info.Size() == 0 {
+		return false, options.FileSystem.Remove(path + tempSegmentExt)
+	}
+	if _, err := options.FileSystem.Stat(path); err == nil {
+		return false, fmt.Errorf("both segment file %s and its temporary file exist", name)
+	}
+	return true, options.FileSystem.Rename(path+tempSegmentExt, path)
+}
+
 // SegmentFileName returns the file name of a segment file.
 func SegmentFileName(dirPath string, extName string, id SegmentID) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d"+extName, id))
 }
 
+// segmentFileName returns the path of the segment file in the WAL directory.
+func (wal *WAL) segmentFileName(id SegmentID) string {
+	return segmentFilePath(&wal.options, wal.options.DirPath, wal.options.SegmentFileExt, id)
+}
+
+// SegmentPath returns the path of the segment file with the given id in the WAL directory,
+// named by options.SegmentNameFunc or SegmentFileName as the WAL names it.
+// The segment file may not exist, such as it is removed by retention.
+// If options.TempSegmentFiles is true, the active segment file may still have the
+// temporary name until it is synced, which is the path plus ".tmp".
+func (wal *WAL) SegmentPath(id SegmentID) string {
+	wal.mu.RLock()
+	defer wal.mu.RUnlock()
+
+	return wal.segmentFileName(id)
+}
+
+// SegmentIDs returns the ids of all the segment files in the WAL in ascending order,
+// the last one is the active segment file. The segment files removed by retention are not included.
+func (wal *WAL) SegmentIDs() []SegmentID {
+	wal.mu.RLock()
+	defer wal.mu.RUnlock()
+
+	return append(wal.sortedOlderSegmentIDs(), wal.activeSegment.id)
+}
+
+// segmentFilePath returns the path of the segment file named by options.SegmentNameFunc,
+// or by SegmentFileName if it is nil.
+func segmentFilePath(options *Options, dirPath, extName string, id SegmentID) string {
+	if options.SegmentNameFunc != nil {
+		return filepath.Join(dirPath, options.SegmentNameFunc(id))
+	}
+	return SegmentFileName(dirPath, extName, id)
+}
+
 // OpenNewActiveSegment opens a new segment file
 // and sets it as the active segment file.
 // It is used when even the active segment file is not full,
@@ -153,20 +428,21 @@ func SegmentFileName(dirPath string, extName string, id SegmentID) string {
 // It is now used by Merge operation of rosedb, not a common usage for most users.
 func (wal *WAL) OpenNewActiveSegment() error {
 	wal.mu.Lock()
-	defer wal.mu.Unlock()
-	// sync the active segment file.
-	if err := wal.activeSegment.Sync(); err != nil {
-		return err
-	}
-	// create a new segment file and set it as the active one.
-	segment, err := openSegmentFile(wal.options.DirPath, wal.options.SegmentFileExt,
-		wal.activeSegment.id+1)
-	if err != nil {
-		return err
-	}
-	wal.olderSegments[wal.activeSegment.id] = wal.activeSegment
-	wal.activeSegment = segment
-	return nil
+	defer wal.unlock()
+
+	return wal.rotateActiveSegment()
+}
+
+// OpenNewActiveSegmentWithoutSync creates a new segment file like OpenNewActiveSegment,
+// but the old active segment file is not synced, which saves the fsync when the durability
+// is left to the sync policy, such as loading a lot of data with Options.Sync disabled.
+// The old segment file is never synced by the WAL afterwards,
+// so its data may be lost if the machine crashes before the operating system writes it back.
+func (wal *WAL) OpenNewActiveSegmentWithoutSync() error {
+	wal.mu.Lock()
+	defer wal.unlock()
+
+	return wal.rotate(false)
 }
 
 // ActiveSegmentID returns the id of the active segment file.
@@ -177,6 +453,17 @@ func (wal *WAL) ActiveSegmentID() SegmentID {
 	return wal.activeSegment.id
 }
 
+// IsActive reports whether the position is in the active segment file, which is still growing,
+// so more data may follow the position in the same segment file.
+// It is false for the sealed segment files, which will never be modified,
+// a tailing reader can move on to the next segment file once it reaches the end of one.
+func (wal *WAL) IsActive(pos *ChunkPosition) bool {
+	wal.mu.RLock()
+	defer wal.mu.RUnlock()
+
+	return pos.SegmentId == wal.activeSegment.id
+}
+
 // IsEmpty returns whether the WAL is empty.
 // Only there is only one empty active segment file, which means the WAL is empty.
 func (wal *WAL) IsEmpty() bool {
@@ -186,6 +473,98 @@ func (wal *WAL) IsEmpty() bool {
 	return len(wal.olderSegments) == 0 && wal.activeSegment.Size() == 0
 }
 
+// Len returns the total number of entries in the WAL, the internal entries are not counted,
+// but the entries written by WriteUncommitted are, no matter whether they are committed.
+//
+// The number of entries of a segment file is known without reading it if the segment file
+// has a footer, or it is created by this WAL, so the sealed segment files cost nothing.
+// The other segment files, usually the active one and the ones written by older versions
+// when the WAL is reopened, are scanned entirely, which costs the time to read them.
+// The scan doesn't hold the WAL lock, the entries written during it may be counted or not.
+func (wal *WAL) Len() (int, error) {
+	wal.mu.RLock()
+	var total int
+	var scanReaders []*segmentReader
+	segments := append(make([]*segment, 0, len(wal.olderSegments)+1), wal.activeSegment)
+	for _, seg := range wal.olderSegments {
+		segments = append(segments, seg)
+	}
+	for _, seg := range segments {
+		// the entries before the start position set by TrimFront are not counted.
+		if seg.index.complete && !wal.isTrimmedSegment(seg.id) {
+			total += int(seg.index.entries)
+		} else {
+			reader := wal.newSegmentReader(seg)
+			// the segment file is referenced with the WAL lock held, so it is not
+			// removed by the retention or TrimFront during the scan.
+			seg.acquire()
+			reader.acquired = true
+			scanReaders = append(scanReaders, reader)
+		}
+	}
+	wal.mu.RUnlock()
+	defer func() {
+		for _, reader := range scanReaders {
+			if err := reader.release(); err != nil {
+				wal.options.Logger.Warnf("wal: failed to remove the evicted segment file %d: %v", reader.segment.id, err)
+			}
+		}
+	}()
+
+	for _, reader := range scanReaders {
+		for {
+			if _, _, err := reader.NextPosition(); err != nil {
+				if err == io.EOF {
+					break
+				}
+				return 0, err
+			}
+			total++
+		}
+	}
+	return total, nil
+}
+
+// FirstPosition returns the position of the oldest entry still in the WAL,
+// that is the first valid entry of the segment file with the lowest id,
+// the segment files removed by retention are not considered.
+// It returns nil if the WAL is empty.
+func (wal *WAL) FirstPosition() (*ChunkPosition, error) {
+	reader := wal.NewReader()
+	defer reader.Close()
+	pos, _, err := reader.NextPosition()
+	if err == io.EOF {
+		return nil, nil
+	}
+	return pos, err
+}
+
+// CurrentPosition returns the write cursor of the active segment file,
+// that is the position the next entry will be written at, and its ChunkSize is 0.
+// If the left space of the current block can not hold a chunk header,
+// the block is padded by the next write, so the start of the next block is returned.
+// But it is in the next segment file if the next write rotates the active segment file.
+func (wal *WAL) CurrentPosition() *ChunkPosition {
+	wal.mu.RLock()
+	defer wal.mu.RUnlock()
+	return wal.currentPosition()
+}
+
+// currentPosition returns the position of the next entry in the active segment file,
+// it must be called with the WAL lock held.
+func (wal *WAL) currentPosition() *ChunkPosition {
+	pos := &ChunkPosition{
+		SegmentId:   wal.activeSegment.id,
+		BlockNumber: wal.activeSegment.currentBlockNumber,
+		ChunkOffset: int64(wal.activeSegment.currentBlockSize),
+	}
+	if pos.ChunkOffset+chunkHeaderSize >= blockSize {
+		pos.BlockNumber += 1
+		pos.ChunkOffset = 0
+	}
+	return pos
+}
+
 // SetIsStartupTraversal This is only used if the WAL is during startup traversal.
 // Such as rosedb/lotusdb startup, so it's not a common usage for most users.
 // And notice that if you set it to true, only one reader can read the data from the WAL
@@ -203,6 +582,12 @@ func (wal *WAL) SetIsStartupTraversal(v bool) {
 //
 // It is now used by the Merge operation of rosedb, not a common usage for most users.
 func (wal *WAL) NewReaderWithMax(segId SegmentID) *Reader {
+	// the reader can not see the buffered data, flush it first.
+	// The error is returned by the following writes as well, so it is ignored here.
+	if wal.options.WriteBufferSize > 0 {
+		_ = wal.Flush()
+	}
+
 	wal.mu.RLock()
 	defer wal.mu.RUnlock()
 
@@ -210,12 +595,12 @@ func (wal *WAL) NewReaderWithMax(segId SegmentID) *Reader {
 	var segmentReaders []*segmentReader
 	for _, segment := range wal.olderSegments {
 		if segId == 0 || segment.id <= segId {
-			reader := segment.NewReader()
+			reader := wal.newSegmentReader(segment)
 			segmentReaders = append(segmentReaders, reader)
 		}
 	}
 	if segId == 0 || wal.activeSegment.id <= segId {
-		reader := wal.activeSegment.NewReader()
+		reader := wal.newSegmentReader(wal.activeSegment)
 		segmentReaders = append(segmentReaders, reader)
 	}
 
@@ -223,11 +608,90 @@ func (wal *WAL) NewReaderWithMax(segId SegmentID) *Reader {
 	sort.Slice(segmentReaders, func(i, j int) bool {
 		return segmentReaders[i].segment.id < segmentReaders[j].segment.id
 	})
+	for _, reader := range segmentReaders {
+		reader.keepMarkers = true
+		// the segment files are referenced with the WAL lock held, so none of them is evicted yet.
+		reader.segment.acquire()
+		reader.acquired = true
+	}
+
+	return newReader(segmentReaders, wal.options.Logger)
+}
+
+// NewReaderForSegment returns a new reader which only reads the segment file with the id,
+// such as to inspect a segment file reported as corrupted.
+// It returns ErrSegmentNotFound if the segment file is not in the WAL.
+// The entries spanning from or to the other segment files are skipped, since they can't be read entirely.
+func (wal *WAL) NewReaderForSegment(id SegmentID) (*Reader, error) {
+	// the reader can not see the buffered data, flush it first.
+	if wal.options.WriteBufferSize > 0 {
+		_ = wal.Flush()
+	}
+
+	wal.mu.RLock()
+	defer wal.mu.RUnlock()
+
+	seg := wal.olderSegments[id]
+	if id == wal.activeSegment.id {
+		seg = wal.activeSegment
+	}
+	if seg == nil {
+		return nil, fmt.Errorf("segment file %d%s: %w", id, wal.options.SegmentFileExt, ErrSegmentNotFound)
+	}
+	segReader := wal.newSegmentReader(seg)
+	segReader.keepMarkers = true
+	seg.acquire()
+	segReader.acquired = true
+	return newReader([]*segmentReader{segReader}, wal.options.Logger), nil
+}
 
-	return &Reader{
+// newReader returns a reader of the segment readers, which releases the segment files
+// referenced by them when it is garbage collected without Close being called.
+func newReader(segmentReaders []*segmentReader, logger Logger) *Reader {
+	reader := &Reader{
 		segmentReaders: segmentReaders,
 		currentReader:  0,
+		logger:         logger,
+	}
+	runtime.SetFinalizer(reader, (*Reader).releaseSegments)
+	return reader
+}
+
+// NewSnapshotReader returns a new reader for the WAL like NewReader, but it only returns
+// the data written before it is created, the data written after that is never returned,
+// even if it is in the active segment file, so it reads a consistent snapshot of the WAL.
+func (wal *WAL) NewSnapshotReader() *Reader {
+	reader := wal.NewReader()
+	for _, segReader := range reader.segmentReaders {
+		segReader.bounded = true
+		segReader.end = segReader.segment.writtenSize.Load()
+	}
+	return reader
+}
+
+// NewReaderAtEnd returns a new reader which starts after all the data in the WAL,
+// so its first Next returns the next entry written to the active segment file,
+// such as to tail the WAL along with Notify without reading the history.
+// Like the other readers, it returns io.EOF once it reaches the end of the data,
+// and doesn't read the segment files created after it, so the tailing goes on
+// by a reader from its cursor, see Reader.SaveCursor and WAL.NewReaderFromCursor.
+// The uncommitted entries written before it are not returned even if they are committed after it.
+func (wal *WAL) NewReaderAtEnd() *Reader {
+	// the reader can not see the buffered data, flush it first.
+	if wal.options.WriteBufferSize > 0 {
+		_ = wal.Flush()
 	}
+
+	wal.mu.RLock()
+	defer wal.mu.RUnlock()
+	pos := wal.currentPosition()
+	segReader := wal.activeSegment.NewReader()
+	segReader.blockNumber = pos.BlockNumber
+	segReader.chunkOffset = pos.ChunkOffset
+	segReader.keepMarkers = true
+	wal.activeSegment.acquire()
+	segReader.acquired = true
+	return newReader([]*segmentReader{segReader}, wal.options.Logger)
 }
 
 // NewReaderWithStart returns a new reader for the WAL,
@@ -237,20 +701,32 @@ func (wal *WAL) NewReaderWithStart(startPos *ChunkPosition) (*Reader, error) {
 	if startPos == nil {
 		return nil, errors.New("start position is nil")
 	}
-	wal.mu.RLock()
-	defer wal.mu.RUnlock()
-
+	// the reader takes a snapshot of the segment files with the lock held,
+	// and it is iterated without the lock like the other readers.
 	reader := wal.NewReader()
-	for {
+	for reader.currentReader < len(reader.segmentReaders) {
 		// skip the segment readers whose id is less than the given position's segment id.
 		if reader.CurrentSegmentId() < startPos.SegmentId {
 			reader.SkipCurrentSegment()
 			continue
 		}
-		// skip the chunk whose position is less than the given position.
+		// skip the segment file if all its entries are before the given position.
+		segReader := reader.segmentReaders[reader.currentReader]
+		if index := wal.sealedIndex(segReader.segment); index != nil &&
+			segReader.segment.id == startPos.SegmentId && index.endsBefore(startPos) {
+			reader.SkipCurrentSegment()
+			continue
+		}
+		// jump to the block of the given position directly if the segment file is indexed.
+		if segReader.segment.id == startPos.SegmentId && segReader.blockNumber < startPos.BlockNumber {
+			if blockNumber, chunkOffset, ok := wal.seekIndex(segReader.segment, startPos.BlockNumber); ok {
+				segReader.blockNumber, segReader.chunkOffset = blockNumber, chunkOffset
+			}
+		}
+		// skip the chunk whose position is less than the given position,
+		// the following segment files are after it as a whole.
 		currentPos := reader.CurrentChunkPosition()
-		if currentPos.BlockNumber >= startPos.BlockNumber &&
-			currentPos.ChunkOffset >= startPos.ChunkOffset {
+		if !currentPos.before(startPos) {
 			break
 		}
 		// call Next to find again.
@@ -258,138 +734,699 @@ func (wal *WAL) NewReaderWithStart(startPos *ChunkPosition) (*Reader, error) {
 			if err == io.EOF {
 				break
 			}
+			reader.Close()
 			return nil, err
 		}
 	}
 	return reader, nil
 }
 
+// NewReaderWithTimeRange returns a new reader for the WAL,
+// and the reader will only read the data whose write timestamp is in [start, end).
+// The data not written by WriteWithTime has no timestamp and will be skipped.
+//
+// It scans the segment files and filters the data by the timestamp, but the sealed segment
+// files whose range of timestamps recorded in the footer doesn't overlap are skipped without reading.
+func (wal *WAL) NewReaderWithTimeRange(start, end time.Time) (*Reader, error) {
+	if end.Before(start) {
+		return nil, errors.New("the end of the time range is before the start")
+	}
+	startNano, endNano := start.UnixNano(), end.UnixNano()
+	filter := func(entry *chunkEntry) bool {
+		return entry.flags&entryFlagTimestamp != 0 &&
+			entry.timestamp >= startNano && entry.timestamp < endNano
+	}
+
+	// skip the sealed segment files whose range of timestamps doesn't overlap,
+	// unless a spanning entry of the previous segment file continues in it.
+	reader := wal.NewReader()
+	segmentReaders := reader.segmentReaders[:0]
+	var prevKept bool
+	for _, segReader := range reader.segmentReaders {
+		index := wal.sealedIndex(segReader.segment)
+		if index != nil && !index.overlaps(startNano, endNano) && !(prevKept && index.continued) {
+			reader.releaseSegment(segReader)
+			prevKept = false
+			continue
+		}
+		segReader.filter = filter
+		segmentReaders = append(segmentReaders, segReader)
+		prevKept = true
+	}
+	reader.segmentReaders = segmentReaders
+	return reader, nil
+}
+
+// sealedIndex returns the index of the segment file if it is sealed and the index
+// records the range of it, otherwise nil. The index of a sealed segment file never changes.
+func (wal *WAL) sealedIndex(seg *segment) *segmentIndex {
+	wal.mu.RLock()
+	defer wal.mu.RUnlock()
+	if seg == wal.activeSegment || !seg.index.hasRange() {
+		return nil
+	}
+	return seg.index
+}
+
+// seekIndex returns the position of the first entry starting in the block or after it
+// by the index of the segment file, if the index is complete.
+// The lock is held since the index of the active segment file grows with the writes.
+func (wal *WAL) seekIndex(seg *segment, blockNumber uint32) (uint32, int64, bool) {
+	wal.mu.RLock()
+	defer wal.mu.RUnlock()
+	if !seg.index.complete {
+		return 0, 0, false
+	}
+	return seg.index.seek(blockNumber)
+}
+
 // NewReader returns a new reader for the WAL.
 // It will iterate all segment files and read all data from them.
 func (wal *WAL) NewReader() *Reader {
 	return wal.NewReaderWithMax(0)
 }
 
+// NewReaderWithContext returns a new reader for the WAL, which is like NewReader,
+// but the context is checked before reading every block, Next and NextPosition
+// return the context error promptly once the context is cancelled.
+// It is useful to cancel replaying a huge WAL when shutting down.
+func (wal *WAL) NewReaderWithContext(ctx context.Context) *Reader {
+	reader := wal.NewReader()
+	for _, segReader := range reader.segmentReaders {
+		segReader.ctx = ctx
+	}
+	return reader
+}
+
+// MergeReaders returns a reader which presents the given readers as one sequential reader,
+// it yields all the remaining chunks of readers[0], then readers[1], and so on.
+// The given readers should not be used anymore after merging.
+//
+// Notice that the returned positions don't tell which WAL the chunk comes from,
+// so they are only meaningful together with the reader they are read from.
+func MergeReaders(readers ...*Reader) *Reader {
+	var segmentReaders []*segmentReader
+	var logger Logger = nopLogger{}
+	for _, r := range readers {
+		segmentReaders = append(segmentReaders, r.detach()...)
+		if r.logger != nil {
+			logger = r.logger
+		}
+	}
+	return newReader(segmentReaders, logger)
+}
+
 // Next returns the next chunk data and its position in the WAL.
 // If there is no data, io.EOF will be returned.
 //
 // The position can be used to read the data from the segment file.
 func (r *Reader) Next() ([]byte, *ChunkPosition, error) {
-	if r.currentReader >= len(r.segmentReaders) {
-		return nil, nil, io.EOF
+	entry, position, err := r.next(false)
+	return entry.data, position, err
+}
+
+// NextBatch returns up to n next entries and their positions like calling Next n times,
+// such as to feed a batch-oriented consumer. It returns fewer entries at the end of the WAL,
+// and io.EOF if there is no data. If an error occurs after some entries are read,
+// the entries are returned without the error, which is returned by the following call.
+//
+// The returned slices are reused by the following NextBatch call, copy them to keep them,
+// the data of the entries is not reused and can be kept.
+func (r *Reader) NextBatch(n int) ([][]byte, []*ChunkPosition, error) {
+	r.batchData, r.batchPositions = r.batchData[:0], r.batchPositions[:0]
+	for len(r.batchData) < n {
+		entry, position, err := r.next(false)
+		if err != nil {
+			if len(r.batchData) > 0 {
+				break
+			}
+			return nil, nil, err
+		}
+		r.batchData = append(r.batchData, entry.data)
+		r.batchPositions = append(r.batchPositions, position)
 	}
+	return r.batchData, r.batchPositions, nil
+}
 
-	data, position, err := r.segmentReaders[r.currentReader].Next()
-	if err == io.EOF {
-		r.currentReader++
-		return r.Next()
+// next returns the next entry accepted by the filter of the segment reader.
+func (r *Reader) next(skipData bool) (chunkEntry, *ChunkPosition, error) {
+	if r.limit > 0 && r.count >= r.limit {
+		return chunkEntry{}, nil, io.EOF
+	}
+	if r.byteBudget > 0 && r.bytesRead >= r.byteBudget {
+		return chunkEntry{}, nil, ErrByteBudgetExceeded
+	}
+	if r.stopC != nil {
+		select {
+		case <-r.stopC:
+			return chunkEntry{}, nil, ErrClosed
+		default:
+		}
+	}
+	if r.peeked != nil {
+		e := r.peeked
+		r.peeked = nil
+		return r.accept(e.entry), e.position, nil
+	}
+	for {
+		// return the committed entries before reading on.
+		if len(r.committed) > 0 {
+			e := r.committed[0]
+			r.committed = r.committed[1:]
+			return r.accept(e.entry), e.position, nil
+		}
+		if r.currentReader >= len(r.segmentReaders) {
+			return chunkEntry{}, nil, io.EOF
+		}
+
+		segReader := r.segmentReaders[r.currentReader]
+		entry, position, err := segReader.next(skipData)
+		if err == io.EOF {
+			r.releaseSegment(segReader)
+			r.currentReader++
+			continue
+		}
+		if err != nil {
+			return chunkEntry{}, nil, err
+		}
+		if start, ok := entry.commitStart(); ok {
+			r.commit(start)
+			continue
+		}
+		if entry.isMarker() {
+			entry.stripMarkerKind()
+		}
+		if entry.flags&entryFlagSpan != 0 {
+			entry, err = readSpan(entry, r.findSegment, readOptions{skipData: skipData, ctx: segReader.ctx})
+			if errors.Is(err, ErrIncompleteSpan) {
+				// the spanning entry is torn, or the segment files of it are not in the reader.
+				r.logger.Warnf("wal: skipped the incomplete spanning entry at %d/%d/%d",
+					position.SegmentId, position.BlockNumber, position.ChunkOffset)
+				continue
+			}
+			if err != nil {
+				return chunkEntry{}, nil, err
+			}
+		}
+		if segReader.filter != nil && !segReader.filter(&entry) {
+			continue
+		}
+		if entry.flags&entryFlagUncommitted != 0 {
+			r.uncommitted = append(r.uncommitted, txnEntry{entry: entry, position: position})
+			continue
+		}
+		return r.accept(entry), position, nil
 	}
-	return data, position, err
 }
 
-// SkipCurrentSegment skips the current segment file
-// when reading the WAL.
-//
-// It is now used by the Merge operation of rosedb, not a common usage for most users.
-func (r *Reader) SkipCurrentSegment() {
-	r.currentReader++
+// accept records the entry as returned by the reader.
+func (r *Reader) accept(entry chunkEntry) chunkEntry {
+	r.lastEntry = entry
+	r.count++
+	r.bytesRead += int64(entry.length)
+	return entry
 }
 
-// CurrentSegmentId returns the id of the current segment file
-// when reading the WAL.
-func (r *Reader) CurrentSegmentId() SegmentID {
-	return r.segmentReaders[r.currentReader].segment.id
+// releaseSegment releases the segment file of the segment reader once the reader is done with it,
+// the error of removing an evicted segment file is only logged, since the reading is not affected.
+func (r *Reader) releaseSegment(segReader *segmentReader) {
+	if err := segReader.release(); err != nil {
+		r.logger.Warnf("wal: failed to remove the evicted segment file %d: %v", segReader.segment.id, err)
+	}
 }
 
-// CurrentChunkPosition returns the position of the current chunk data
-func (r *Reader) CurrentChunkPosition() *ChunkPosition {
-	reader := r.segmentReaders[r.currentReader]
-	return &ChunkPosition{
-		SegmentId:   reader.segment.id,
-		BlockNumber: reader.blockNumber,
-		ChunkOffset: reader.chunkOffset,
+// releaseSegments releases the segment files of all the segment readers.
+func (r *Reader) releaseSegments() {
+	for _, segReader := range r.segmentReaders {
+		r.releaseSegment(segReader)
 	}
 }
 
-// ClearPendingWrites clear pendingWrite and reset pendingSize
-func (wal *WAL) ClearPendingWrites() {
-	wal.pendingWritesLock.Lock()
-	defer wal.pendingWritesLock.Unlock()
+// detach takes the segment readers not read to the end away from the reader,
+// along with the references to their segment files, the reader can not be used after that.
+func (r *Reader) detach() []*segmentReader {
+	if r.currentReader >= len(r.segmentReaders) {
+		return nil
+	}
+	segmentReaders := r.segmentReaders[r.currentReader:]
+	r.segmentReaders = r.segmentReaders[:r.currentReader]
+	return segmentReaders
+}
+
+// findSegment returns the segment file read by the reader by id, nil if not found.
+func (r *Reader) findSegment(id SegmentID) *segment {
+	return findReaderSegment(r.segmentReaders, id)
+}
+
+// findReaderSegment returns the segment file of the segment readers by id, nil if not found.
+func findReaderSegment(segmentReaders []*segmentReader, id SegmentID) *segment {
+	for _, segReader := range segmentReaders {
+		if segReader.segment.id == id {
+			return segReader.segment
+		}
+	}
+	return nil
+}
+
+// SetLimit sets the max number of entries the reader returns,
+// Next and NextPosition return io.EOF after n entries are returned since the reader is created,
+// regardless of the remaining data. There is no limit if n is zero or negative.
+func (r *Reader) SetLimit(n int) {
+	if n < 0 {
+		n = 0
+	}
+	r.limit = n
+}
+
+// SetByteBudget sets the max size in bytes of the data the reader returns,
+// Next and NextPosition return ErrByteBudgetExceeded once the total length of the returned data
+// reaches the budget. The entry crossing the budget is still returned entirely,
+// so the budget may be exceeded by at most one entry, and every call returns at least one entry.
+//
+// The reader is not advanced by ErrByteBudgetExceeded, so it can be resumed from
+// CurrentChunkPosition, or by calling SetByteBudget again, which resets the counted size.
+// There is no budget if n is zero or negative.
+func (r *Reader) SetByteBudget(n int64) {
+	if n < 0 {
+		n = 0
+	}
+	r.byteBudget = n
+	r.bytesRead = 0
+}
+
+// Timestamp returns the write timestamp of the chunk data last returned by Next.
+// It returns the zero time if the data is not written by WriteWithTime.
+func (r *Reader) Timestamp() time.Time {
+	return r.lastEntry.Time()
+}
+
+// NextPosition returns the position and the data length of the next chunk in the WAL,
+// it is like Next but the data is not copied out, which avoids the allocation.
+// If there is no data, io.EOF will be returned.
+//
+// It is useful to build an index of the WAL, which only needs the positions.
+func (r *Reader) NextPosition() (*ChunkPosition, int, error) {
+	entry, position, err := r.next(true)
+	return position, entry.length, err
+}
+
+// Peek returns the next entry data and its position like Next, but doesn't advance the reader,
+// so Peek returns the same entry until Next or NextPosition is called, which return it.
+// The current position of the reader is still the one of the peeked entry,
+// even if it is in the next segment file. The errors are not kept,
+// the following Peek or Next reads again after an error such as io.EOF.
+func (r *Reader) Peek() ([]byte, *ChunkPosition, error) {
+	if r.peeked == nil {
+		lastEntry, count, bytesRead := r.lastEntry, r.count, r.bytesRead
+		entry, position, err := r.next(false)
+		if err != nil {
+			return nil, nil, err
+		}
+		// the entry is accepted when it is returned by Next.
+		r.lastEntry, r.count, r.bytesRead = lastEntry, count, bytesRead
+		r.peeked = &txnEntry{entry: entry, position: position}
+	}
+	return r.peeked.entry.data, r.peeked.position, nil
+}
+
+// SkipCurrentSegment skips the current segment file
+// when reading the WAL.
+//
+// It is now used by the Merge operation of rosedb, not a common usage for most users.
+func (r *Reader) SkipCurrentSegment() {
+	r.peeked = nil
+	r.releaseSegment(r.segmentReaders[r.currentReader])
+	r.currentReader++
+}
+
+// CurrentSegmentId returns the id of the current segment file
+// when reading the WAL.
+func (r *Reader) CurrentSegmentId() SegmentID {
+	if r.peeked != nil {
+		return r.peeked.position.SegmentId
+	}
+	return r.segmentReaders[r.currentReader].segment.id
+}
+
+// CurrentChunkPosition returns the position of the current chunk data
+func (r *Reader) CurrentChunkPosition() *ChunkPosition {
+	if r.peeked != nil {
+		pos := r.peeked.position
+		return &ChunkPosition{SegmentId: pos.SegmentId, BlockNumber: pos.BlockNumber, ChunkOffset: pos.ChunkOffset}
+	}
+	reader := r.segmentReaders[r.currentReader]
+	return &ChunkPosition{
+		SegmentId:   reader.segment.id,
+		BlockNumber: reader.blockNumber,
+		ChunkOffset: reader.chunkOffset,
+	}
+}
+
+// Remaining returns an estimate of the number of entries and the size in bytes
+// the reader has not read yet, such as to show the progress of a long replay.
+//
+// The size is the remaining size of the segment files, including the chunk headers,
+// the padding and the footers, it is exact unless the active segment file is written meanwhile.
+// The number of entries is exact for the segment files not read yet whose entries are counted,
+// that is those with a footer or created by this WAL, which is the case of Len as well.
+// It is estimated from the remaining size of the segment file being read,
+// and of the segment files whose entries are not counted, with the average size of the counted entries,
+// so the entries are not estimated at all if none of the segment files are counted.
+// The committed entries pending in the reader and the peeked entry are counted,
+// and the number of entries is capped by the limit set by SetLimit.
+func (r *Reader) Remaining() (entries int, bytes int64) {
+	// the average size of the entries is taken from all the counted segment files, read or not.
+	var countedEntries, countedSize int64
+	for _, segReader := range r.segmentReaders {
+		if segReader.counted {
+			countedEntries += int64(segReader.entries)
+			countedSize += segReader.segment.writtenSize.Load()
+		}
+	}
+
+	var estimated int64
+	for _, segReader := range r.segmentReaders[r.currentReader:] {
+		size := segReader.segment.writtenSize.Load()
+		if segReader.bounded {
+			size = segReader.end
+		}
+		offset := int64(segReader.blockNumber)*blockSize + segReader.chunkOffset
+		if offset >= size {
+			continue
+		}
+		bytes += size - offset
+		switch {
+		case segReader.counted && offset == 0:
+			entries += segReader.entries
+		case countedSize > 0:
+			estimated += (size - offset) * countedEntries
+		}
+	}
+	if countedSize > 0 {
+		entries += int(estimated / countedSize)
+	}
+
+	entries += len(r.committed)
+	if r.peeked != nil {
+		entries++
+	}
+	if r.limit > 0 && entries > r.limit-r.count {
+		entries = r.limit - r.count
+	}
+	return entries, bytes
+}
+
+// ClearPendingWrites clear pendingWrite and reset pendingSize
+func (wal *WAL) ClearPendingWrites() {
+	wal.pendingWritesLock.Lock()
+	defer wal.pendingWritesLock.Unlock()
 
 	wal.pendingSize = 0
 	wal.pendingWrites = wal.pendingWrites[:0]
 }
 
 // PendingWrites add data to wal.pendingWrites and wait for batch write.
-// If the data in pendingWrites exceeds the size of one segment,
-// it will return a 'ErrPendingSizeTooLarge' error and clear the pendingWrites.
+// If the data in pendingWrites exceeds the max pending size,
+// WriteAll will return a 'ErrPendingSizeTooLarge' error and clear the pendingWrites.
 func (wal *WAL) PendingWrites(data []byte) {
 	wal.pendingWritesLock.Lock()
 	defer wal.pendingWritesLock.Unlock()
 
+	data = compress(data, wal.options.Compression.entryFlags())
 	size := wal.maxDataWriteSize(int64(len(data)))
 	wal.pendingSize += size
 	wal.pendingWrites = append(wal.pendingWrites, data)
 }
 
+// PendingSize returns the size of the data in pendingWrites,
+// it is the upper bound of the size it will take in the segment file,
+// including the chunk headers and paddings.
+func (wal *WAL) PendingSize() int64 {
+	wal.pendingWritesLock.Lock()
+	defer wal.pendingWritesLock.Unlock()
+	return wal.pendingSize
+}
+
+// maxPendingSize returns the upper bound of the size of pendingWrites.
+func (wal *WAL) maxPendingSize() int64 {
+	if wal.options.MaxPendingSize > 0 && wal.options.MaxPendingSize < wal.segmentCapacity() {
+		return wal.options.MaxPendingSize
+	}
+	return wal.segmentCapacity()
+}
+
+// unlock releases the WAL lock, and then calls options.OnRotate
+// for the rotations done with the lock held.
+// The rotateHookLock is acquired before releasing the WAL lock,
+// so the hooks are called one by one in the order of the rotations.
+func (wal *WAL) unlock() {
+	if len(wal.rotations) == 0 {
+		wal.mu.Unlock()
+		return
+	}
+	rotations := wal.rotations
+	wal.rotations = nil
+	wal.rotateHookLock.Lock()
+	wal.mu.Unlock()
+
+	defer wal.rotateHookLock.Unlock()
+	for _, r := range rotations {
+		wal.options.OnRotate(r.oldID, r.newID)
+	}
+}
+
 // rotateActiveSegment create a new segment file and replace the activeSegment.
 func (wal *WAL) rotateActiveSegment() error {
-	if err := wal.activeSegment.Sync(); err != nil {
+	return wal.rotate(true)
+}
+
+// rotate creates a new active segment file like rotateActiveSegment,
+// the old active segment file is not synced if sync is false.
+func (wal *WAL) rotate(sync bool) error {
+	if wal.readOnly {
+		return ErrReadOnly
+	}
+	// fail loudly instead of wrapping the segment id around to 0.
+	if wal.activeSegment.id == math.MaxUint32 {
+		return ErrSegmentIDOverflow
+	}
+	if err := wal.sealActiveSegment(sync); err != nil {
 		return err
 	}
+	if wal.options.MMapReads {
+		if err := wal.activeSegment.mmap(); err != nil {
+			return err
+		}
+	}
 	wal.bytesWrite = 0
 	segment, err := openSegmentFile(wal.options.DirPath, wal.options.SegmentFileExt,
-		wal.activeSegment.id+1)
+		wal.activeSegment.id+1, &wal.options)
 	if err != nil {
 		return err
 	}
-	wal.olderSegments[wal.activeSegment.id] = wal.activeSegment
+	sealed := wal.activeSegment
+	wal.olderSegments[sealed.id] = sealed
 	wal.activeSegment = segment
-	return nil
+	wal.dirDirty = true
+	wal.missingSegments = nil
+	wal.stats.rotationCount.Add(1)
+	wal.options.Logger.Debugf("wal: rotated the active segment file from %d to %d", sealed.id, segment.id)
+	if wal.options.OnRotate != nil {
+		wal.rotations = append(wal.rotations, rotation{oldID: sealed.id, newID: segment.id})
+	}
+
+	// the sealed segment file will never be modified.
+	// The rotation is done, so the error of the hook is only logged, not failing the write.
+	if wal.options.OnSegmentSealed != nil {
+		path := wal.segmentFileName(sealed.id)
+		if err := wal.options.OnSegmentSealed(sealed.id, path); err != nil {
+			wal.options.Logger.Errorf("wal: the segment sealed hook of %d%s failed: %v",
+				sealed.id, wal.options.SegmentFileExt, err)
+		}
+	}
+
+	// remove the oldest segment files if there are too many segment files.
+	return wal.evictSegmentsByCount()
 }
 
 // WriteAll write wal.pendingWrites to WAL and then clear pendingWrites,
 // it will not sync the segment file based on wal.options, you should call Sync() manually.
+//
+// If writing to the segment file fails partway, such as the disk is full,
+// the entries written completely are valid and can be read and iterated,
+// their positions are returned along with the error, which are the first entries of the batch.
+// The partially written entry is truncated from the segment file,
+// so the segment file is still valid for the following writes.
+// If options.AtomicWriteAll is true, the whole batch is truncated instead and no position is returned.
 func (wal *WAL) WriteAll() ([]*ChunkPosition, error) {
 	if len(wal.pendingWrites) == 0 {
 		return make([]*ChunkPosition, 0), nil
 	}
 
-	wal.mu.Lock()
+	wal.lock()
 	defer func() {
 		wal.ClearPendingWrites()
-		wal.mu.Unlock()
+		wal.unlock()
 	}()
 
-	// if the pending size is still larger than segment size, return error
-	if wal.pendingSize > wal.options.SegmentSize {
+	if wal.readOnly {
+		return nil, ErrReadOnly
+	}
+	// if the pending size is larger than the max pending size, return error
+	if wal.pendingSize > wal.maxPendingSize() {
 		return nil, ErrPendingSizeTooLarge
 	}
 
 	// if the active segment file is full, sync it and create a new one.
-	if wal.activeSegment.Size()+wal.pendingSize > wal.options.SegmentSize {
+	if wal.activeSegment.Size()+wal.pendingSize > wal.segmentCapacity() {
 		if err := wal.rotateActiveSegment(); err != nil {
 			return nil, err
 		}
 	}
 
-	// write all data to the active segment file.
-	positions, err := wal.activeSegment.writeAll(wal.pendingWrites)
-	if err != nil {
+	// remove the oldest segment files if the total size limit would be exceeded.
+	if err := wal.evictSegmentsBySize(wal.pendingSize); err != nil {
 		return nil, err
 	}
 
-	return positions, nil
+	// write all data to the active segment file,
+	// the positions of the entries written completely are returned even if it fails.
+	sizeBefore := wal.activeSegment.Size()
+	positions, err := wal.activeSegment.writeAll(wal.pendingWrites,
+		wal.options.Compression.entryFlags(), wal.options.AtomicWriteAll)
+	if err != nil {
+		wal.options.Logger.Errorf("wal: write %d entries to segment file %d failed, %d are written: %v",
+			len(wal.pendingWrites), wal.activeSegment.id, len(positions), err)
+	}
+	if len(positions) > 0 {
+		wal.stats.addWrites(len(positions), wal.activeSegment.Size()-sizeBefore)
+		wal.lastWrite = newWriteStats(positions, wal.activeSegment.Size()-sizeBefore)
+		wal.notify()
+	}
+
+	return positions, err
 }
 
 // Write writes the data to the WAL.
 // Actually, it writes the data to the active segment file.
 // It returns the position of the data in the WAL, and an error if any.
 func (wal *WAL) Write(data []byte) (*ChunkPosition, error) {
-	wal.mu.Lock()
-	defer wal.mu.Unlock()
-	if int64(len(data))+chunkHeaderSize > wal.options.SegmentSize {
-		return nil, ErrValueTooLarge
+	flags := wal.options.Compression.entryFlags()
+	return wal.write(compress(data, flags), flags)
+}
+
+// WriteWithEnd writes the data to the WAL like Write, and returns the end position
+// of the data along with the start one, which is the position immediately after the data
+// in the same segment file, so [start, end) is the range the data takes up.
+// The end position is where the next entry is written, unless the segment file is rotated.
+func (wal *WAL) WriteWithEnd(data []byte) (*ChunkPosition, *ChunkPosition, error) {
+	pos, err := wal.Write(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pos, pos.end(), nil
+}
+
+// WriteWithTime writes the data to the WAL along with the timestamp,
+// the timestamp takes 8 more bytes in the segment file.
+// You can get the timestamp by Reader.Timestamp() or WAL.ReadWithTime().
+//
+// Notice that the data written by WriteWithTime can not be read by
+// the older versions of the WAL.
+func (wal *WAL) WriteWithTime(data []byte, t time.Time) (*ChunkPosition, error) {
+	flags := entryFlagTimestamp | wal.options.Compression.entryFlags()
+	return wal.write(encodeEntryPrefix(compress(data, flags), flags, t), flags)
+}
+
+// WriteIf writes the data to the WAL like Write, but only if the position of the last entry
+// in the WAL is expectedLast, otherwise nothing is written and ErrPositionConflict is returned.
+// The check and the write are done with the WAL lock held, so a writer passing the position
+// of its own last write detects that another writer has written since then.
+// The expectedLast is nil if the WAL is expected to be empty, see LastPosition.
+// The positions are compared by the segment id, the block number and the chunk offset.
+func (wal *WAL) WriteIf(expectedLast *ChunkPosition, data []byte) (*ChunkPosition, error) {
+	flags := wal.options.Compression.entryFlags()
+	data = compress(data, flags)
+
+	wal.lock()
+	defer wal.unlock()
+	last, err := wal.lastEntryPosition()
+	if err != nil {
+		return nil, err
+	}
+	if (last == nil) != (expectedLast == nil) ||
+		last != nil && (last.SegmentId != expectedLast.SegmentId || chunkOffset(last) != chunkOffset(expectedLast)) {
+		return nil, ErrPositionConflict
+	}
+	return wal.writeData(data, flags)
+}
+
+// LastPosition returns the position of the last entry in the WAL, the internal entries,
+// such as the markers written by WriteMarker, are not considered.
+// It returns nil if there is no entry in the segment files still in the WAL.
+//
+// The position is known without reading the segment files if the last entry is written
+// since the WAL is opened, or its segment file has a footer, otherwise the segment file is scanned.
+func (wal *WAL) LastPosition() (*ChunkPosition, error) {
+	wal.mu.RLock()
+	defer wal.mu.RUnlock()
+	return wal.lastEntryPosition()
+}
+
+// lastEntryPosition returns the position of the last entry in the WAL, or nil if there is none.
+// It must be called with the WAL lock held.
+func (wal *WAL) lastEntryPosition() (*ChunkPosition, error) {
+	ids := append(wal.sortedOlderSegmentIDs(), wal.activeSegment.id)
+	for i := len(ids) - 1; i >= 0; i-- {
+		seg := wal.findSegment(ids[i])
+		index := seg.index
+		// the last entry is recorded by the index if it has any entry,
+		// unless the index is loaded from a version 1 footer.
+		if index.entries > 0 && !index.noRange {
+			return &ChunkPosition{SegmentId: seg.id, BlockNumber: index.lastBlock, ChunkOffset: index.lastOffset}, nil
+		}
+		if index.complete && !index.noRange {
+			continue
+		}
+		var last *ChunkPosition
+		err := wal.newSegmentReader(seg).scan(func(pos *ChunkPosition, _ *chunkEntry) {
+			last = pos
+		})
+		if err != nil {
+			return nil, err
+		}
+		if last != nil {
+			return last, nil
+		}
+	}
+	return nil, nil
+}
+
+// write writes the data with the given entry flags to the WAL,
+// the data must already contain the prefix required by the flags.
+func (wal *WAL) write(data []byte, flags byte) (*ChunkPosition, error) {
+	wal.lock()
+	defer wal.unlock()
+	return wal.writeData(data, flags)
+}
+
+// writeData writes the data with the given entry flags to the WAL like write,
+// it must be called with the WAL lock held.
+func (wal *WAL) writeData(data []byte, flags byte) (*ChunkPosition, error) {
+	if int64(len(data))+chunkHeaderSize > wal.segmentCapacity() {
+		if !wal.options.AllowSegmentSpanning {
+			return nil, ErrValueTooLarge
+		}
+		return wal.writeSpan(data, flags)
+	}
+	return wal.writeLocked(data, flags)
+}
+
+// writeLocked writes the data which fits in a segment file to the WAL,
+// it must be called with the WAL lock held.
+func (wal *WAL) writeLocked(data []byte, flags byte) (*ChunkPosition, error) {
+	if wal.readOnly {
+		return nil, ErrReadOnly
 	}
 	// if the active segment file is full, sync it and create a new one.
 	if wal.isFull(int64(len(data))) {
@@ -398,49 +1435,350 @@ func (wal *WAL) Write(data []byte) (*ChunkPosition, error) {
 		}
 	}
 
+	// remove the oldest segment files if the total size limit would be exceeded.
+	if err := wal.evictSegmentsBySize(wal.maxDataWriteSize(int64(len(data)))); err != nil {
+		return nil, err
+	}
+
 	// write the data to the active segment file.
-	position, err := wal.activeSegment.Write(data)
+	sizeBefore := wal.activeSegment.Size()
+	position, err := wal.activeSegment.writeWithFlags(data, flags)
 	if err != nil {
+		wal.options.Logger.Errorf("wal: write to segment file %d failed: %v", wal.activeSegment.id, err)
 		return nil, err
 	}
+	wal.stats.addWrites(1, wal.activeSegment.Size()-sizeBefore)
+	wal.lastWrite = newWriteStats([]*ChunkPosition{position}, wal.activeSegment.Size()-sizeBefore)
 
 	// update the bytesWrite field.
 	wal.bytesWrite += position.ChunkSize
 
 	// sync the active segment file if needed.
-	var needSync = wal.options.Sync
-	if !needSync && wal.options.BytesPerSync > 0 {
-		needSync = wal.bytesWrite >= wal.options.BytesPerSync
-	}
-	if needSync {
-		if err := wal.activeSegment.Sync(); err != nil {
+	if wal.syncPolicy.shouldSync(wal.bytesWrite) {
+		if err := wal.syncActiveSegment(); err != nil {
 			return nil, err
 		}
 		wal.bytesWrite = 0
 	}
+	wal.notify()
 
 	return position, nil
 }
 
 // Read reads the data from the WAL according to the given position.
+// The WAL lock is only held to find the segment file, not to read the data,
+// so reading large data doesn't block the writes and rotations.
+// It is safe to call Read concurrently with Write, the data is visible
+// as soon as the Write returns, and ErrClosed is returned if the segment file
+// is removed by retention or the WAL is closed during the read.
+//
+// If the chunks of the entry end before its last chunk, such as the entry is torn by a crash,
+// the data of the chunks read so far is returned along with ErrIncompleteEntry,
+// which is not decompressed if the entry is compressed.
 func (wal *WAL) Read(pos *ChunkPosition) ([]byte, error) {
+	entry, err := wal.readEntry(context.Background(), pos)
+	return entry.data, err
+}
+
+// ReadContext is like Read, but the context is checked before reading every block
+// of the data, so reading the data spanning many blocks returns the context error
+// promptly if the context is cancelled.
+func (wal *WAL) ReadContext(ctx context.Context, pos *ChunkPosition) ([]byte, error) {
+	entry, err := wal.readEntry(ctx, pos)
+	return entry.data, err
+}
+
+// ReadPooled reads the data like Read, but into a buffer taken from an internal pool,
+// the returned release function puts the buffer back to the pool, which saves the allocations
+// and the GC pressure of the reads on a hot path where the data is used briefly.
+// The returned data is invalid after release is called, since the buffer is reused by
+// the other reads, so it must not be kept or used after that, copy it to keep it.
+// The release function is never nil and does nothing after the first call, or if an error is returned.
+func (wal *WAL) ReadPooled(pos *ChunkPosition) ([]byte, func(), error) {
+	buf := getDataBuffer()
+	entry, err := wal.readEntryInto(context.Background(), pos, buf)
+	if err != nil {
+		putDataBuffer(buf)
+		return nil, func() {}, err
+	}
+	var released bool
+	release := func() {
+		if !released {
+			released = true
+			// the data may be in a larger buffer, if it outgrows buf or is decompressed.
+			putDataBuffer(entry.data)
+		}
+	}
+	return entry.data, release, nil
+}
+
+// ReadWithTime reads the data and its write timestamp from the WAL according to the given position.
+// The timestamp is the zero time if the data is not written by WriteWithTime.
+func (wal *WAL) ReadWithTime(pos *ChunkPosition) ([]byte, time.Time, error) {
+	entry, err := wal.readEntry(context.Background(), pos)
+	return entry.data, entry.Time(), err
+}
+
+// ReadHeader reads the header of the chunk at the position without reading its payload,
+// such as to inspect the chunks of an entry spanning many blocks, which are read
+// one by one from the position returned by a write or a reader.
+// It returns the chunk type, the length of the payload and the checksum stored in the header,
+// the checksum is not verified since the payload is not read.
+// The chunk type doesn't include the entry flags, so the internal entries, such as the footers
+// and the markers written by WriteMarker, are reported as ChunkTypeFull or the other chunk types too.
+//
+// A FIRST or MIDDLE chunk is followed by the chunk at the start of the next block,
+// and a FULL or LAST chunk by the chunk after its payload, or at the start of the next block
+// if the rest of the block is padding, which is too short for a chunk header.
+// io.EOF is returned if there is no chunk at the position.
+func (wal *WAL) ReadHeader(pos *ChunkPosition) (chunkType ChunkType, length uint16, crc uint32, err error) {
+	segment, err := wal.readSegment(pos)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	h, err := segment.readHeader(pos.BlockNumber, pos.ChunkOffset)
+	return h.chunkType, h.length, h.crc, err
+}
+
+// readEntry reads the entry from the WAL according to the given position.
+func (wal *WAL) readEntry(ctx context.Context, pos *ChunkPosition) (chunkEntry, error) {
+	return wal.readEntryInto(ctx, pos, nil)
+}
+
+// readEntryInto is like readEntry, but the data is read into buf if it is large enough.
+func (wal *WAL) readEntryInto(ctx context.Context, pos *ChunkPosition, buf []byte) (chunkEntry, error) {
+	segment, err := wal.readSegment(pos)
+	if err != nil {
+		return chunkEntry{}, err
+	}
+
+	// read the data from the segment file,
+	// ErrClosed is returned if it is removed or closed during the read.
+	opts := readOptions{
+		skipChecksum: wal.options.SkipChecksumOnRead,
+		ctx:          ctx,
+		buf:          buf,
+	}
+	entry, err := segment.readInternal(pos.BlockNumber, pos.ChunkOffset, opts)
+	if err == nil && entry.isMarker() {
+		entry.stripMarkerKind()
+	}
+	if err != nil || entry.flags&entryFlagSpan == 0 {
+		return entry, err
+	}
+	// the fragments are appended to the data of the first one.
+	opts.buf = nil
+	return readSpan(entry, wal.findSpanSegment, opts)
+}
+
+// readSegment returns the segment file to read the position from,
+// the segment file is loaded by the SegmentLoader if it is not in the WAL,
+// and the write buffer is flushed if the position is still in it.
+func (wal *WAL) readSegment(pos *ChunkPosition) (*segment, error) {
+	// find the segment file according to the position,
+	// the lock is only held to find it, not to read it.
 	wal.mu.RLock()
-	defer wal.mu.RUnlock()
+	segment := wal.findSegment(pos.SegmentId)
+	truncated := segment == nil && pos.SegmentId < wal.firstSegmentID()
+	trimmed := wal.isTrimmed(pos)
+	wal.mu.RUnlock()
+
+	// the entries before the start position set by TrimFront are not readable.
+	if trimmed {
+		return nil, wal.trimmedError(pos)
+	}
 
-	// find the segment file according to the position.
-	var segment *segment
-	if pos.SegmentId == wal.activeSegment.id {
-		segment = wal.activeSegment
-	} else {
-		segment = wal.olderSegments[pos.SegmentId]
+	if segment == nil && wal.options.SegmentLoader != nil {
+		// load the segment file with the write lock held.
+		wal.mu.Lock()
+		segment = wal.findSegment(pos.SegmentId)
+		if segment == nil {
+			var err error
+			if segment, err = wal.loadSegment(pos.SegmentId); err != nil {
+				wal.mu.Unlock()
+				return nil, err
+			}
+		}
+		wal.mu.Unlock()
 	}
 
 	if segment == nil {
-		return nil, fmt.Errorf("segment file %d%s not found", pos.SegmentId, wal.options.SegmentFileExt)
+		// the segment files lower than the first one have been removed by retention.
+		if truncated {
+			return nil, fmt.Errorf("segment file %d%s: %w", pos.SegmentId, wal.options.SegmentFileExt, ErrPositionTruncated)
+		}
+		return nil, fmt.Errorf("segment file %d%s: %w", pos.SegmentId, wal.options.SegmentFileExt, ErrSegmentNotFound)
+	}
+
+	// the entry is still in the write buffer of the active segment, flush it first.
+	if segment.isBuffered(pos) {
+		wal.mu.Lock()
+		err := segment.Flush()
+		wal.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return segment, nil
+}
+
+// findSpanSegment returns the segment file holding the fragments of a spanning entry,
+// the write buffer of it is flushed so the fragments can be read.
+func (wal *WAL) findSpanSegment(id SegmentID) *segment {
+	wal.mu.RLock()
+	seg := wal.findSegment(id)
+	wal.mu.RUnlock()
+	if seg != nil && seg.isBuffered(&ChunkPosition{}) {
+		wal.mu.Lock()
+		_ = seg.Flush()
+		wal.mu.Unlock()
+	}
+	return seg
+}
+
+// ReadBatch reads the data of many positions at once, and returns them in the input order.
+// The segment files of all the positions are found with the lock held only once,
+// and the positions are read in the order of the segment ids and the offsets for locality.
+// If any position fails to be read, the error of it is returned along with its index.
+func (wal *WAL) ReadBatch(positions []*ChunkPosition) ([][]byte, error) {
+	order := make([]int, len(positions))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := positions[order[i]], positions[order[j]]
+		if a.SegmentId != b.SegmentId {
+			return a.SegmentId < b.SegmentId
+		}
+		if a.BlockNumber != b.BlockNumber {
+			return a.BlockNumber < b.BlockNumber
+		}
+		return a.ChunkOffset < b.ChunkOffset
+	})
+
+	wal.mu.RLock()
+	segments := make(map[SegmentID]*segment)
+	trimmed := -1
+	for i, pos := range positions {
+		if seg := wal.findSegment(pos.SegmentId); seg != nil {
+			segments[pos.SegmentId] = seg
+		}
+		if trimmed < 0 && wal.isTrimmed(pos) {
+			trimmed = i
+		}
 	}
+	wal.mu.RUnlock()
 
-	// read the data from the segment file.
-	return segment.Read(pos.BlockNumber, pos.ChunkOffset)
+	// the entries before the start position set by TrimFront are not readable, like Read.
+	if trimmed >= 0 {
+		return nil, fmt.Errorf("read the position at index %d failed: %w", trimmed, wal.trimmedError(positions[trimmed]))
+	}
+
+	results := make([][]byte, len(positions))
+	for _, i := range order {
+		pos := positions[i]
+		var (
+			entry chunkEntry
+			err   error
+		)
+		seg := segments[pos.SegmentId]
+		if seg == nil || seg.isBuffered(pos) {
+			// readEntry loads the segment file or flushes the write buffer.
+			entry, err = wal.readEntry(context.Background(), pos)
+		} else {
+			opts := readOptions{skipChecksum: wal.options.SkipChecksumOnRead}
+			entry, err = seg.readInternal(pos.BlockNumber, pos.ChunkOffset, opts)
+			if err == nil && entry.isMarker() {
+				entry.stripMarkerKind()
+			}
+			if err == nil && entry.flags&entryFlagSpan != 0 {
+				entry, err = readSpan(entry, wal.findSpanSegment, opts)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read the position at index %d failed: %w", i, err)
+		}
+		results[i] = entry.data
+	}
+	return results, nil
+}
+
+// findSegment returns the segment file by id, including the loaded ones,
+// and nil if not found. It must be called with the WAL lock held.
+func (wal *WAL) findSegment(id SegmentID) *segment {
+	if id == wal.activeSegment.id {
+		return wal.activeSegment
+	}
+	if seg, ok := wal.olderSegments[id]; ok {
+		return seg
+	}
+	return wal.loadedSegments[id]
+}
+
+// firstSegmentID returns the lowest id of the segment files in the directory.
+func (wal *WAL) firstSegmentID() SegmentID {
+	id := wal.activeSegment.id
+	for segId := range wal.olderSegments {
+		if segId < id {
+			id = segId
+		}
+	}
+	return id
+}
+
+// Checkpoint returns the position recorded by the last completed sync,
+// all the data before the position has been synced to stable storage.
+// It returns nil if there is no checkpoint file in the directory.
+//
+// The checkpoint file is only written if options.EnableCheckpoint is true.
+func (wal *WAL) Checkpoint() (*ChunkPosition, error) {
+	return readCheckpoint(wal.options.FileSystem, wal.options.DirPath)
+}
+
+// Notify returns a channel that receives a signal after data is written
+// by Write, WriteWithTime or WriteAll, so the consumers don't need to poll the WAL.
+// The signals are coalesced, one signal may stand for many writes,
+// so the consumer should read all the new data by a reader when woken up.
+// Every call returns a new channel, so each consumer should call it once.
+// The channel is closed when the WAL is closed.
+func (wal *WAL) Notify() <-chan struct{} {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	notifyC := make(chan struct{}, 1)
+	select {
+	case <-wal.closeC:
+		close(notifyC)
+	default:
+		wal.notifyChans = append(wal.notifyChans, notifyC)
+	}
+	return notifyC
+}
+
+// notify signals all the notification channels without blocking,
+// the signal is dropped if the channel already has one.
+func (wal *WAL) notify() {
+	for _, notifyC := range wal.notifyChans {
+		select {
+		case notifyC <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Stats returns the statistics of the WAL since it was opened.
+func (wal *WAL) Stats() Stats {
+	return wal.stats.snapshot()
+}
+
+// LastWriteStats returns the space taken up by the last successful write to the WAL,
+// which may be done by another goroutine, that is how many bytes of the chunk headers and the
+// block paddings it costs beyond the data. The segment footer written by a rotation is not included.
+// The zero WriteStats is returned if nothing is written since the WAL was opened.
+func (wal *WAL) LastWriteStats() WriteStats {
+	wal.mu.RLock()
+	defer wal.mu.RUnlock()
+	return wal.lastWrite
 }
 
 // Close closes the WAL.
@@ -455,6 +1793,12 @@ func (wal *WAL) Close() error {
 		close(wal.closeC)
 	}
 
+	// close all notification channels.
+	for _, notifyC := range wal.notifyChans {
+		close(notifyC)
+	}
+	wal.notifyChans = nil
+
 	// close all segment files.
 	for _, segment := range wal.olderSegments {
 		if err := segment.Close(); err != nil {
@@ -464,9 +1808,26 @@ func (wal *WAL) Close() error {
 	}
 	wal.olderSegments = nil
 
+	// close all loaded segment files.
+	for _, segment := range wal.loadedSegments {
+		if err := segment.Close(); err != nil {
+			return err
+		}
+	}
+	wal.loadedSegments = nil
+
 	wal.renameIds = append(wal.renameIds, wal.activeSegment.id)
 	// close the active segment file.
-	return wal.activeSegment.Close()
+	if err := wal.activeSegment.Close(); err != nil {
+		return err
+	}
+	if err := wal.syncDir(); err != nil {
+		return err
+	}
+	if wal.singleFile != nil {
+		return wal.singleFile.close()
+	}
+	return nil
 }
 
 // Delete deletes all segment files of the WAL.
@@ -474,6 +1835,10 @@ func (wal *WAL) Delete() error {
 	wal.mu.Lock()
 	defer wal.mu.Unlock()
 
+	if wal.readOnly {
+		return ErrReadOnly
+	}
+
 	// delete all segment files.
 	for _, segment := range wal.olderSegments {
 		if err := segment.Remove(); err != nil {
@@ -482,16 +1847,164 @@ func (wal *WAL) Delete() error {
 	}
 	wal.olderSegments = nil
 
+	// delete the checkpoint file and the trim file.
+	if err := removeCheckpoint(wal.options.FileSystem, wal.options.DirPath); err != nil {
+		return err
+	}
+	if wal.trimStart != nil {
+		if err := removePositionFile(wal.options.FileSystem, wal.trimFilePath()); err != nil {
+			return err
+		}
+	}
+
 	// delete the active segment file.
-	return wal.activeSegment.Remove()
+	if err := wal.activeSegment.Remove(); err != nil {
+		return err
+	}
+	if wal.singleFile != nil {
+		return wal.singleFile.remove()
+	}
+	return nil
+}
+
+// Flush writes the data buffered in the user space to the operating system,
+// the flushed data survives a process crash, but not a machine crash,
+// since it may still be in the page cache, call Sync to make it durable.
+// Sync flushes the data before syncing it, so there is no need to call both.
+func (wal *WAL) Flush() error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	return wal.activeSegment.Flush()
 }
 
 // Sync syncs the active segment file to stable storage like disk.
+// If segment files are created since the last Sync or Close, such as by the rotations
+// of a bulk load, the directory is synced too, so the new segment files survive a machine crash.
+// The directory is synced once for all of them, instead of once per segment file.
 func (wal *WAL) Sync() error {
 	wal.mu.Lock()
 	defer wal.mu.Unlock()
 
-	return wal.activeSegment.Sync()
+	if err := wal.syncActiveSegment(); err != nil {
+		return err
+	}
+	return wal.syncDir()
+}
+
+// syncDir syncs the directory if segment files are created since it is synced last,
+// so their directory entries are durable. It must be called with the WAL lock held.
+func (wal *WAL) syncDir() error {
+	if !wal.dirDirty {
+		return nil
+	}
+	if err := syncDir(wal.options.FileSystem, wal.options.DirPath); err != nil {
+		wal.options.Logger.Errorf("wal: sync directory %s failed: %v", wal.options.DirPath, err)
+		return err
+	}
+	wal.dirDirty = false
+	return nil
+}
+
+// SetSync changes options.Sync at runtime, such as disabling it during a bulk load.
+// If it is enabled, the active segment file is synced immediately,
+// so the data written before is as durable as the following writes.
+//
+// Options.SyncPolicy is combined with options.Sync and is not changed by it, so disabling it
+// takes no effect if Options.SyncPolicy is SyncAlways, and ErrSyncPolicyOverride is returned.
+func (wal *WAL) SetSync(sync bool) error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	if !sync && wal.options.SyncPolicy.Always() {
+		return ErrSyncPolicyOverride
+	}
+	wal.options.Sync = sync
+	wal.syncPolicy = wal.options.syncPolicy()
+	if !sync {
+		return nil
+	}
+	if err := wal.syncActiveSegment(); err != nil {
+		return err
+	}
+	wal.bytesWrite = 0
+	return nil
+}
+
+// SetBytesPerSync changes options.BytesPerSync at runtime, zero disables it.
+// If the bytes written since the last sync already reach it,
+// the active segment file is synced immediately.
+//
+// Like SetSync, Options.SyncPolicy is not changed by it, so ErrSyncPolicyOverride is returned
+// if Options.SyncPolicy is SyncAlways or syncs after fewer bytes than bytesPerSync.
+func (wal *WAL) SetBytesPerSync(bytesPerSync uint32) error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	policy := wal.options.SyncPolicy
+	if policy.Always() || policy.Bytes() > 0 && (bytesPerSync == 0 || bytesPerSync > policy.Bytes()) {
+		return ErrSyncPolicyOverride
+	}
+	wal.options.BytesPerSync = bytesPerSync
+	wal.syncPolicy = wal.options.syncPolicy()
+	if wal.bytesWrite == 0 || !wal.syncPolicy.shouldSync(wal.bytesWrite) {
+		return nil
+	}
+	if err := wal.syncActiveSegment(); err != nil {
+		return err
+	}
+	wal.bytesWrite = 0
+	return nil
+}
+
+// sealActiveSegment writes the footer to the active segment file and syncs it if sync is true,
+// it is called before the active segment file is replaced by a new one.
+func (wal *WAL) sealActiveSegment(sync bool) error {
+	sizeBefore := wal.activeSegment.Size()
+	if err := wal.activeSegment.writeFooter(); err != nil {
+		return err
+	}
+	wal.stats.bytesWritten.Add(uint64(wal.activeSegment.Size() - sizeBefore))
+	if sync {
+		if err := wal.syncActiveSegment(); err != nil {
+			return err
+		}
+	} else {
+		// the buffered data and the final name are still required without the sync.
+		if err := wal.activeSegment.Flush(); err != nil {
+			return err
+		}
+		if err := wal.activeSegment.finalize(); err != nil {
+			return err
+		}
+	}
+	// the write buffer is flushed, and never used again.
+	wal.activeSegment.writeBuffer = nil
+	return nil
+}
+
+// syncActiveSegment syncs the active segment file,
+// and records the synced position in the checkpoint file if enabled.
+func (wal *WAL) syncActiveSegment() error {
+	start := time.Now()
+	err := wal.activeSegment.Sync()
+	elapsed := time.Since(start)
+	if wal.options.OnSync != nil {
+		wal.options.OnSync(elapsed, err)
+	}
+	if err != nil {
+		wal.options.Logger.Errorf("wal: sync segment file %d failed: %v", wal.activeSegment.id, err)
+		return err
+	}
+	wal.stats.addSync(elapsed)
+	if !wal.options.EnableCheckpoint {
+		return nil
+	}
+	return writeCheckpoint(wal.options.FileSystem, wal.options.DirPath, wal.options.FileMode, &ChunkPosition{
+		SegmentId:   wal.activeSegment.id,
+		BlockNumber: wal.activeSegment.currentBlockNumber,
+		ChunkOffset: int64(wal.activeSegment.currentBlockSize),
+	})
 }
 
 // RenameFileExt renames all segment files' extension name.
@@ -500,13 +2013,16 @@ func (wal *WAL) RenameFileExt(ext string) error {
 	if !strings.HasPrefix(ext, ".") {
 		return fmt.Errorf("segment file extension must start with '.'")
 	}
+	if wal.options.SegmentNameFunc != nil {
+		return fmt.Errorf("can't rename the extension of the segment files named by segment name func")
+	}
 	wal.mu.Lock()
 	defer wal.mu.Unlock()
 
 	renameFile := func(id SegmentID) error {
 		oldName := SegmentFileName(wal.options.DirPath, wal.options.SegmentFileExt, id)
 		newName := SegmentFileName(wal.options.DirPath, ext, id)
-		return os.Rename(oldName, newName)
+		return wal.options.FileSystem.Rename(oldName, newName)
 	}
 
 	for _, id := range wal.renameIds {
@@ -520,7 +2036,14 @@ func (wal *WAL) RenameFileExt(ext string) error {
 }
 
 func (wal *WAL) isFull(delta int64) bool {
-	return wal.activeSegment.Size()+wal.maxDataWriteSize(delta) > wal.options.SegmentSize
+	return wal.activeSegment.Size()+wal.maxDataWriteSize(delta) > wal.segmentCapacity()
+}
+
+// segmentCapacity returns the space of the entries in a segment file, that is, options.SegmentSize
+// less the largest footer, which is appended when it is sealed, so the sealed segment files
+// are never larger than options.SegmentSize.
+func (wal *WAL) segmentCapacity() int64 {
+	return wal.options.SegmentSize - wal.maxDataWriteSize(maxFooterSize(wal.options.SegmentSize))
 }
 
 // maxDataWriteSize calculate the possible maximum size.
@@ -528,3 +2051,72 @@ func (wal *WAL) isFull(delta int64) bool {
 func (wal *WAL) maxDataWriteSize(size int64) int64 {
 	return chunkHeaderSize + size + (size/blockSize+1)*chunkHeaderSize
 }
+
+// evictSegmentsBySize removes the oldest segment files until the total size
+// of all segment files plus delta is not larger than options.MaxTotalSize.
+// The active segment file is never removed.
+func (wal *WAL) evictSegmentsBySize(delta int64) error {
+	if wal.options.MaxTotalSize <= 0 {
+		return nil
+	}
+
+	totalSize := wal.activeSegment.Size() + delta
+	for _, seg := range wal.olderSegments {
+		totalSize += seg.Size()
+	}
+
+	for _, id := range wal.sortedOlderSegmentIDs() {
+		if totalSize <= wal.options.MaxTotalSize {
+			break
+		}
+		seg := wal.olderSegments[id]
+		totalSize -= seg.Size()
+		if err := wal.evictSegment(seg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evictSegmentsByCount removes the oldest segment files until the number
+// of segment files is not larger than options.MaxSegments.
+// The active segment file is never removed.
+func (wal *WAL) evictSegmentsByCount() error {
+	if wal.options.MaxSegments <= 0 {
+		return nil
+	}
+
+	ids := wal.sortedOlderSegmentIDs()
+	for len(ids)+1 > wal.options.MaxSegments && len(ids) > 0 {
+		if err := wal.evictSegment(wal.olderSegments[ids[0]]); err != nil {
+			return err
+		}
+		ids = ids[1:]
+	}
+	return nil
+}
+
+// evictSegment removes an older segment file from the WAL and the disk,
+// the new readers don't see it, and it is removed from the disk after the readers reading it release it.
+func (wal *WAL) evictSegment(seg *segment) error {
+	delete(wal.olderSegments, seg.id)
+	if err := seg.evict(); err != nil {
+		return err
+	}
+	if wal.options.OnSegmentEvicted != nil {
+		wal.options.OnSegmentEvicted(seg.id)
+	}
+	return nil
+}
+
+// sortedOlderSegmentIDs returns the ids of the older segment files in ascending order.
+func (wal *WAL) sortedOlderSegmentIDs() []SegmentID {
+	ids := make([]SegmentID, 0, len(wal.olderSegments))
+	for id := range wal.olderSegments {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
@@ -1,10 +1,19 @@
 package wal
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -118,6 +127,37 @@ func TestWAL_OpenNewActiveSegment(t *testing.T) {
 	}
 }
 
+func TestWAL_OpenNewActiveSegmentWithoutSync(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-new-active-segment-without-sync")
+	opts := Options{
+		DirPath:          dir,
+		SegmentFileExt:   ".SEG",
+		SegmentSize:      MB,
+		TempSegmentFiles: true,
+		WriteBufferSize:  4 * KB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	pos, err := wal.Write([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Nil(t, wal.OpenNewActiveSegmentWithoutSync())
+	assert.Equal(t, SegmentID(2), wal.ActiveSegmentID())
+	assert.Equal(t, uint64(0), wal.Stats().SyncCount)
+
+	// the sealed segment file is flushed and has the final name.
+	_, err = os.Stat(SegmentFileName(dir, ".SEG", 1))
+	assert.Nil(t, err)
+	assert.True(t, wal.olderSegments[1].hasFooter)
+	val, err := wal.Read(pos)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(val))
+
+	assert.Nil(t, wal.OpenNewActiveSegment())
+	assert.Equal(t, uint64(1), wal.Stats().SyncCount)
+}
+
 func TestWAL_IsEmpty(t *testing.T) {
 	dir, _ := os.MkdirTemp("", "wal-test-is-empty")
 	opts := Options{
@@ -323,3 +363,2244 @@ func TestWAL_RenameFileExt(t *testing.T) {
 		assert.Nil(t, err)
 	}
 }
+
+func TestWAL_MaxTotalSize(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-max-total-size")
+	var evicted []SegmentID
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		MaxTotalSize:   4 * MB,
+		OnSegmentEvicted: func(segId SegmentID) {
+			evicted = append(evicted, segId)
+		},
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	val := []byte(strings.Repeat("wal", 512))
+	first, err := wal.Write(val)
+	assert.Nil(t, err)
+	for i := 0; i < 20000; i++ {
+		_, err := wal.Write(val)
+		assert.Nil(t, err)
+
+		var totalSize = wal.activeSegment.Size()
+		for _, seg := range wal.olderSegments {
+			totalSize += seg.Size()
+		}
+		assert.LessOrEqual(t, totalSize, opts.MaxTotalSize)
+	}
+
+	assert.NotEmpty(t, evicted)
+	for i, id := range evicted {
+		assert.Equal(t, SegmentID(i+1), id)
+		assert.NotEqual(t, wal.ActiveSegmentID(), id)
+		_, err := os.Stat(SegmentFileName(dir, ".SEG", id))
+		assert.True(t, os.IsNotExist(err))
+	}
+	_, err = wal.Read(first)
+	assert.NotNil(t, err)
+}
+
+func TestWAL_MaxSegments(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-max-segments")
+	var evicted []SegmentID
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		MaxSegments:    3,
+		OnSegmentEvicted: func(segId SegmentID) {
+			evicted = append(evicted, segId)
+		},
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	val := []byte(strings.Repeat("wal", 512))
+	for i := 0; i < 10000; i++ {
+		_, err := wal.Write(val)
+		assert.Nil(t, err)
+		assert.LessOrEqual(t, len(wal.olderSegments)+1, opts.MaxSegments)
+	}
+	assert.Nil(t, wal.OpenNewActiveSegment())
+	assert.Equal(t, opts.MaxSegments, len(wal.olderSegments)+1)
+
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, opts.MaxSegments, len(entries))
+	for i, id := range evicted {
+		assert.Equal(t, SegmentID(i+1), id)
+	}
+	assert.Equal(t, int(wal.ActiveSegmentID())-opts.MaxSegments, len(evicted))
+}
+
+func TestWAL_InitialSegmentID(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-initial-segment-id")
+	opts := Options{
+		DirPath:          dir,
+		SegmentFileExt:   ".SEG",
+		SegmentSize:      MB,
+		InitialSegmentID: 100,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+	assert.Equal(t, SegmentID(100), wal.ActiveSegmentID())
+
+	pos, err := wal.Write([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, SegmentID(100), pos.SegmentId)
+	assert.Nil(t, wal.OpenNewActiveSegment())
+	assert.Equal(t, SegmentID(101), wal.ActiveSegmentID())
+
+	// it is ignored if there are segment files already.
+	assert.Nil(t, wal.Close())
+	opts.InitialSegmentID = 5
+	opts.ValidateSegmentSequence = true
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, SegmentID(101), wal.ActiveSegmentID())
+	first, err := wal.FirstPosition()
+	assert.Nil(t, err)
+	assert.Equal(t, pos.SegmentId, first.SegmentId)
+	val, err := wal.Read(pos)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(val))
+}
+
+func TestWAL_StartNewSegmentOnOpen(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-start-new-segment-on-open")
+	opts := Options{
+		DirPath:               dir,
+		SegmentFileExt:        ".SEG",
+		SegmentSize:           MB,
+		StartNewSegmentOnOpen: true,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+	assert.Equal(t, SegmentID(1), wal.ActiveSegmentID())
+	pos, err := wal.Write([]byte("hello"))
+	assert.Nil(t, err)
+
+	// the segment file of the previous run is sealed.
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, SegmentID(2), wal.ActiveSegmentID())
+	assert.True(t, wal.olderSegments[1].hasFooter)
+	val, err := wal.Read(pos)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(val))
+
+	// the empty active segment file is reused.
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, SegmentID(2), wal.ActiveSegmentID())
+	pos, err = wal.Write([]byte("world"))
+	assert.Nil(t, err)
+	assert.Equal(t, SegmentID(2), pos.SegmentId)
+}
+
+func TestWAL_RetentionWithReader(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-retention-reader")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		MaxSegments:    2,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	val := []byte(strings.Repeat("wal", 512))
+	for i := 0; i < 1000; i++ {
+		_, err := wal.Write(val)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, wal.OpenNewActiveSegment())
+
+	reader := wal.NewReader()
+	_, _, err = reader.Next()
+	assert.Nil(t, err)
+	abandoned := wal.NewReverseReader()
+
+	// the segment files read by the readers are evicted, but not removed from the disk.
+	for i := 0; i < 2000; i++ {
+		_, err := wal.Write(val)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, wal.OpenNewActiveSegment())
+	assert.Equal(t, opts.MaxSegments, len(wal.olderSegments)+1)
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, opts.MaxSegments+2, len(entries))
+
+	// the new readers don't see them.
+	first, err := wal.FirstPosition()
+	assert.Nil(t, err)
+	assert.Equal(t, wal.firstSegmentID(), first.SegmentId)
+
+	var last *ChunkPosition
+	for {
+		_, pos, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		last = pos
+	}
+	assert.True(t, last.SegmentId < first.SegmentId)
+	entries, err = os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, opts.MaxSegments+2, len(entries))
+
+	// they are removed once the last reader releases them.
+	abandoned.Close()
+	entries, err = os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, opts.MaxSegments, len(entries))
+}
+
+func TestWAL_Checkpoint(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-checkpoint")
+	opts := Options{
+		DirPath:          dir,
+		SegmentFileExt:   ".SEG",
+		SegmentSize:      32 * 1024 * 1024,
+		EnableCheckpoint: true,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	cp, err := wal.Checkpoint()
+	assert.Nil(t, err)
+	assert.Nil(t, cp)
+
+	testWriteAndIterate(t, wal, 2000, 512)
+	err = wal.Sync()
+	assert.Nil(t, err)
+	cp, err = wal.Checkpoint()
+	assert.Nil(t, err)
+	assert.NotNil(t, cp)
+	assert.Equal(t, wal.activeSegment.id, cp.SegmentId)
+	assert.Equal(t, wal.activeSegment.currentBlockNumber, cp.BlockNumber)
+	assert.Equal(t, int64(wal.activeSegment.currentBlockSize), cp.ChunkOffset)
+
+	// the checkpoint file survives reopen, and is not treated as a segment file.
+	err = wal.Close()
+	assert.Nil(t, err)
+	wal2, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal2)
+	cp2, err := wal2.Checkpoint()
+	assert.Nil(t, err)
+	assert.Equal(t, cp, cp2)
+	assert.Equal(t, 0, len(wal2.olderSegments))
+
+	err = wal2.Delete()
+	assert.Nil(t, err)
+	cp, err = wal2.Checkpoint()
+	assert.Nil(t, err)
+	assert.Nil(t, cp)
+}
+
+func TestWAL_Stats(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-stats")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	testWriteAndIterate(t, wal, 2000, 512)
+	assert.Nil(t, wal.Sync())
+
+	stats := wal.Stats()
+	assert.Equal(t, uint64(2000), stats.WriteCount)
+	var totalSize = wal.activeSegment.Size()
+	for _, seg := range wal.olderSegments {
+		totalSize += seg.Size()
+	}
+	assert.Equal(t, uint64(totalSize), stats.BytesWritten)
+	assert.Equal(t, uint64(wal.ActiveSegmentID()-1), stats.RotationCount)
+	assert.Equal(t, stats.RotationCount+1, stats.SyncCount)
+	assert.Equal(t, len(SyncLatencyBounds())+1, len(stats.SyncLatencyBuckets))
+	var syncs uint64
+	for _, count := range stats.SyncLatencyBuckets {
+		syncs += count
+	}
+	assert.Equal(t, stats.SyncCount, syncs)
+}
+
+func TestWAL_LastWriteStats(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-last-write-stats")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	assert.Equal(t, WriteStats{}, wal.LastWriteStats())
+	_, err = wal.Write(make([]byte, 100))
+	assert.Nil(t, err)
+	assert.Equal(t, WriteStats{Entries: 1, DataBytes: 100, HeaderBytes: chunkHeaderSize}, wal.LastWriteStats())
+
+	// leave 3 bytes in the block, which are padded by the next write.
+	_, err = wal.Write(make([]byte, blockSize-2*chunkHeaderSize-100-3))
+	assert.Nil(t, err)
+	_, err = wal.Write(make([]byte, 10))
+	assert.Nil(t, err)
+	assert.Equal(t, WriteStats{Entries: 1, DataBytes: 10, HeaderBytes: chunkHeaderSize, PaddingBytes: 3}, wal.LastWriteStats())
+
+	// an entry takes a chunk header for every block it occupies.
+	_, err = wal.Write(make([]byte, 2*blockSize))
+	assert.Nil(t, err)
+	assert.Equal(t, WriteStats{Entries: 1, DataBytes: 2 * blockSize, HeaderBytes: 3 * chunkHeaderSize}, wal.LastWriteStats())
+
+	for i := 0; i < 3; i++ {
+		wal.PendingWrites(make([]byte, 10))
+	}
+	_, err = wal.WriteAll()
+	assert.Nil(t, err)
+	assert.Equal(t, WriteStats{Entries: 3, DataBytes: 30, HeaderBytes: 3 * chunkHeaderSize}, wal.LastWriteStats())
+}
+
+func TestReader_NextPosition(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-next-position")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	var positions []*ChunkPosition
+	for i := 0; i < 1000; i++ {
+		pos, err := wal.Write([]byte(strings.Repeat("W", i*100)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+
+	reader := wal.NewReader()
+	for i := 0; ; i++ {
+		pos, length, err := reader.NextPosition()
+		if err == io.EOF {
+			assert.Equal(t, len(positions), i)
+			break
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, i*100, length)
+		assert.Equal(t, positions[i].SegmentId, pos.SegmentId)
+		assert.Equal(t, positions[i].BlockNumber, pos.BlockNumber)
+		assert.Equal(t, positions[i].ChunkOffset, pos.ChunkOffset)
+	}
+}
+
+func TestMergeReaders(t *testing.T) {
+	var wals []*WAL
+	for i := 0; i < 3; i++ {
+		dir, _ := os.MkdirTemp("", "wal-test-merge-readers")
+		wal, err := Open(Options{
+			DirPath:        dir,
+			SegmentFileExt: ".SEG",
+			SegmentSize:    MB,
+		})
+		assert.Nil(t, err)
+		defer destroyWAL(wal)
+		for j := 0; j < 1000; j++ {
+			_, err := wal.Write([]byte(fmt.Sprintf("wal-%d-%d", i, j)))
+			assert.Nil(t, err)
+		}
+		wals = append(wals, wal)
+	}
+
+	// skip the first entry of the second wal.
+	second := wals[1].NewReader()
+	_, _, err := second.Next()
+	assert.Nil(t, err)
+
+	reader := MergeReaders(wals[0].NewReader(), second, wals[2].NewReader())
+	var values []string
+	for {
+		val, _, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		values = append(values, string(val))
+	}
+	assert.Equal(t, 2999, len(values))
+	assert.Equal(t, "wal-0-0", values[0])
+	assert.Equal(t, "wal-1-1", values[1000])
+	assert.Equal(t, "wal-2-999", values[2998])
+
+	_, _, err = MergeReaders().Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestWAL_WriteWithTime(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-write-with-time")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	base := time.Unix(1700000000, 0)
+	var positions []*ChunkPosition
+	for i := 0; i < 100; i++ {
+		val := []byte(strings.Repeat("W", i*1000))
+		var pos *ChunkPosition
+		if i%2 == 0 {
+			pos, err = wal.WriteWithTime(val, base.Add(time.Duration(i)*time.Second))
+		} else {
+			pos, err = wal.Write(val)
+		}
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+
+	for i, pos := range positions {
+		val, ts, err := wal.ReadWithTime(pos)
+		assert.Nil(t, err)
+		assert.Equal(t, i*1000, len(val))
+		if i%2 == 0 {
+			assert.True(t, base.Add(time.Duration(i)*time.Second).Equal(ts))
+		} else {
+			assert.True(t, ts.IsZero())
+		}
+	}
+
+	reader := wal.NewReader()
+	for i := 0; ; i++ {
+		val, _, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, strings.Repeat("W", i*1000), string(val))
+		if i%2 == 0 {
+			assert.True(t, base.Add(time.Duration(i)*time.Second).Equal(reader.Timestamp()))
+		} else {
+			assert.True(t, reader.Timestamp().IsZero())
+		}
+	}
+
+	// the prefix is not counted in the data length.
+	reader = wal.NewReader()
+	_, length, err := reader.NextPosition()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, length)
+	_, length, err = reader.NextPosition()
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, length)
+}
+
+func TestWAL_NewReaderWithTimeRange(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-reader-time-range")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	base := time.Unix(1700000000, 0)
+	for i := 0; i < 1000; i++ {
+		_, err := wal.WriteWithTime([]byte(fmt.Sprintf("wal-%d", i)), base.Add(time.Duration(i)*time.Minute))
+		assert.Nil(t, err)
+		_, err = wal.Write([]byte(strings.Repeat("X", 1024)))
+		assert.Nil(t, err)
+	}
+
+	_, err = wal.NewReaderWithTimeRange(base, base.Add(-time.Second))
+	assert.NotNil(t, err)
+
+	reader, err := wal.NewReaderWithTimeRange(base.Add(100*time.Minute), base.Add(200*time.Minute))
+	assert.Nil(t, err)
+	var values []string
+	for {
+		val, _, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		assert.False(t, reader.Timestamp().Before(base.Add(100*time.Minute)))
+		values = append(values, string(val))
+	}
+	assert.Equal(t, 100, len(values))
+	assert.Equal(t, "wal-100", values[0])
+	assert.Equal(t, "wal-199", values[99])
+}
+
+func TestWAL_OnSegmentSealed(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-on-segment-sealed")
+	var sealed []SegmentID
+	var failed bool
+	logger := &recordLogger{}
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		Logger:         logger,
+		OnSegmentSealed: func(segId SegmentID, path string) error {
+			if failed {
+				return errors.New("upload failed")
+			}
+			// the sealed segment file is in its final path with the footer.
+			_, err := os.Stat(path)
+			assert.Nil(t, err)
+			assert.Equal(t, SegmentFileName(dir, ".SEG", segId), path)
+			sealed = append(sealed, segId)
+			return nil
+		},
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	testWriteAndIterate(t, wal, 2000, 512)
+	assert.Nil(t, wal.OpenNewActiveSegment())
+	assert.Equal(t, int(wal.ActiveSegmentID())-1, len(sealed))
+	for i, id := range sealed {
+		assert.Equal(t, SegmentID(i+1), id)
+		assert.True(t, wal.olderSegments[id].hasFooter)
+	}
+
+	// the error is logged, the rotation and the retention are done as usual.
+	failed = true
+	wal.options.MaxSegments = 2
+	activeID := wal.ActiveSegmentID()
+	assert.Nil(t, wal.OpenNewActiveSegment())
+	assert.Equal(t, activeID+1, wal.ActiveSegmentID())
+	assert.True(t, logger.contains(fmt.Sprintf("ERROR wal: the segment sealed hook of %d.SEG failed: upload failed", activeID)))
+	assert.Equal(t, 1, len(wal.olderSegments))
+	failed = false
+	pos, err := wal.Write([]byte("hello"))
+	assert.Nil(t, err)
+	val, err := wal.Read(pos)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(val))
+}
+
+func TestWAL_Notify(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-notify")
+	opts := DefaultOptions
+	opts.DirPath = dir
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	notifyC1 := wal.Notify()
+	notifyC2 := wal.Notify()
+	select {
+	case <-notifyC1:
+		t.Fatal("unexpected notification before writing")
+	default:
+	}
+
+	// the signals of many writes are coalesced into one.
+	for i := 0; i < 10; i++ {
+		_, err = wal.Write([]byte("hello"))
+		assert.Nil(t, err)
+	}
+	for _, notifyC := range []<-chan struct{}{notifyC1, notifyC2} {
+		select {
+		case <-notifyC:
+		default:
+			t.Fatal("missing notification after writing")
+		}
+		select {
+		case <-notifyC:
+			t.Fatal("the notifications are not coalesced")
+		default:
+		}
+	}
+
+	wal.PendingWrites([]byte("hello"))
+	_, err = wal.WriteAll()
+	assert.Nil(t, err)
+	_, ok := <-notifyC1
+	assert.True(t, ok)
+
+	err = wal.Close()
+	assert.Nil(t, err)
+	_, ok = <-notifyC1
+	assert.False(t, ok)
+	_, ok = <-wal.Notify()
+	assert.False(t, ok)
+}
+
+func TestWAL_NewReaderAtEnd(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-reader-at-end")
+	opts := DefaultOptions
+	opts.DirPath = dir
+	opts.SegmentSize = 64 * KB
+	opts.WriteBufferSize = 4 * KB
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	reader := wal.NewReaderAtEnd()
+	_, _, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+	reader.Close()
+
+	for i := 0; i < 200; i++ {
+		_, err := wal.Write([]byte(strings.Repeat("X", 1000)))
+		assert.Nil(t, err)
+	}
+	assert.True(t, wal.ActiveSegmentID() > 1)
+
+	// the reader at the end returns the entries written after it.
+	notifyC := wal.Notify()
+	reader = wal.NewReaderAtEnd()
+	cursor := reader.SaveCursor()
+	reader.Close()
+	for i := 0; i < 100; i++ {
+		pos, err := wal.Write([]byte(fmt.Sprintf("new-%d", i)))
+		assert.Nil(t, err)
+		assert.Nil(t, wal.Flush())
+		<-notifyC
+
+		// tail the WAL by the readers from the cursor of the previous one.
+		reader, err := wal.NewReaderFromCursor(cursor)
+		assert.Nil(t, err)
+		val, readPos, err := reader.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("new-%d", i), string(val))
+		assert.Equal(t, pos.SegmentId, readPos.SegmentId)
+		assert.Equal(t, pos.BlockNumber, readPos.BlockNumber)
+		assert.Equal(t, pos.ChunkOffset, readPos.ChunkOffset)
+		_, _, err = reader.Next()
+		assert.Equal(t, io.EOF, err)
+		cursor = reader.SaveCursor()
+		reader.Close()
+	}
+}
+
+func TestWAL_MaxPendingSize(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-max-pending-size")
+	opts := DefaultOptions
+	opts.DirPath = dir
+	opts.MaxPendingSize = 100
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	wal.PendingWrites(make([]byte, 50))
+	assert.Equal(t, wal.maxDataWriteSize(50), wal.PendingSize())
+	wal.ClearPendingWrites()
+	assert.Equal(t, int64(0), wal.PendingSize())
+	positions, err := wal.WriteAll()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(positions))
+
+	wal.PendingWrites(make([]byte, 50))
+	wal.PendingWrites(make([]byte, 50))
+	_, err = wal.WriteAll()
+	assert.Equal(t, ErrPendingSizeTooLarge, err)
+	assert.Equal(t, int64(0), wal.PendingSize())
+	assert.True(t, wal.IsEmpty())
+
+	wal.PendingWrites(make([]byte, 50))
+	positions, err = wal.WriteAll()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(positions))
+}
+
+func TestWAL_Write_NoSpace(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-write-no-space")
+	fs := &limitedFileSystem{}
+	fs.limit.Store(GB)
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		FileSystem:     fs,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	val := []byte(strings.Repeat("X", 100))
+	_, err = wal.Write(val)
+	assert.Nil(t, err)
+	size := wal.activeSegment.Size()
+	path := wal.segmentFileName(wal.ActiveSegmentID())
+	fileSize := func() int64 {
+		info, err := os.Stat(path)
+		assert.Nil(t, err)
+		return info.Size()
+	}
+
+	// the half written chunk is truncated, and the short write without error is a failure too.
+	for _, shortWrite := range []bool{false, true} {
+		fs.shortWrite.Store(shortWrite)
+		fs.limit.Store(50)
+		_, err = wal.Write(val)
+		assert.NotNil(t, err)
+		assert.Equal(t, size, wal.activeSegment.Size())
+		assert.Equal(t, size, fileSize())
+	}
+
+	// the torn data is truncated before the next write if the truncate fails.
+	fs.shortWrite.Store(false)
+	fs.failTruncate.Store(true)
+	fs.limit.Store(50)
+	_, err = wal.Write(val)
+	assert.NotNil(t, err)
+	assert.Equal(t, size+50, fileSize())
+	fs.limit.Store(GB)
+	_, err = wal.Write(val)
+	assert.NotNil(t, err)
+	assert.Equal(t, size+50, fileSize())
+
+	fs.failTruncate.Store(false)
+	pos, err := wal.Write(val)
+	assert.Nil(t, err)
+	assert.Equal(t, size, int64(pos.BlockNumber)*blockSize+pos.ChunkOffset)
+	assert.Equal(t, size+chunkHeaderSize+100, fileSize())
+
+	var count int
+	reader := wal.NewReader()
+	for {
+		_, _, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if !assert.Nil(t, err) {
+			break
+		}
+		count++
+	}
+	assert.Equal(t, 2, count)
+}
+
+func TestWAL_WriteAll_Partial(t *testing.T) {
+	for _, atomic := range []bool{false, true} {
+		t.Run(fmt.Sprintf("atomic=%v", atomic), func(t *testing.T) {
+			dir, _ := os.MkdirTemp("", "wal-test-write-all-partial")
+			fs := &limitedFileSystem{}
+			fs.limit.Store(GB)
+			opts := Options{
+				DirPath:        dir,
+				SegmentFileExt: ".SEG",
+				SegmentSize:    MB,
+				AtomicWriteAll: atomic,
+				FileSystem:     fs,
+			}
+			wal, err := Open(opts)
+			assert.Nil(t, err)
+			defer destroyWAL(wal)
+
+			_, err = wal.Write([]byte("before"))
+			assert.Nil(t, err)
+			sizeBefore := wal.activeSegment.Size()
+
+			// the disk is full in the middle of the third entry.
+			fs.limit.Store(2*(chunkHeaderSize+100) + 50)
+			for i := 0; i < 5; i++ {
+				wal.PendingWrites([]byte(strings.Repeat(fmt.Sprint(i), 100)))
+			}
+			positions, err := wal.WriteAll()
+			assert.Equal(t, errNoSpace, err)
+			if atomic {
+				assert.Equal(t, 0, len(positions))
+				assert.Equal(t, sizeBefore, wal.activeSegment.Size())
+			} else {
+				assert.Equal(t, 2, len(positions))
+				assert.Equal(t, sizeBefore+2*(chunkHeaderSize+100), wal.activeSegment.Size())
+			}
+			for i, pos := range positions {
+				val, err := wal.Read(pos)
+				assert.Nil(t, err)
+				assert.Equal(t, strings.Repeat(fmt.Sprint(i), 100), string(val))
+			}
+
+			// the segment file is still valid for the following writes.
+			fs.limit.Store(GB)
+			pos, err := wal.Write([]byte("after"))
+			assert.Nil(t, err)
+			val, err := wal.Read(pos)
+			assert.Nil(t, err)
+			assert.Equal(t, "after", string(val))
+
+			var count int
+			reader := wal.NewReader()
+			for {
+				_, _, err := reader.Next()
+				if err == io.EOF {
+					break
+				}
+				assert.Nil(t, err)
+				count++
+			}
+			assert.Equal(t, 2+len(positions), count)
+		})
+	}
+}
+
+func TestWAL_ConcurrentReadWrite(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-concurrent-read-write")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	val := []byte(strings.Repeat("X", 10*KB))
+	positions := make(chan *ChunkPosition, 1000)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer close(positions)
+		for i := 0; i < 1000; i++ {
+			pos, err := wal.Write(val)
+			assert.Nil(t, err)
+			positions <- pos
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for pos := range positions {
+			res, err := wal.Read(pos)
+			assert.Nil(t, err)
+			assert.Equal(t, val, res)
+		}
+	}()
+
+	// iterate the WAL while writing, all the entries seen are complete.
+	reader := wal.NewReader()
+	for {
+		res, _, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, val, res)
+	}
+	wg.Wait()
+}
+
+func TestWAL_Size_LargeSegment(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-size-large-segment")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    8 * GB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	// pretend the active segment file has grown beyond 4GB,
+	// the block number times the block size overflows uint32.
+	wal.activeSegment.currentBlockNumber = 4*GB/blockSize + 1
+	wal.activeSegment.currentBlockSize = 100
+	assert.Equal(t, int64(4*GB+blockSize+100), wal.activeSegment.Size())
+	assert.False(t, wal.IsEmpty())
+	assert.False(t, wal.isFull(MB))
+	assert.True(t, wal.isFull(4*GB))
+}
+
+func TestWAL_SegmentIDOverflow(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-segment-id-overflow")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	// the last segment file has the max segment id.
+	fd, err := os.Create(SegmentFileName(dir, ".SEG", math.MaxUint32))
+	assert.Nil(t, err)
+	assert.Nil(t, fd.Close())
+
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+	assert.Equal(t, SegmentID(math.MaxUint32), wal.ActiveSegmentID())
+
+	// the active segment file can still be written until it is full.
+	_, err = wal.Write([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, ErrSegmentIDOverflow, wal.OpenNewActiveSegment())
+	for i := 0; i < 100; i++ {
+		_, err = wal.Write([]byte(strings.Repeat("X", 20*KB)))
+		if err != nil {
+			break
+		}
+	}
+	assert.Equal(t, ErrSegmentIDOverflow, err)
+	assert.Equal(t, SegmentID(math.MaxUint32), wal.ActiveSegmentID())
+
+	// the segment file whose id is out of range can't be opened.
+	assert.Nil(t, wal.Close())
+	fd, err = os.Create(filepath.Join(dir, "4294967296.SEG"))
+	assert.Nil(t, err)
+	assert.Nil(t, fd.Close())
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, ErrSegmentIDOverflow))
+}
+
+func TestWAL_ValidateSegmentSequence(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-validate-segment-sequence")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	opts := Options{
+		DirPath:                 dir,
+		SegmentFileExt:          ".SEG",
+		SegmentSize:             MB,
+		ValidateSegmentSequence: true,
+	}
+	createFile := func(name string) {
+		fd, err := os.Create(filepath.Join(dir, name))
+		assert.Nil(t, err)
+		assert.Nil(t, fd.Close())
+	}
+
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, wal.OpenNewActiveSegment())
+	}
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, wal.Close())
+
+	// a stray file with a colliding id.
+	createFile("3.SEG")
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, ErrDuplicateSegment))
+	assert.Nil(t, os.Remove(filepath.Join(dir, "3.SEG")))
+
+	// a segment file is deleted manually.
+	assert.Nil(t, os.Remove(SegmentFileName(dir, ".SEG", 2)))
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, ErrSegmentGap))
+
+	// the sequence is not validated by default.
+	opts.ValidateSegmentSequence = false
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, wal.Close())
+}
+
+func TestReader_SetLimit(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-reader-limit")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	for i := 0; i < 300; i++ {
+		_, err := wal.Write([]byte(strings.Repeat("X", 10*KB)))
+		assert.Nil(t, err)
+	}
+
+	for _, limit := range []int{1, 150, 300, 500} {
+		reader := wal.NewReader()
+		reader.SetLimit(limit)
+		count := 0
+		for {
+			_, _, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			assert.Nil(t, err)
+			count++
+		}
+		assert.Equal(t, min(limit, 300), count)
+	}
+
+	// no limit if it is zero.
+	reader := wal.NewReader()
+	reader.SetLimit(0)
+	count := 0
+	for {
+		if _, _, err := reader.NextPosition(); err == io.EOF {
+			break
+		}
+		count++
+	}
+	assert.Equal(t, 300, count)
+}
+
+func TestReader_SetByteBudget(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-reader-byte-budget")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	for i := 0; i < 300; i++ {
+		_, err := wal.Write([]byte(strings.Repeat("X", 10*KB)))
+		assert.Nil(t, err)
+	}
+
+	// read the WAL in batches of about 25KB.
+	reader := wal.NewReader()
+	reader.SetByteBudget(25 * KB)
+	total, batchSize, batches := 0, 0, 0
+	for {
+		val, _, err := reader.Next()
+		if err == ErrByteBudgetExceeded {
+			// the entry crossing the budget is returned.
+			assert.Equal(t, 30*KB, batchSize)
+			batches++
+			batchSize = 0
+
+			// resume from the current position.
+			pos := reader.CurrentChunkPosition()
+			reader, err = wal.NewReaderWithStart(pos)
+			assert.Nil(t, err)
+			reader.SetByteBudget(25 * KB)
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		batchSize += len(val)
+		total++
+	}
+	assert.Equal(t, 300, total)
+	assert.Equal(t, 100, batches)
+
+	// the budget can be reset to continue the same reader.
+	reader = wal.NewReader()
+	reader.SetByteBudget(15 * KB)
+	for i := 0; i < 150; i++ {
+		_, length, err := reader.NextPosition()
+		assert.Nil(t, err)
+		assert.Equal(t, 10*KB, length)
+		_, _, err = reader.NextPosition()
+		assert.Nil(t, err)
+		_, _, err = reader.NextPosition()
+		assert.Equal(t, ErrByteBudgetExceeded, err)
+		reader.SetByteBudget(15 * KB)
+	}
+	_, _, err = reader.NextPosition()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReader_Peek(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-reader-peek")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    32 * KB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	var positions []*ChunkPosition
+	for i := 0; i < 10; i++ {
+		pos, err := wal.Write([]byte(strings.Repeat(strconv.Itoa(i), 10*KB)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.True(t, wal.ActiveSegmentID() > 1)
+
+	reader := wal.NewReader()
+	defer reader.Close()
+	for i, pos := range positions {
+		// peek twice, the reader stays at the peeked entry, even across the segment files.
+		for j := 0; j < 2; j++ {
+			val, peekPos, err := reader.Peek()
+			assert.Nil(t, err)
+			assert.Equal(t, strings.Repeat(strconv.Itoa(i), 10*KB), string(val))
+			assert.Equal(t, pos.SegmentId, peekPos.SegmentId)
+			assert.Equal(t, pos.ChunkOffset, peekPos.ChunkOffset)
+			assert.Equal(t, pos.SegmentId, reader.CurrentSegmentId())
+			current := reader.CurrentChunkPosition()
+			assert.Equal(t, pos.BlockNumber, current.BlockNumber)
+			assert.Equal(t, pos.ChunkOffset, current.ChunkOffset)
+		}
+		if i%2 == 0 {
+			val, _, err := reader.Next()
+			assert.Nil(t, err)
+			assert.Equal(t, strings.Repeat(strconv.Itoa(i), 10*KB), string(val))
+		} else {
+			_, length, err := reader.NextPosition()
+			assert.Nil(t, err)
+			assert.Equal(t, 10*KB, length)
+		}
+	}
+	_, _, err = reader.Peek()
+	assert.Equal(t, io.EOF, err)
+	_, _, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// the peeked entry isn't counted by the limit until it is returned by Next.
+	reader = wal.NewReader()
+	defer reader.Close()
+	reader.SetLimit(1)
+	_, _, err = reader.Peek()
+	assert.Nil(t, err)
+	_, _, err = reader.Next()
+	assert.Nil(t, err)
+	_, _, err = reader.Peek()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReader_Remaining(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-reader-remaining")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    64 * KB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+
+	for i := 0; i < 300; i++ {
+		_, err := wal.Write([]byte(strings.Repeat("X", KB)))
+		assert.Nil(t, err)
+	}
+	assert.True(t, wal.ActiveSegmentID() > 3)
+
+	check := func(exact bool) {
+		reader := wal.NewReader()
+		defer reader.Close()
+		entries, size := reader.Remaining()
+		if exact {
+			assert.Equal(t, 300, entries)
+		}
+		var total int64
+		files, _ := filepath.Glob(filepath.Join(dir, "*.SEG"))
+		for _, file := range files {
+			info, err := os.Stat(file)
+			assert.Nil(t, err)
+			total += info.Size()
+		}
+		assert.Equal(t, total, size)
+		for i := 0; i < 300; i++ {
+			entries, remaining := reader.Remaining()
+			assert.InDelta(t, 300-i, entries, 3)
+			assert.True(t, remaining <= size)
+			size = remaining
+			_, _, err := reader.Next()
+			assert.Nil(t, err)
+		}
+		entries, size = reader.Remaining()
+		assert.Equal(t, 0, entries)
+		assert.Equal(t, int64(0), size)
+	}
+	check(true)
+
+	// the entries of the footerless active segment file are estimated after reopening.
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	check(false)
+
+	reader := wal.NewReader()
+	defer reader.Close()
+	reader.SetLimit(10)
+	entries, _ := reader.Remaining()
+	assert.Equal(t, 10, entries)
+	_, _, err = reader.Peek()
+	assert.Nil(t, err)
+	entries, _ = reader.Remaining()
+	assert.Equal(t, 10, entries)
+}
+
+func TestWAL_Len(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-len")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+
+	n, err := wal.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	for i := 0; i < 500; i++ {
+		_, err := wal.Write([]byte(strings.Repeat("X", i*10)))
+		assert.Nil(t, err)
+	}
+	assert.True(t, wal.ActiveSegmentID() > 1)
+	n, err = wal.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 500, n)
+
+	// the footerless active segment file is scanned after reopening.
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	assert.False(t, wal.activeSegment.index.complete)
+	n, err = wal.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 500, n)
+
+	_, err = wal.Write([]byte("hello"))
+	assert.Nil(t, err)
+	n, err = wal.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 501, n)
+}
+
+// blockingFileSystem wraps the OSFileSystem and blocks the first read of the file
+// at path once armed, until resume is closed, reading is closed when it blocks.
+type blockingFileSystem struct {
+	osFileSystem
+	path    string
+	armed   atomic.Bool
+	reading chan struct{}
+	resume  chan struct{}
+}
+
+type blockingFile struct {
+	File
+	fs *blockingFileSystem
+}
+
+func (fs *blockingFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := fs.osFileSystem.OpenFile(name, flag, perm)
+	if err != nil || name != fs.path {
+		return f, err
+	}
+	return &blockingFile{File: f, fs: fs}, nil
+}
+
+func (f *blockingFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.fs.armed.CompareAndSwap(true, false) {
+		close(f.fs.reading)
+		<-f.fs.resume
+	}
+	return f.File.ReadAt(p, off)
+}
+
+func TestWAL_Len_ConcurrentTrim(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-len-concurrent-trim")
+	fs := &blockingFileSystem{
+		path:    SegmentFileName(dir, ".SEG", 1),
+		reading: make(chan struct{}),
+		resume:  make(chan struct{}),
+	}
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		FileSystem:     fs,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+	var positions []*ChunkPosition
+	for i := 0; i < 500; i++ {
+		pos, err := wal.Write([]byte(strings.Repeat("X", 5*KB)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.True(t, wal.ActiveSegmentID() > 2)
+	// the segment file of the start position is scanned by Len.
+	assert.Nil(t, wal.TrimFront(positions[1]))
+
+	fs.armed.Store(true)
+	errC := make(chan error)
+	go func() {
+		_, err := wal.Len()
+		errC <- err
+	}()
+
+	// the segment file being scanned is removed by TrimFront only after the scan.
+	<-fs.reading
+	for _, pos := range positions {
+		if pos.SegmentId == 2 {
+			assert.Nil(t, wal.TrimFront(pos))
+			break
+		}
+	}
+	close(fs.resume)
+	assert.Nil(t, <-errC)
+	_, err = os.Stat(fs.path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWAL_NewReaderWithContext(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-reader-context")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	for i := 0; i < 300; i++ {
+		_, err := wal.Write([]byte(strings.Repeat("X", 10*KB)))
+		assert.Nil(t, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reader := wal.NewReaderWithContext(ctx)
+	for i := 0; i < 100; i++ {
+		_, _, err := reader.Next()
+		assert.Nil(t, err)
+	}
+	cancel()
+	_, _, err = reader.Next()
+	assert.Equal(t, context.Canceled, err)
+	_, _, err = reader.NextPosition()
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestWAL_ReadContext(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-read-context")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	val := []byte(strings.Repeat("X", 10*blockSize))
+	pos, err := wal.Write(val)
+	assert.Nil(t, err)
+
+	res, err := wal.ReadContext(context.Background(), pos)
+	assert.Nil(t, err)
+	assert.Equal(t, val, res)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = wal.ReadContext(ctx, pos)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestWAL_MMapReads(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-mmap-reads")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		MMapReads:      true,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+
+	var positions []*ChunkPosition
+	for i := 0; i < 500; i++ {
+		pos, err := wal.Write([]byte(strings.Repeat("X", 10*KB)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.True(t, wal.ActiveSegmentID() > 2)
+
+	check := func() {
+		// only the sealed segment files are mapped.
+		for _, seg := range wal.olderSegments {
+			assert.NotNil(t, seg.mapped)
+		}
+		assert.Nil(t, wal.activeSegment.mapped)
+		for _, pos := range positions {
+			res, err := wal.Read(pos)
+			assert.Nil(t, err)
+			assert.Equal(t, 10*KB, len(res))
+		}
+	}
+	check()
+
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	check()
+}
+
+func TestWAL_FirstPosition(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-first-position")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		MaxSegments:    3,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	pos, err := wal.FirstPosition()
+	assert.Nil(t, err)
+	assert.Nil(t, pos)
+
+	// an empty segment file is skipped.
+	assert.Nil(t, wal.OpenNewActiveSegment())
+	var positions []*ChunkPosition
+	for i := 0; i < 200; i++ {
+		pos, err := wal.Write([]byte(strings.Repeat("X", 10*KB)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	pos, err = wal.FirstPosition()
+	assert.Nil(t, err)
+	assert.Equal(t, positions[0].SegmentId, pos.SegmentId)
+	assert.Equal(t, positions[0].ChunkOffset, pos.ChunkOffset)
+
+	// the segment files removed by retention are not considered.
+	for i := 0; i < 500; i++ {
+		pos, err := wal.Write([]byte(strings.Repeat("X", 10*KB)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	pos, err = wal.FirstPosition()
+	assert.Nil(t, err)
+	assert.Equal(t, wal.ActiveSegmentID()-2, pos.SegmentId)
+	assert.Equal(t, uint32(0), pos.BlockNumber)
+	assert.Equal(t, int64(0), pos.ChunkOffset)
+	_, err = wal.Read(pos)
+	assert.Nil(t, err)
+}
+
+func TestWAL_Read_Truncated(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-read-truncated")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		MaxSegments:    2,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	var positions []*ChunkPosition
+	for i := 0; i < 500; i++ {
+		pos, err := wal.Write([]byte(strings.Repeat("X", 10*KB)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	first, err := wal.FirstPosition()
+	assert.Nil(t, err)
+	assert.True(t, first.SegmentId > positions[0].SegmentId)
+
+	_, err = wal.Read(positions[0])
+	assert.True(t, errors.Is(err, ErrPositionTruncated))
+	_, err = wal.Read(first)
+	assert.Nil(t, err)
+	_, err = wal.Read(&ChunkPosition{SegmentId: wal.ActiveSegmentID() + 1})
+	assert.True(t, errors.Is(err, ErrSegmentNotFound))
+}
+
+func TestWAL_FileMode(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-file-mode")
+	dir = filepath.Join(dir, "wal")
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(dir))
+	}()
+	opts := Options{
+		DirPath:          dir,
+		SegmentFileExt:   ".SEG",
+		SegmentSize:      MB,
+		EnableCheckpoint: true,
+		FileMode:         0600,
+		DirMode:          0700,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = wal.Write([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Nil(t, wal.Sync())
+	assert.Nil(t, wal.Close())
+
+	stat, err := os.Stat(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0700), stat.Mode().Perm())
+	for _, name := range []string{SegmentFileName(dir, ".SEG", 1), filepath.Join(dir, checkpointFileName)} {
+		stat, err := os.Stat(name)
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+	}
+}
+
+func TestWAL_SegmentNameFunc(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-segment-name-func")
+	opts := Options{
+		DirPath:     dir,
+		SegmentSize: MB,
+		SegmentNameFunc: func(id SegmentID) string {
+			return fmt.Sprintf("log-%04d.wal", id)
+		},
+		SegmentParseFunc: func(name string) (SegmentID, bool) {
+			var id SegmentID
+			if _, err := fmt.Sscanf(name, "log-%04d.wal", &id); err != nil {
+				return 0, false
+			}
+			return id, true
+		},
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+
+	var positions []*ChunkPosition
+	for i := 0; i < 300; i++ {
+		pos, err := wal.Write([]byte(strings.Repeat("X", 10*KB)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.Nil(t, wal.Close())
+
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+	for i, entry := range entries {
+		assert.Equal(t, fmt.Sprintf("log-%04d.wal", i+1), entry.Name())
+	}
+
+	// the segment files are found by the parse func.
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, SegmentID(3), wal.ActiveSegmentID())
+	for _, pos := range positions {
+		_, err := wal.Read(pos)
+		assert.Nil(t, err)
+	}
+
+	opts.SegmentParseFunc = nil
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestWAL_OnRotate(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-on-rotate")
+	type rotation struct{ oldID, newID SegmentID }
+	var rotations []rotation
+	var wal *WAL
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		OnRotate: func(oldID, newID SegmentID) {
+			// the WAL lock is not held.
+			assert.Equal(t, newID, wal.ActiveSegmentID())
+			rotations = append(rotations, rotation{oldID, newID})
+		},
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	for i := 0; i < 200; i++ {
+		_, err := wal.Write([]byte(strings.Repeat("X", 10*KB)))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, []rotation{{1, 2}}, rotations)
+
+	assert.Nil(t, wal.OpenNewActiveSegment())
+	for i := 0; i < 60; i++ {
+		_, err := wal.Write([]byte(strings.Repeat("X", 10*KB)))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 5; i++ {
+		wal.PendingWrites([]byte(strings.Repeat("X", 100*KB)))
+	}
+	_, err = wal.WriteAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []rotation{{1, 2}, {2, 3}, {3, 4}}, rotations)
+}
+
+func TestWAL_OnRotate_StartNewSegmentOnOpen(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-on-rotate-open")
+	type rotation struct{ oldID, newID SegmentID }
+	var rotations []rotation
+	opts := Options{
+		DirPath:               dir,
+		SegmentFileExt:        ".SEG",
+		SegmentSize:           MB,
+		StartNewSegmentOnOpen: true,
+		OnRotate: func(oldID, newID SegmentID) {
+			rotations = append(rotations, rotation{oldID, newID})
+		},
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+	_, err = wal.Write([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Nil(t, rotations)
+
+	// the rotation on Open is reported before Open returns, without waiting for a write.
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []rotation{{1, 2}}, rotations)
+}
+
+func TestWAL_WriteBufferSize(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-write-buffer")
+	opts := Options{
+		DirPath:         dir,
+		SegmentFileExt:  ".SEG",
+		SegmentSize:     MB,
+		WriteBufferSize: 4 * KB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() { destroyWAL(wal) }()
+
+	fileSize := func() int64 {
+		info, err := os.Stat(SegmentFileName(dir, ".SEG", wal.ActiveSegmentID()))
+		assert.Nil(t, err)
+		return info.Size()
+	}
+
+	// the small entries are buffered.
+	var positions []*ChunkPosition
+	for i := 0; i < 10; i++ {
+		pos, err := wal.Write([]byte(fmt.Sprintf("entry-%d", i)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.Equal(t, int64(0), fileSize())
+
+	// the buffered entries can be read.
+	val, err := wal.Read(positions[3])
+	assert.Nil(t, err)
+	assert.Equal(t, "entry-3", string(val))
+	assert.Equal(t, wal.activeSegment.Size(), fileSize())
+
+	// the buffer is flushed when it is full.
+	for i := 10; i < 1000; i++ {
+		pos, err := wal.Write([]byte(fmt.Sprintf("entry-%d", i)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.True(t, fileSize() > 0)
+	assert.True(t, fileSize() < wal.activeSegment.Size())
+
+	// the data larger than the buffer is written directly.
+	pos, err := wal.Write([]byte(strings.Repeat("X", 8*KB)))
+	assert.Nil(t, err)
+	assert.Equal(t, wal.activeSegment.Size(), fileSize())
+	val, err = wal.Read(pos)
+	assert.Nil(t, err)
+	assert.Equal(t, 8*KB, len(val))
+
+	_, err = wal.Write([]byte("tail"))
+	assert.Nil(t, err)
+	assert.Nil(t, wal.Flush())
+	assert.Equal(t, wal.activeSegment.Size(), fileSize())
+
+	// the reader flushes the buffer first.
+	_, err = wal.Write([]byte("last"))
+	assert.Nil(t, err)
+	reader := wal.NewReader()
+	var count int
+	var last []byte
+	for {
+		val, _, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		last = val
+		count++
+	}
+	assert.Equal(t, 1003, count)
+	assert.Equal(t, "last", string(last))
+
+	// the buffer is flushed on close.
+	_, err = wal.Write([]byte("closed"))
+	assert.Nil(t, err)
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	for i, pos := range positions {
+		val, err := wal.Read(pos)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("entry-%d", i), string(val))
+	}
+	n, err := wal.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 1004, n)
+}
+
+func TestWAL_CurrentPosition(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-current-position")
+	opts := DefaultOptions
+	opts.DirPath = dir
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	assert.Equal(t, &ChunkPosition{SegmentId: 1}, wal.CurrentPosition())
+
+	for i := 0; i < 10; i++ {
+		cur := wal.CurrentPosition()
+		pos, err := wal.Write([]byte(strings.Repeat("X", 5*KB)))
+		assert.Nil(t, err)
+		assert.Equal(t, cur.SegmentId, pos.SegmentId)
+		assert.Equal(t, cur.BlockNumber, pos.BlockNumber)
+		assert.Equal(t, cur.ChunkOffset, pos.ChunkOffset)
+	}
+
+	// the left space of the block can only hold the chunk header partially.
+	_, err = wal.Write(make([]byte, blockSize-int(wal.CurrentPosition().ChunkOffset)-chunkHeaderSize-3))
+	assert.Nil(t, err)
+	cur := wal.CurrentPosition()
+	assert.Equal(t, int64(0), cur.ChunkOffset)
+	pos, err := wal.Write([]byte("next block"))
+	assert.Nil(t, err)
+	assert.Equal(t, cur.BlockNumber, pos.BlockNumber)
+	assert.Equal(t, int64(0), pos.ChunkOffset)
+}
+
+func TestWAL_TempSegmentFiles(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-temp-segment")
+	opts := Options{
+		DirPath:          dir,
+		SegmentFileExt:   ".SEG",
+		SegmentSize:      MB,
+		TempSegmentFiles: true,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() { destroyWAL(wal) }()
+
+	exists := func(id SegmentID, ext string) bool {
+		_, err := os.Stat(SegmentFileName(dir, ".SEG"+ext, id))
+		return err == nil
+	}
+	assert.True(t, exists(1, tempSegmentExt))
+	assert.False(t, exists(1, ""))
+
+	// renamed by the first sync.
+	_, err = wal.Write([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Nil(t, wal.Sync())
+	assert.False(t, exists(1, tempSegmentExt))
+	assert.True(t, exists(1, ""))
+
+	// the new active segment file is temporary until it is synced or closed.
+	assert.Nil(t, wal.OpenNewActiveSegment())
+	assert.True(t, exists(2, tempSegmentExt))
+	pos, err := wal.Write([]byte("world"))
+	assert.Nil(t, err)
+	data, err := os.ReadFile(SegmentFileName(dir, ".SEG"+tempSegmentExt, 2))
+	assert.Nil(t, err)
+	assert.Nil(t, wal.Close())
+	assert.True(t, exists(2, ""))
+
+	// the temporary segment files left by a crash are recovered.
+	assert.Nil(t, os.WriteFile(SegmentFileName(dir, ".SEG"+tempSegmentExt, 3), data, 0644))
+	assert.Nil(t, os.WriteFile(SegmentFileName(dir, ".SEG"+tempSegmentExt, 4), nil, 0644))
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, SegmentID(3), wal.ActiveSegmentID())
+	assert.True(t, exists(3, ""))
+	assert.False(t, exists(4, tempSegmentExt))
+	pos.SegmentId = 3
+	val, err := wal.Read(pos)
+	assert.Nil(t, err)
+	assert.Equal(t, "world", string(val))
+
+	// ignored if the option is off.
+	assert.Nil(t, wal.Close())
+	assert.Nil(t, os.WriteFile(SegmentFileName(dir, ".SEG"+tempSegmentExt, 4), data, 0644))
+	opts.TempSegmentFiles = false
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, SegmentID(3), wal.ActiveSegmentID())
+	assert.Nil(t, os.Remove(SegmentFileName(dir, ".SEG"+tempSegmentExt, 4)))
+}
+
+func TestWAL_WriteWithEnd(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-write-with-end")
+	opts := DefaultOptions
+	opts.DirPath = dir
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	sizes := []int{10, 40 * KB, blockSize - 100, 90, 1, 100 * KB}
+	var ends []*ChunkPosition
+	for _, size := range sizes {
+		start, end, err := wal.WriteWithEnd(make([]byte, size))
+		assert.Nil(t, err)
+		assert.Equal(t, start.SegmentId, end.SegmentId)
+		assert.Equal(t, wal.CurrentPosition(), end)
+		ends = append(ends, end)
+	}
+
+	// the end position is the start of the next entry.
+	reader := wal.NewReader()
+	_, _, err = reader.Next()
+	assert.Nil(t, err)
+	for i := 0; i < len(sizes)-1; i++ {
+		_, pos, err := reader.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, ends[i].BlockNumber, pos.BlockNumber)
+		assert.Equal(t, ends[i].ChunkOffset, pos.ChunkOffset)
+	}
+}
+
+func TestWAL_WriteIf(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-write-if")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyWAL(wal)
+	}()
+
+	last, err := wal.LastPosition()
+	assert.Nil(t, err)
+	assert.Nil(t, last)
+	_, err = wal.WriteIf(&ChunkPosition{SegmentId: 1}, []byte("conflict"))
+	assert.Equal(t, ErrPositionConflict, err)
+
+	// two writers race from the same position, only the first one wins.
+	first, err := wal.WriteIf(nil, []byte("first"))
+	assert.Nil(t, err)
+	_, err = wal.WriteIf(nil, []byte("second"))
+	assert.Equal(t, ErrPositionConflict, err)
+	pos, err := wal.WriteIf(first, []byte("third"))
+	assert.Nil(t, err)
+	_, err = wal.WriteIf(first, []byte("fourth"))
+	assert.Equal(t, ErrPositionConflict, err)
+
+	// the markers are not entries.
+	_, err = wal.WriteMarker([]byte("marker"))
+	assert.Nil(t, err)
+	last, err = wal.LastPosition()
+	assert.Nil(t, err)
+	assert.Equal(t, pos.ChunkOffset, last.ChunkOffset)
+
+	// the last position is found by scanning the footerless active segment file after reopening.
+	assert.Nil(t, wal.Close())
+	wal, err = Open(opts)
+	assert.Nil(t, err)
+	last, err = wal.LastPosition()
+	assert.Nil(t, err)
+	assert.Equal(t, pos.SegmentId, last.SegmentId)
+	assert.Equal(t, pos.ChunkOffset, last.ChunkOffset)
+
+	// and from the footer of the sealed segment file if the active one is empty.
+	assert.Nil(t, wal.OpenNewActiveSegment())
+	pos, err = wal.WriteIf(last, []byte("fifth"))
+	assert.Nil(t, err)
+	assert.Equal(t, SegmentID(2), pos.SegmentId)
+	n, err := wal.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+}
+
+func TestWAL_ReadHeader(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-read-header")
+	opts := DefaultOptions
+	opts.DirPath = dir
+	opts.WriteBufferSize = 64 * KB
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	small, err := wal.Write([]byte("hello"))
+	assert.Nil(t, err)
+	large, err := wal.Write([]byte(strings.Repeat("X", 100*KB)))
+	assert.Nil(t, err)
+
+	// the buffered chunk is flushed to read its header.
+	chunkType, length, _, err := wal.ReadHeader(small)
+	assert.Nil(t, err)
+	assert.Equal(t, ChunkTypeFull, chunkType)
+	assert.Equal(t, uint16(5), length)
+
+	// walk through the chunks of the large entry.
+	var types []ChunkType
+	var total int
+	pos := &ChunkPosition{SegmentId: large.SegmentId, BlockNumber: large.BlockNumber, ChunkOffset: large.ChunkOffset}
+	for {
+		chunkType, length, _, err := wal.ReadHeader(pos)
+		assert.Nil(t, err)
+		types = append(types, chunkType)
+		total += int(length)
+		if chunkType == ChunkTypeLast {
+			pos.ChunkOffset += chunkHeaderSize + int64(length)
+			break
+		}
+		pos.BlockNumber++
+		pos.ChunkOffset = 0
+	}
+	assert.Equal(t, []ChunkType{ChunkTypeFirst, ChunkTypeMiddle, ChunkTypeMiddle, ChunkTypeLast}, types)
+	assert.Equal(t, 100*KB, total)
+
+	_, _, _, err = wal.ReadHeader(pos)
+	assert.Equal(t, io.EOF, err)
+	_, _, _, err = wal.ReadHeader(&ChunkPosition{SegmentId: 10})
+	assert.ErrorIs(t, err, ErrSegmentNotFound)
+}
+
+func TestWAL_ReadBatch(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-read-batch")
+	opts := Options{
+		DirPath:         dir,
+		SegmentFileExt:  ".SEG",
+		SegmentSize:     64 * KB,
+		WriteBufferSize: KB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	var positions []*ChunkPosition
+	for i := 0; i < 500; i++ {
+		pos, err := wal.Write([]byte(fmt.Sprintf("%d-%s", i, strings.Repeat("X", i))))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.True(t, wal.ActiveSegmentID() > 1)
+
+	var batch []*ChunkPosition
+	var expected [][]byte
+	for i := len(positions) - 1; i >= 0; i -= 7 {
+		batch = append(batch, positions[i])
+		expected = append(expected, []byte(fmt.Sprintf("%d-%s", i, strings.Repeat("X", i))))
+	}
+	results, err := wal.ReadBatch(batch)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, results)
+
+	_, err = wal.ReadBatch([]*ChunkPosition{positions[0], {SegmentId: 100}})
+	assert.ErrorIs(t, err, ErrSegmentNotFound)
+	assert.Contains(t, err.Error(), "index 1")
+}
+
+func TestWAL_SegmentIDs(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-segment-ids")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+		MaxSegments:    3,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	assert.Equal(t, []SegmentID{1}, wal.SegmentIDs())
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, wal.OpenNewActiveSegment())
+	}
+	// the segment files removed by retention are not listed.
+	assert.Equal(t, []SegmentID{3, 4, 5}, wal.SegmentIDs())
+}
+
+func TestWAL_NewReaderForSegment(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-reader-for-segment")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	for id := 1; id <= 3; id++ {
+		for i := 0; i < 3; i++ {
+			_, err := wal.Write([]byte(fmt.Sprintf("%d-%d", id, i)))
+			assert.Nil(t, err)
+		}
+		if id < 3 {
+			assert.Nil(t, wal.OpenNewActiveSegment())
+		}
+	}
+
+	for _, id := range []SegmentID{2, 3} {
+		reader, err := wal.NewReaderForSegment(id)
+		assert.Nil(t, err)
+		for i := 0; i < 3; i++ {
+			val, pos, err := reader.Next()
+			assert.Nil(t, err)
+			assert.Equal(t, id, pos.SegmentId)
+			assert.Equal(t, fmt.Sprintf("%d-%d", id, i), string(val))
+		}
+		_, _, err = reader.Next()
+		assert.Equal(t, io.EOF, err)
+	}
+
+	_, err = wal.NewReaderForSegment(4)
+	assert.True(t, errors.Is(err, ErrSegmentNotFound))
+}
+
+func TestWAL_SegmentPath(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-segment-path")
+	opts := DefaultOptions
+	opts.DirPath = dir
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	path := wal.SegmentPath(wal.ActiveSegmentID())
+	assert.Equal(t, SegmentFileName(dir, ".SEG", 1), path)
+	_, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, SegmentFileName(dir, ".SEG", 10), wal.SegmentPath(10))
+
+	opts.DirPath, _ = os.MkdirTemp("", "wal-test-segment-path-func")
+	opts.SegmentNameFunc = func(id SegmentID) string { return fmt.Sprintf("log-%d", id) }
+	opts.SegmentParseFunc = func(name string) (SegmentID, bool) {
+		var id SegmentID
+		_, err := fmt.Sscanf(name, "log-%d", &id)
+		return id, err == nil
+	}
+	named, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(named)
+	assert.Equal(t, filepath.Join(opts.DirPath, "log-1"), named.SegmentPath(1))
+	_, err = os.Stat(named.SegmentPath(1))
+	assert.Nil(t, err)
+}
+
+func TestWAL_NewReaderWithStart_WriteBuffer(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-reader-with-start-buffer")
+	opts := DefaultOptions
+	opts.DirPath = dir
+	opts.WriteBufferSize = 4 * KB
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	var positions []*ChunkPosition
+	for i := 0; i < 10; i++ {
+		pos, err := wal.Write([]byte(fmt.Sprintf("entry-%d", i)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	// the buffered entries are flushed without a deadlock.
+	reader, err := wal.NewReaderWithStart(positions[5])
+	assert.Nil(t, err)
+	val, _, err := reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "entry-5", string(val))
+}
+
+func TestWAL_IsActive(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-is-active")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    MB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	pos, err := wal.Write([]byte("sealed"))
+	assert.Nil(t, err)
+	assert.True(t, wal.IsActive(pos))
+
+	assert.Nil(t, wal.OpenNewActiveSegment())
+	assert.False(t, wal.IsActive(pos))
+	pos, err = wal.Write([]byte("active"))
+	assert.Nil(t, err)
+	assert.True(t, wal.IsActive(pos))
+}
+
+func TestWAL_NewSnapshotReader(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-snapshot-reader")
+	opts := Options{
+		DirPath:         dir,
+		SegmentFileExt:  ".SEG",
+		SegmentSize:     64 * KB,
+		WriteBufferSize: 4 * KB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	for i := 0; i < 500; i++ {
+		_, err := wal.Write([]byte(fmt.Sprintf("before-%d-%0200d", i, i)))
+		assert.Nil(t, err)
+	}
+	snapshot := wal.NewSnapshotReader()
+	reader := wal.NewReader()
+	// the data written after the readers are created, including the new segment files.
+	activeID := wal.ActiveSegmentID()
+	for i := 0; i < 500; i++ {
+		_, err := wal.Write([]byte(fmt.Sprintf("after-%d", i)))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, wal.OpenNewActiveSegment())
+	_, err = wal.Write([]byte("new segment"))
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		val, _, err := snapshot.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("before-%d-%0200d", i, i), string(val))
+	}
+	_, _, err = snapshot.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// the reader sees the data appended to the active segment file when it was created.
+	var count int
+	for {
+		_, pos, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		assert.True(t, pos.SegmentId <= activeID)
+		count++
+	}
+	assert.True(t, count > 500)
+}
+
+func TestReader_NextBatch(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-reader-next-batch")
+	opts := Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    32 * KB,
+	}
+	wal, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyWAL(wal)
+
+	var positions []*ChunkPosition
+	for i := 0; i < 10; i++ {
+		pos, err := wal.Write([]byte(strings.Repeat(strconv.Itoa(i), 10*KB)))
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.True(t, wal.ActiveSegmentID() > 1)
+
+	// the batches go across the segment files, and the last one is shorter.
+	reader := wal.NewReader()
+	var read int
+	for _, size := range []int{4, 4, 2} {
+		data, batchPositions, err := reader.NextBatch(4)
+		assert.Nil(t, err)
+		assert.Equal(t, size, len(data))
+		assert.Equal(t, size, len(batchPositions))
+		for j := range data {
+			assert.Equal(t, strings.Repeat(strconv.Itoa(read), 10*KB), string(data[j]))
+			assert.Equal(t, positions[read], batchPositions[j])
+			read++
+		}
+	}
+	_, _, err = reader.NextBatch(4)
+	assert.Equal(t, io.EOF, err)
+
+	// the batch stops at the limit like Next.
+	reader = wal.NewReader()
+	reader.SetLimit(3)
+	data, _, err := reader.NextBatch(5)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(data))
+	_, _, err = reader.NextBatch(5)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestWAL_ReadPooled(t *testing.T) {
+	for _, compression := range []CompressionType{CompressionNone, CompressionSnappy} {
+		dir, _ := os.MkdirTemp("", "wal-test-read-pooled")
+		opts := Options{
+			DirPath:        dir,
+			SegmentFileExt: ".SEG",
+			SegmentSize:    MB,
+			Compression:    compression,
+		}
+		wal, err := Open(opts)
+		assert.Nil(t, err)
+
+		var positions []*ChunkPosition
+		var values []string
+		for i := 0; i < 20; i++ {
+			value := strings.Repeat(strconv.Itoa(i), 100*(i+1))
+			if i == 10 {
+				// the entry of several blocks.
+				value = strings.Repeat("X", 100*KB)
+			}
+			pos, err := wal.Write([]byte(value))
+			assert.Nil(t, err)
+			positions = append(positions, pos)
+			values = append(values, value)
+		}
+
+		for i, pos := range positions {
+			data, release, err := wal.ReadPooled(pos)
+			assert.Nil(t, err)
+			assert.Equal(t, values[i], string(data))
+			release()
+			// the release function does nothing after the first call.
+			release()
+		}
+
+		_, release, err := wal.ReadPooled(&ChunkPosition{SegmentId: 100})
+		assert.ErrorIs(t, err, ErrSegmentNotFound)
+		assert.NotNil(t, release)
+		release()
+		destroyWAL(wal)
+	}
+}
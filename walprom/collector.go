@@ -0,0 +1,70 @@
+// Package walprom provides a Prometheus collector for the WAL.
+//
+// It is a separate module so that the users who don't need
+// the metrics are not forced to depend on Prometheus.
+package walprom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rosedblabs/wal"
+)
+
+const namespace = "wal"
+
+// collector exports the statistics of a WAL as Prometheus metrics.
+type collector struct {
+	wal *wal.WAL
+
+	writes       *prometheus.Desc
+	bytesWritten *prometheus.Desc
+	syncs        *prometheus.Desc
+	syncLatency  *prometheus.Desc
+	rotations    *prometheus.Desc
+}
+
+// NewCollector returns a prometheus.Collector exporting the statistics of the WAL.
+// The metrics are read from WAL.Stats() whenever the collector is scraped.
+func NewCollector(w *wal.WAL) prometheus.Collector {
+	return &collector{
+		wal: w,
+		writes: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "writes_total"),
+			"Number of entries written to the WAL.", nil, nil),
+		bytesWritten: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "written_bytes_total"),
+			"Number of bytes written to the segment files, including chunk headers and paddings.", nil, nil),
+		syncs: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "syncs_total"),
+			"Number of syncs of the active segment file.", nil, nil),
+		syncLatency: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "sync_duration_seconds"),
+			"Latency of syncing the active segment file.", nil, nil),
+		rotations: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "segment_rotations_total"),
+			"Number of times a new active segment file is created.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.writes
+	ch <- c.bytesWritten
+	ch <- c.syncs
+	ch <- c.syncLatency
+	ch <- c.rotations
+}
+
+// Collect implements prometheus.Collector.
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.wal.Stats()
+
+	ch <- prometheus.MustNewConstMetric(c.writes, prometheus.CounterValue, float64(stats.WriteCount))
+	ch <- prometheus.MustNewConstMetric(c.bytesWritten, prometheus.CounterValue, float64(stats.BytesWritten))
+	ch <- prometheus.MustNewConstMetric(c.syncs, prometheus.CounterValue, float64(stats.SyncCount))
+	ch <- prometheus.MustNewConstMetric(c.rotations, prometheus.CounterValue, float64(stats.RotationCount))
+
+	// the buckets of a Prometheus histogram are cumulative.
+	var cumulative uint64
+	buckets := make(map[float64]uint64)
+	for i, bound := range wal.SyncLatencyBounds() {
+		cumulative += stats.SyncLatencyBuckets[i]
+		buckets[bound.Seconds()] = cumulative
+	}
+	ch <- prometheus.MustNewConstHistogram(c.syncLatency,
+		stats.SyncCount, stats.SyncDuration.Seconds(), buckets)
+}
@@ -0,0 +1,57 @@
+package walprom
+
+import (
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rosedblabs/wal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-prometheus")
+	w, err := wal.Open(wal.Options{
+		DirPath:        dir,
+		SegmentFileExt: ".SEG",
+		SegmentSize:    wal.MB,
+	})
+	assert.Nil(t, err)
+	defer func() {
+		_ = w.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	for i := 0; i < 1000; i++ {
+		_, err := w.Write(make([]byte, 2048))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, w.Sync())
+
+	registry := prometheus.NewPedanticRegistry()
+	assert.Nil(t, registry.Register(NewCollector(w)))
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+
+	metrics := make(map[string]float64)
+	for _, family := range families {
+		metric := family.GetMetric()[0]
+		switch {
+		case metric.GetCounter() != nil:
+			metrics[family.GetName()] = metric.GetCounter().GetValue()
+		case metric.GetHistogram() != nil:
+			histogram := metric.GetHistogram()
+			metrics[family.GetName()] = float64(histogram.GetSampleCount())
+			assert.Equal(t, len(wal.SyncLatencyBounds()), len(histogram.GetBucket()))
+		}
+	}
+
+	stats := w.Stats()
+	assert.Equal(t, map[string]float64{
+		"wal_writes_total":            1000,
+		"wal_written_bytes_total":     float64(stats.BytesWritten),
+		"wal_syncs_total":             float64(stats.SyncCount),
+		"wal_sync_duration_seconds":   float64(stats.SyncCount),
+		"wal_segment_rotations_total": float64(stats.RotationCount),
+	}, metrics)
+}
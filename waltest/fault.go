@@ -0,0 +1,109 @@
+// Package waltest provides the helpers to test the applications using the WAL,
+// such as a file system injecting the faults into the segment files,
+// which reproduces the torn writes and the failed syncs deterministically.
+//
+// It is only for testing, never use it in production.
+package waltest
+
+import (
+	"os"
+
+	"github.com/rosedblabs/wal"
+)
+
+// FaultInjector decides the faults of the file operations done by the WAL,
+// every hook is called with the name of the file.
+type FaultInjector interface {
+	// BeforeWrite is called before p is written to the file.
+	// If it returns an error, only the first n bytes of p are written,
+	// which is a torn write if n > 0, and the error is returned by the write.
+	// The n is ignored if the error is nil, p is written entirely.
+	BeforeWrite(name string, p []byte) (n int, err error)
+
+	// AfterWrite is called after the write, n and err are returned by the underlying file.
+	// The error it returns replaces err, such as reporting a successful write as failed.
+	AfterWrite(name string, n int, err error) error
+
+	// BeforeSync is called before the file is synced,
+	// the file is not synced and the error is returned if it returns an error.
+	BeforeSync(name string) error
+}
+
+// FaultFuncs is a FaultInjector made up of the funcs, the nil ones inject no fault.
+type FaultFuncs struct {
+	BeforeWriteFunc func(name string, p []byte) (int, error)
+	AfterWriteFunc  func(name string, n int, err error) error
+	BeforeSyncFunc  func(name string) error
+}
+
+func (f FaultFuncs) BeforeWrite(name string, p []byte) (int, error) {
+	if f.BeforeWriteFunc == nil {
+		return 0, nil
+	}
+	return f.BeforeWriteFunc(name, p)
+}
+
+func (f FaultFuncs) AfterWrite(name string, n int, err error) error {
+	if f.AfterWriteFunc == nil {
+		return err
+	}
+	return f.AfterWriteFunc(name, n, err)
+}
+
+func (f FaultFuncs) BeforeSync(name string) error {
+	if f.BeforeSyncFunc == nil {
+		return nil
+	}
+	return f.BeforeSyncFunc(name)
+}
+
+// faultFileSystem is a wal.FileSystem injecting the faults into the opened files.
+type faultFileSystem struct {
+	wal.FileSystem
+	injector FaultInjector
+}
+
+// faultFile is a wal.File whose writes and syncs are decided by the injector.
+type faultFile struct {
+	wal.File
+	injector FaultInjector
+}
+
+// NewFileSystem returns a file system injecting the faults decided by the injector
+// into the files opened by fs, it is used as wal.Options.FileSystem.
+// If fs is nil, the operating system's file system is used.
+func NewFileSystem(fs wal.FileSystem, injector FaultInjector) wal.FileSystem {
+	if fs == nil {
+		fs = wal.OSFileSystem
+	}
+	return &faultFileSystem{FileSystem: fs, injector: injector}
+}
+
+func (fs *faultFileSystem) OpenFile(name string, flag int, perm os.FileMode) (wal.File, error) {
+	f, err := fs.FileSystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{File: f, injector: fs.injector}, nil
+}
+
+func (f *faultFile) Write(p []byte) (int, error) {
+	if n, err := f.injector.BeforeWrite(f.Name(), p); err != nil {
+		if n > len(p) {
+			n = len(p)
+		}
+		if n > 0 {
+			n, _ = f.File.Write(p[:n])
+		}
+		return n, err
+	}
+	n, err := f.File.Write(p)
+	return n, f.injector.AfterWrite(f.Name(), n, err)
+}
+
+func (f *faultFile) Sync() error {
+	if err := f.injector.BeforeSync(f.Name()); err != nil {
+		return err
+	}
+	return f.File.Sync()
+}
@@ -0,0 +1,94 @@
+package waltest
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rosedblabs/wal"
+	"github.com/stretchr/testify/assert"
+)
+
+var errInjected = errors.New("injected fault")
+
+func TestNewFileSystem(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-fault")
+	defer os.RemoveAll(dir)
+
+	var tornWrite, failSync bool
+	fs := NewFileSystem(nil, FaultFuncs{
+		BeforeWriteFunc: func(name string, p []byte) (int, error) {
+			if tornWrite {
+				return len(p) / 2, errInjected
+			}
+			return 0, nil
+		},
+		BeforeSyncFunc: func(name string) error {
+			if failSync {
+				return errInjected
+			}
+			return nil
+		},
+	})
+	opts := wal.DefaultOptions
+	opts.DirPath = dir
+	opts.FileSystem = fs
+	w, err := wal.Open(opts)
+	assert.Nil(t, err)
+
+	pos, err := w.Write([]byte("hello"))
+	assert.Nil(t, err)
+
+	// the torn data is truncated by the WAL.
+	tornWrite = true
+	_, err = w.Write([]byte(strings.Repeat("X", 100)))
+	assert.Equal(t, errInjected, err)
+	tornWrite = false
+
+	failSync = true
+	assert.Equal(t, errInjected, w.Sync())
+	failSync = false
+	assert.Nil(t, w.Sync())
+
+	last, err := w.Write([]byte("world"))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	w, err = wal.Open(opts)
+	assert.Nil(t, err)
+	defer w.Close()
+	val, err := w.Read(pos)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(val))
+	val, err = w.Read(last)
+	assert.Nil(t, err)
+	assert.Equal(t, "world", string(val))
+
+	reader := w.NewReader()
+	var count int
+	for {
+		if _, _, err := reader.Next(); err != nil {
+			break
+		}
+		count++
+	}
+	assert.Equal(t, 2, count)
+}
+
+func TestFaultFuncs_AfterWrite(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "wal-test-fault-after-write")
+	defer os.RemoveAll(dir)
+
+	f, err := NewFileSystem(nil, FaultFuncs{
+		AfterWriteFunc: func(name string, n int, err error) error {
+			return errInjected
+		},
+	}).OpenFile(dir+"/file", os.O_CREATE|os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	n, err := f.Write([]byte("data"))
+	assert.Equal(t, 4, n)
+	assert.Equal(t, errInjected, err)
+}